@@ -0,0 +1,222 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+var adjustmentPrefix = "ADJUSTMENT"                  //prefix for the key/value that stores a credit or debit note
+var adjustmentIndexName = "adjustment~billid~noteid" //composite index of the notes raised against a bill
+
+// Note types
+const (
+	CreditNote = "CREDIT"
+	DebitNote  = "DEBIT"
+)
+
+// Reason codes accepted on credit and debit notes
+var adjustmentReasons = []string{"PRICING_ERROR", "DUPLICATE", "RETURN", "SERVICE_ISSUE", "GOODWILL", "UNDERBILLED", "DISPUTE", "LATE_FEE", "INSTALLMENT_INTEREST", "OTHER"}
+
+// Adjustment is a credit or debit note raised against a bill. The bill's own
+// Amount never changes; its AdjustmentTotal carries the net of its notes.
+type Adjustment struct {
+	ID         string `json:"id"`
+	BillID     string `json:"billid"`
+	Type       string `json:"type"`
+	Amount     int    `json:"amount"`
+	ReasonCode string `json:"reasoncode"`
+	Memo       string `json:"memo"`
+	CreatedBy  string `json:"createdby"`
+	Timestamp  string `json:"tr_time"`
+}
+
+// adjustBill records a note against a bill and updates its effective and
+// outstanding amounts. A bill under dispute is adjusted only by the
+// resolution of the dispute, which clears the dispute first.
+func adjustBill(stub shim.ChaincodeStubInterface, bill Bill, note Adjustment, now time.Time) (Bill, error) {
+	if !bill.isOpen() {
+		return bill, fmt.Errorf("Bill %s is %s and can no longer be adjusted", bill.ID, bill.Status)
+	}
+	if bill.DisputeID != "" {
+		return bill, fmt.Errorf("Bill %s is under dispute %s and cannot be adjusted until it is resolved", bill.ID, bill.DisputeID)
+	}
+	if note.Amount <= 0 {
+		return bill, fmt.Errorf("Note amount must be positive")
+	}
+	if !containsString(adjustmentReasons, note.ReasonCode) {
+		return bill, fmt.Errorf("Unknown reason code %s, expecting one of %v", note.ReasonCode, adjustmentReasons)
+	}
+	existing, err := stub.GetState(adjustmentPrefix + note.ID)
+	if err != nil {
+		return bill, err
+	}
+	if existing != nil {
+		return bill, fmt.Errorf("Note already exists: %s", note.ID)
+	}
+
+	effective, err := bill.effectiveAmount()
+	if err != nil {
+		return bill, err
+	}
+	delta := note.Amount
+	if note.Type == CreditNote {
+		delta = -note.Amount
+		if effective+delta < bill.PaidAmount+bill.DiscountTaken {
+			return bill, fmt.Errorf("Credit of %d would bring bill %s below the %d already paid", note.Amount, bill.ID, bill.PaidAmount)
+		}
+	}
+
+	bill.AdjustmentTotal += delta
+	bill.EffectiveAmount = effective + delta
+	bill.Outstanding = bill.EffectiveAmount - bill.PaidAmount - bill.DiscountTaken
+	switch {
+	case bill.Outstanding > 0 && bill.PaidAmount > 0:
+		bill.Status = BillPartial
+	case bill.Outstanding > 0:
+		bill.Status = BillIssued
+	case bill.PaidAmount > 0:
+		bill.Status = BillPaid
+		if bill.PaidAt == "" {
+			bill.PaidAt = now.Format(time.RFC3339)
+		}
+	default:
+		bill.Status = BillCredited
+	}
+	if err := putBill(stub, bill); err != nil {
+		return bill, err
+	}
+
+	note.BillID = bill.ID
+	note.Timestamp = now.Format(time.RFC3339)
+	noteAsBytes, _ := json.Marshal(note)
+	if err := stub.PutState(adjustmentPrefix+note.ID, noteAsBytes); err != nil {
+		return bill, err
+	}
+	indexKey, err := stub.CreateCompositeKey(adjustmentIndexName, []string{bill.ID, note.ID})
+	if err != nil {
+		return bill, err
+	}
+	return bill, stub.PutState(indexKey, []byte{0x00})
+}
+
+// raiseNote is shared by createCreditNote and createDebitNote. Only the
+// bill's recipient may raise notes against it, and its notes may at most
+// double the bill: the net of all notes on a bill, late fees and interest
+// included, cannot exceed its original amount.
+func (t *SimpleChaincode) raiseNote(stub shim.ChaincodeStubInterface, args []string, noteType string) pb.Response {
+	if len(args) != 5 {
+		return shim.Error("Incorrect number of arguments. Expecting 5")
+	}
+
+	submitter, err := getParty(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	bill, err := getBill(stub, args[1])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if bill.RecipientID != submitter.ID {
+		return shim.Error(fmt.Sprintf("Only recipient %s can raise notes against bill %s", bill.RecipientID, bill.ID))
+	}
+	amount, err := strconv.Atoi(args[2])
+	if err != nil {
+		return shim.Error("Invalid note amount, expecting a positive integer value")
+	}
+	if noteType == DebitNote {
+		original, err := bill.amountDue()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if bill.AdjustmentTotal+amount > original {
+			return shim.Error(fmt.Sprintf("Debit note of %d would add more than the original amount %d to bill %s, %d already added", amount, original, bill.ID, bill.AdjustmentTotal))
+		}
+	}
+
+	now, err := txTime(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	note := Adjustment{ID: args[0], Type: noteType, Amount: amount, ReasonCode: args[3], Memo: args[4], CreatedBy: submitter.ID}
+	bill, err = adjustBill(stub, bill, note, now)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	billAsBytes, _ := json.Marshal(bill)
+	return shim.Success(billAsBytes)
+}
+
+// ==== createCreditNote =========================================
+// createCreditNote reduces what is owed on a bill.
+// 0         1       2         3              4
+// "noteid"  "id"    "amount"  "reasoncode"   "memo"
+// ===========================================================================================
+func (t *SimpleChaincode) createCreditNote(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	return t.raiseNote(stub, args, CreditNote)
+}
+
+// ==== createDebitNote =========================================
+// createDebitNote increases what is owed on a bill.
+// 0         1       2         3              4
+// "noteid"  "id"    "amount"  "reasoncode"   "memo"
+// ===========================================================================================
+func (t *SimpleChaincode) createDebitNote(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	return t.raiseNote(stub, args, DebitNote)
+}
+
+// ==== queryBillAdjustments =========================================
+// queryBillAdjustments returns a bill's original and effective amounts and every note raised against it.
+// 0
+// "id"
+// ===========================================================================================
+func (t *SimpleChaincode) queryBillAdjustments(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	bill, err := getBill(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(adjustmentIndexName, []string{bill.ID})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	notes := []Adjustment{}
+	for resultsIterator.HasNext() {
+		responseRange, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		_, compositeKeyParts, err := stub.SplitCompositeKey(responseRange.Key)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		noteAsBytes, err := stub.GetState(adjustmentPrefix + compositeKeyParts[1])
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		var note Adjustment
+		json.Unmarshal(noteAsBytes, &note)
+		notes = append(notes, note)
+	}
+
+	effective, _ := bill.effectiveAmount()
+	respAsBytes, _ := json.Marshal(struct {
+		BillID          string       `json:"billid"`
+		Amount          string       `json:"amount"`
+		EffectiveAmount int          `json:"effectiveamount"`
+		Status          string       `json:"status"`
+		Notes           []Adjustment `json:"notes"`
+	}{bill.ID, bill.Amount, effective, bill.Status, notes})
+	return shim.Success(respAsBytes)
+}
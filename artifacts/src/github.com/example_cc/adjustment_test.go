@@ -0,0 +1,121 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNotesAdjustWhatIsOwed(t *testing.T) {
+	s := newTestStub(t)
+	s.biller("acme")
+	s.register("alice", "Alice", "Able", KYCTier1)
+	s.issueBill("b1", "acme", "alice", 100, "2017-11-30")
+
+	s.mustFail("Only recipient acme can raise notes", "alice", "createCreditNote", "n1", "b1", "20", "GOODWILL", "")
+	s.mustFail("Unknown reason code", "acme", "createCreditNote", "n1", "b1", "20", "BECAUSE", "")
+	s.mustInvoke("acme", "createCreditNote", "n1", "b1", "20", "GOODWILL", "")
+	s.mustFail("Note already exists: n1", "acme", "createDebitNote", "n1", "b1", "5", "UNDERBILLED", "")
+	s.mustInvoke("acme", "createDebitNote", "n2", "b1", "5", "UNDERBILLED", "")
+	if bill := s.bill("b1"); bill.Amount != "100" || bill.EffectiveAmount != 85 || bill.Outstanding != 85 {
+		t.Fatalf("b1 is %s, %d after notes with %d outstanding, expected 100, 85 and 85", bill.Amount, bill.EffectiveAmount, bill.Outstanding)
+	}
+
+	// Paying the effective amount settles the bill
+	s.mustPay("p1", "alice", 85, `[{"billid":"b1","amount":85}]`)
+	if bill := s.bill("b1"); bill.Status != BillPaid {
+		t.Fatalf("b1 is %s, expected %s", bill.Status, BillPaid)
+	}
+}
+
+func TestCreditNotesStopAtWhatWasPaid(t *testing.T) {
+	s := newTestStub(t)
+	s.biller("acme")
+	s.register("alice", "Alice", "Able", KYCTier1)
+	s.issueBill("b1", "acme", "alice", 100, "2017-11-30")
+	s.issueBill("b2", "acme", "alice", 100, "2017-11-30")
+
+	s.mustPay("p1", "alice", 60, `[{"billid":"b1","amount":60}]`)
+	s.mustFail("Credit of 50 would bring bill b1 below the 60 already paid", "acme", "createCreditNote", "n1", "b1", "50", "PRICING_ERROR", "")
+	s.mustInvoke("acme", "createCreditNote", "n1", "b1", "40", "PRICING_ERROR", "")
+	if bill := s.bill("b1"); bill.Status != BillPaid || bill.Outstanding != 0 {
+		t.Fatalf("b1 is %s with %d outstanding, expected %s", bill.Status, bill.Outstanding, BillPaid)
+	}
+
+	// Crediting an unpaid bill in full leaves nothing to pay
+	s.mustInvoke("acme", "createCreditNote", "n2", "b2", "100", "DUPLICATE", "")
+	if bill := s.bill("b2"); bill.Status != BillCredited {
+		t.Fatalf("b2 is %s, expected %s", bill.Status, BillCredited)
+	}
+}
+
+func TestCreateBillRejectsExistingID(t *testing.T) {
+	s := newTestStub(t)
+	s.biller("acme")
+	s.register("alice", "Alice", "Able", "")
+	s.issueBill("b1", "acme", "alice", 100, "2017-11-30")
+
+	s.mustFail("Bill already exists: b1", "acme", "createBill", "b1", "INV-2", "acme", "alice", "", "", "2017-10-01", "2017-11-30", "2017-10-01T00:00:00Z", "services", "500", "USD", "")
+	if bill := s.bill("b1"); bill.Amount != "100" {
+		t.Fatalf("bill b1 was overwritten with amount %s", bill.Amount)
+	}
+	var index AllBills
+	s.get(billIndexStr, &index)
+	if len(index.Bills) != 1 {
+		t.Fatalf("bill index holds %d entries, expected 1", len(index.Bills))
+	}
+}
+
+func TestNotesRequireOpenBill(t *testing.T) {
+	s := newTestStub(t)
+	s.biller("acme")
+	s.register("alice", "Alice", "Able", KYCTier1)
+	s.fund("alice", 1000)
+	s.issueBill("b1", "acme", "alice", 100, "2017-11-30")
+
+	s.mustInvoke("acme", "createDebitNote", "n1", "b1", "20", "UNDERBILLED", "")
+	s.mustPay("p1", "alice", 120, "")
+	if bill := s.bill("b1"); bill.Status != BillPaid {
+		t.Fatalf("bill b1 is %s, expected %s", bill.Status, BillPaid)
+	}
+
+	// A debit note must not reopen a settled bill
+	s.mustFail("can no longer be adjusted", "acme", "createDebitNote", "n2", "b1", "30", "UNDERBILLED", "")
+	if bill := s.bill("b1"); bill.Status != BillPaid || bill.Outstanding != 0 {
+		t.Fatalf("bill b1 is %s with %d outstanding, expected %s with nothing outstanding", bill.Status, bill.Outstanding, BillPaid)
+	}
+}
+
+func TestNotesWaitForDisputeResolution(t *testing.T) {
+	s := newTestStub(t)
+	s.biller("acme")
+	s.register("alice", "Alice", "Able", KYCTier1)
+	s.issueBill("b1", "acme", "alice", 100, "2017-11-30")
+	evidence := strings.Repeat("ab", 32)
+	s.mustInvoke("alice", "openDispute", "d1", "b1", "NOT_RECEIVED", evidence, "")
+
+	s.mustFail("under dispute d1", "acme", "createDebitNote", "n1", "b1", "20", "UNDERBILLED", "")
+	s.mustFail("under dispute d1", "acme", "createCreditNote", "n2", "b1", "20", "GOODWILL", "")
+
+	// The resolution clears the dispute before it credits the bill
+	s.mustInvoke("acme", "resolveDispute", "d1", DisputeAdjust, "30", "AGREED", evidence, "")
+	s.mustInvoke("acme", "createDebitNote", "n1", "b1", "20", "UNDERBILLED", "")
+	if bill := s.bill("b1"); bill.Outstanding != 90 {
+		t.Fatalf("b1 has %d outstanding, expected 90", bill.Outstanding)
+	}
+}
+
+func TestDebitNotesAtMostDoubleBill(t *testing.T) {
+	s := newTestStub(t)
+	s.biller("acme")
+	s.register("alice", "Alice", "Able", KYCTier1)
+	s.issueBill("b1", "acme", "alice", 100, "2017-11-30")
+
+	s.mustFail("more than the original amount 100", "acme", "createDebitNote", "n1", "b1", "101", "UNDERBILLED", "")
+	s.mustInvoke("acme", "createDebitNote", "n1", "b1", "60", "UNDERBILLED", "")
+	s.mustFail("60 already added", "acme", "createDebitNote", "n2", "b1", "41", "UNDERBILLED", "")
+	s.mustInvoke("acme", "createCreditNote", "n3", "b1", "10", "GOODWILL", "")
+	s.mustInvoke("acme", "createDebitNote", "n4", "b1", "50", "UNDERBILLED", "")
+	if bill := s.bill("b1"); bill.Outstanding != 200 {
+		t.Fatalf("b1 has %d outstanding, expected 200", bill.Outstanding)
+	}
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+var allocationIndexName = "allocation~billid~paymentid" //composite key holding the part of a payment applied to a bill
+var allocationRuleIndexName = "allocationrule~userid"   //composite key holding a user's allocation rule
+
+// Rules for spreading a payment that carries no allocations over the user's open bills
+const (
+	AllocateOldestDueFirst = "OLDEST_DUE_FIRST"
+	AllocateNewestDueFirst = "NEWEST_DUE_FIRST"
+	AllocateSmallestFirst  = "SMALLEST_FIRST"
+)
+
+// Allocation is the part of a payment applied to one bill. Settlements made
+// by collectBill and autoPayDue use their transaction ID as the payment ID.
+type Allocation struct {
+	BillID    string `json:"billid"`
+	PaymentID string `json:"paymentid"`
+	Amount    int    `json:"amount"`
+	Discount  int    `json:"discount"` //early-payment discount granted on top of Amount
+	TxID      string `json:"txid"`
+	Timestamp string `json:"tr_time"`
+}
+
+func putAllocation(stub shim.ChaincodeStubInterface, allocation Allocation) error {
+	key, err := stub.CreateCompositeKey(allocationIndexName, []string{allocation.BillID, allocation.PaymentID})
+	if err != nil {
+		return err
+	}
+	allocationAsBytes, _ := json.Marshal(allocation)
+	return stub.PutState(key, allocationAsBytes)
+}
+
+// billsForUser returns the bills indexed under a user. The userid~id index is
+// shared with payments, so IDs without a bill behind them are skipped.
+func billsForUser(stub shim.ChaincodeStubInterface, userID string) ([]Bill, error) {
+	resultsIterator, err := stub.GetStateByPartialCompositeKey("userid~id", []string{userID})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	var bills []Bill
+	for resultsIterator.HasNext() {
+		responseRange, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		_, compositeKeyParts, err := stub.SplitCompositeKey(responseRange.Key)
+		if err != nil {
+			return nil, err
+		}
+		billAsBytes, err := stub.GetState(billPrefix + compositeKeyParts[1])
+		if err != nil {
+			return nil, err
+		}
+		if billAsBytes == nil {
+			continue
+		}
+		var bill Bill
+		if err := json.Unmarshal(billAsBytes, &bill); err != nil {
+			return nil, err
+		}
+		bills = append(bills, bill)
+	}
+	return bills, nil
+}
+
+func getAllocationRule(stub shim.ChaincodeStubInterface, userID string) (string, error) {
+	key, err := stub.CreateCompositeKey(allocationRuleIndexName, []string{userID})
+	if err != nil {
+		return "", err
+	}
+	rule, err := stub.GetState(key)
+	if err != nil {
+		return "", err
+	}
+	if rule == nil {
+		return AllocateOldestDueFirst, nil
+	}
+	return string(rule), nil
+}
+
+// billsByRule orders open bills the way an allocation rule pays them, breaking ties by bill ID
+type billsByRule struct {
+	bills []Bill
+	rule  string
+}
+
+func (s billsByRule) Len() int      { return len(s.bills) }
+func (s billsByRule) Swap(i, j int) { s.bills[i], s.bills[j] = s.bills[j], s.bills[i] }
+func (s billsByRule) Less(i, j int) bool {
+	a, b := s.bills[i], s.bills[j]
+	switch s.rule {
+	case AllocateNewestDueFirst:
+		if a.BillDueDate != b.BillDueDate {
+			return a.BillDueDate > b.BillDueDate
+		}
+	case AllocateSmallestFirst:
+		ao, _ := a.outstanding()
+		bo, _ := b.outstanding()
+		if ao != bo {
+			return ao < bo
+		}
+	default:
+		if a.BillDueDate != b.BillDueDate {
+			return a.BillDueDate < b.BillDueDate
+		}
+	}
+	return a.ID < b.ID
+}
+
+// allocatePayment applies the whole units of a payment's target amount to
+// the user's bills. Requested allocations are applied as given, to undisputed
+// bills of the user; without them the amount is spread over the user's open,
+// undisputed bills in the currency of the payment, using the user's
+// allocation rule. The recipient of every bill paid is screened. Whatever is
+// left is kept as unallocated.
+func allocatePayment(stub shim.ChaincodeStubInterface, pay *Payment, requested []Allocation) error {
+	now, err := txTime(stub)
+	if err != nil {
+		return err
+	}
+
+	var candidates []Bill
+	if len(requested) == 0 {
+		bills, err := billsForUser(stub, pay.UserID)
+		if err != nil {
+			return err
+		}
+		for _, bill := range bills {
+			if bill.isOpen() && bill.DisputeID == "" && bill.Currency == pay.TargetCurrency {
+				if _, err := bill.outstanding(); err == nil {
+					candidates = append(candidates, bill)
+				}
+			}
+		}
+		if len(candidates) == 0 {
+			return nil
+		}
+	}
+
+	// Bills are kept in whole units, so only the whole units of a decimal target amount are allocated
+	target, err := strconv.ParseFloat(pay.TargetAmount, 64)
+	if err != nil || target <= 0 {
+		return fmt.Errorf("Payment %s: target amount must be a positive value to allocate it to bills", pay.ID)
+	}
+	amount := int(math.Floor(target))
+	remaining := amount
+
+	// Allocations are keyed by bill and payment, so each bill may appear once
+	seen := map[string]bool{}
+	for _, req := range requested {
+		if seen[req.BillID] {
+			return fmt.Errorf("Bill %s is allocated more than once", req.BillID)
+		}
+		seen[req.BillID] = true
+	}
+	for _, req := range requested {
+		bill, err := getBill(stub, req.BillID)
+		if err != nil {
+			return err
+		}
+		if bill.UserID != pay.UserID {
+			return fmt.Errorf("Bill %s does not belong to user %s", bill.ID, pay.UserID)
+		}
+		if bill.Currency != pay.TargetCurrency {
+			return fmt.Errorf("Bill %s is in %s, payment is in %s", bill.ID, bill.Currency, pay.TargetCurrency)
+		}
+		if bill.DisputeID != "" {
+			return fmt.Errorf("Bill %s is under dispute %s", bill.ID, bill.DisputeID)
+		}
+		if req.Amount > remaining {
+			return fmt.Errorf("Allocations exceed the payment amount %d", amount)
+		}
+		if err := screenParties(stub, "createPayment", bill.RecipientID); err != nil {
+			return err
+		}
+		_, allocation, err := applyPayment(stub, bill, pay.ID, req.Amount, now)
+		if err != nil {
+			return err
+		}
+		remaining -= req.Amount
+		pay.DiscountTotal += allocation.Discount
+		pay.Allocations = append(pay.Allocations, allocation)
+	}
+
+	if len(candidates) > 0 {
+		rule, err := getAllocationRule(stub, pay.UserID)
+		if err != nil {
+			return err
+		}
+		sort.Sort(billsByRule{candidates, rule})
+		for _, bill := range candidates {
+			if remaining == 0 {
+				break
+			}
+			share, _ := bill.amountToSettle(now)
+			if share > remaining {
+				share = remaining
+			}
+			if err := screenParties(stub, "createPayment", bill.RecipientID); err != nil {
+				return err
+			}
+			_, allocation, err := applyPayment(stub, bill, pay.ID, share, now)
+			if err != nil {
+				return err
+			}
+			remaining -= share
+			pay.DiscountTotal += allocation.Discount
+			pay.Allocations = append(pay.Allocations, allocation)
+		}
+	}
+
+	pay.Unallocated = remaining
+	return nil
+}
+
+// ==== setAllocationRule =========================================
+// setAllocationRule chooses how the submitter's payments without allocations are spread over their bills.
+// 0
+// "OLDEST_DUE_FIRST" | "NEWEST_DUE_FIRST" | "SMALLEST_FIRST"
+// ===========================================================================================
+func (t *SimpleChaincode) setAllocationRule(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	rule := args[0]
+	if rule != AllocateOldestDueFirst && rule != AllocateNewestDueFirst && rule != AllocateSmallestFirst {
+		return shim.Error(fmt.Sprintf("Allocation rule must be one of '%s', '%s' or '%s'. But got: %v", AllocateOldestDueFirst, AllocateNewestDueFirst, AllocateSmallestFirst, rule))
+	}
+	submitter, err := getParty(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	key, err := stub.CreateCompositeKey(allocationRuleIndexName, []string{submitter.ID})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := stub.PutState(key, []byte(rule)); err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success([]byte(rule))
+}
+
+// ==== queryBillAllocations =========================================
+// queryBillAllocations lists every payment applied to a bill, with the bill's paid and outstanding amounts.
+// 0
+// "id"
+// ===========================================================================================
+func (t *SimpleChaincode) queryBillAllocations(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	bill, err := getBill(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(allocationIndexName, []string{bill.ID})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	allocations := []Allocation{}
+	for resultsIterator.HasNext() {
+		responseRange, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		var allocation Allocation
+		json.Unmarshal(responseRange.Value, &allocation)
+		allocations = append(allocations, allocation)
+	}
+
+	outstanding, _ := bill.outstanding()
+	respAsBytes, _ := json.Marshal(struct {
+		BillID      string       `json:"billid"`
+		Amount      string       `json:"amount"`
+		PaidAmount  int          `json:"paidamount"`
+		Outstanding int          `json:"outstanding"`
+		Status      string       `json:"status"`
+		Allocations []Allocation `json:"allocations"`
+	}{bill.ID, bill.Amount, bill.PaidAmount, outstanding, bill.Status, allocations})
+	return shim.Success(respAsBytes)
+}
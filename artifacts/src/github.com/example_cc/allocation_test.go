@@ -0,0 +1,166 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPaymentFollowsAllocationRule(t *testing.T) {
+	s := newTestStub(t)
+	s.biller("acme")
+	s.register("alice", "Alice", "Able", KYCTier1)
+	s.register("bob", "Bob", "Baker", KYCTier1)
+	s.issueBill("B1", "acme", "alice", 100, "2017-11-30")
+	s.issueBill("B2", "acme", "alice", 50, "2017-11-15")
+	s.issueBill("B3", "acme", "alice", 80, "2017-11-20")
+	s.issueBill("B4", "acme", "bob", 10, "2017-11-01")
+
+	// Oldest due first: B2, then B3 and part of B1
+	s.mustPay("p1", "alice", 150, "")
+	var pay Payment
+	s.get("PAYMENTp1", &pay)
+	if len(pay.Allocations) != 3 || pay.Allocations[0].BillID != "B2" || pay.Allocations[1].BillID != "B3" || pay.Allocations[2].Amount != 20 || pay.Unallocated != 0 {
+		t.Fatalf("p1 was allocated %+v with %d left, expected B2, B3 and 20 of B1", pay.Allocations, pay.Unallocated)
+	}
+	if bill := s.bill("B1"); bill.Status != BillPartial || bill.PaidAmount != 20 || bill.Outstanding != 80 {
+		t.Fatalf("B1 is %s with %d paid and %d outstanding, expected %s with 20 paid", bill.Status, bill.PaidAmount, bill.Outstanding, BillPartial)
+	}
+	if bill := s.bill("B2"); bill.Status != BillPaid {
+		t.Fatalf("B2 is %s, expected %s", bill.Status, BillPaid)
+	}
+
+	// What is left over after every open bill is paid stays unallocated
+	s.mustPay("p2", "alice", 100, "")
+	s.get("PAYMENTp2", &pay)
+	if len(pay.Allocations) != 1 || pay.Allocations[0].BillID != "B1" || pay.Unallocated != 20 {
+		t.Fatalf("p2 was allocated %+v with %d left, expected 80 to B1 and 20 left", pay.Allocations, pay.Unallocated)
+	}
+}
+
+func TestSmallestFirstAllocation(t *testing.T) {
+	s := newTestStub(t)
+	s.biller("acme")
+	s.register("alice", "Alice", "Able", KYCTier1)
+	s.issueBill("B1", "acme", "alice", 30, "2017-11-30")
+	s.issueBill("B2", "acme", "alice", 100, "2017-11-15")
+
+	s.mustFail("Allocation rule must be one of", "alice", "setAllocationRule", "LARGEST_FIRST")
+	s.mustInvoke("alice", "setAllocationRule", AllocateSmallestFirst)
+	s.mustPay("p1", "alice", 50, "")
+	if bill := s.bill("B1"); bill.Status != BillPaid {
+		t.Fatalf("B1 is %s, expected %s", bill.Status, BillPaid)
+	}
+	if bill := s.bill("B2"); bill.Outstanding != 80 {
+		t.Fatalf("B2 has %d outstanding, expected 80", bill.Outstanding)
+	}
+}
+
+func TestRequestedAllocations(t *testing.T) {
+	s := newTestStub(t)
+	s.biller("acme")
+	s.register("alice", "Alice", "Able", KYCTier1)
+	s.register("bob", "Bob", "Baker", KYCTier1)
+	s.issueBill("B1", "acme", "alice", 100, "2017-11-30")
+	s.issueBill("B2", "acme", "alice", 50, "2017-11-15")
+	s.issueBill("B3", "acme", "bob", 10, "2017-11-01")
+
+	if resp := s.pay("p1", "alice", 100, `[{"billid":"B3","amount":10}]`); resp.Message != "Bill B3 does not belong to user alice" {
+		t.Fatalf("payment to another user's bill returned %+v", resp)
+	}
+	if resp := s.pay("p1", "alice", 100, `[{"billid":"B1","amount":60},{"billid":"B2","amount":50}]`); resp.Message != "Allocations exceed the payment amount 100" {
+		t.Fatalf("over-allocated payment returned %+v", resp)
+	}
+	if resp := s.pay("p1", "alice", 100, `[{"billid":"B2","amount":60}]`); resp.Message != "Cannot apply 60 to bill B2 with 50 outstanding" {
+		t.Fatalf("overpaying B2 returned %+v", resp)
+	}
+
+	// Requested allocations are applied as given, leaving B2 alone
+	s.mustPay("p1", "alice", 100, `[{"billid":"B1","amount":60}]`)
+	var pay Payment
+	s.get("PAYMENTp1", &pay)
+	if pay.Unallocated != 40 {
+		t.Fatalf("p1 left %d unallocated, expected 40", pay.Unallocated)
+	}
+	if bill := s.bill("B2"); bill.PaidAmount != 0 {
+		t.Fatalf("B2 received %d, expected nothing", bill.PaidAmount)
+	}
+}
+
+func allocationSetup(t *testing.T) *testStub {
+	s := newTestStub(t)
+	s.biller("acme")
+	s.register("alice", "Alice", "Able", KYCTier1)
+	s.issueBill("B1", "acme", "alice", 100, "2017-11-10")
+	s.issueBill("B2", "acme", "alice", 100, "2017-11-20")
+	return s
+}
+
+func TestPaymentIDIsUsedOnce(t *testing.T) {
+	s := allocationSetup(t)
+	s.mustPay("P1", "alice", 40, `[{"billid":"B1","amount":40}]`)
+
+	resp := s.pay("P1", "alice", 40, `[{"billid":"B1","amount":40}]`)
+	if resp.Status == 200 || !strings.Contains(resp.Message, "Payment already exists") {
+		t.Fatalf("replayed payment was accepted: %+v", resp)
+	}
+	if bill := s.bill("B1"); bill.PaidAmount != 40 {
+		t.Fatalf("B1 paid amount is %d, expected 40", bill.PaidAmount)
+	}
+}
+
+func TestPaymentRejectsDuplicateBills(t *testing.T) {
+	s := allocationSetup(t)
+	resp := s.pay("P1", "alice", 60, `[{"billid":"B1","amount":30},{"billid":"B1","amount":30}]`)
+	if resp.Status == 200 || !strings.Contains(resp.Message, "allocated more than once") {
+		t.Fatalf("duplicate allocations were accepted: %+v", resp)
+	}
+	if bill := s.bill("B1"); bill.PaidAmount != 0 {
+		t.Fatalf("B1 paid amount is %d, expected 0", bill.PaidAmount)
+	}
+}
+
+func TestAutomaticAllocationSkipsDisputedBills(t *testing.T) {
+	s := allocationSetup(t)
+	s.mustInvoke("alice", "openDispute", "D1", "B1", "NOT_RECEIVED", strings.Repeat("ab", 32), "never delivered")
+
+	s.mustPay("P1", "alice", 50, "")
+	if bill := s.bill("B1"); bill.PaidAmount != 0 {
+		t.Fatalf("disputed bill B1 received %d", bill.PaidAmount)
+	}
+	if bill := s.bill("B2"); bill.PaidAmount != 50 || bill.Status != BillPartial {
+		t.Fatalf("B2 is %s with %d paid, expected PARTIAL with 50", bill.Status, bill.PaidAmount)
+	}
+}
+
+func TestRequestedAllocationRejectsDisputedBills(t *testing.T) {
+	s := allocationSetup(t)
+	s.mustInvoke("alice", "openDispute", "D1", "B1", "NOT_RECEIVED", strings.Repeat("ab", 32), "never delivered")
+
+	resp := s.pay("P1", "alice", 50, `[{"billid":"B1","amount":50}]`)
+	if resp.Status == 200 || !strings.Contains(resp.Message, "under dispute D1") {
+		t.Fatalf("payment settled disputed bill B1: %+v", resp)
+	}
+	if bill := s.bill("B1"); bill.PaidAmount != 0 {
+		t.Fatalf("disputed bill B1 received %d", bill.PaidAmount)
+	}
+}
+
+func TestOnlyTheUserRecordsItsPayments(t *testing.T) {
+	s := allocationSetup(t)
+	args := []string{"P1", "alice", "", "", "PROCESSED", "1", "0", "1", "100", "100", "USD", "USD", "", "2017-11-01T10:00:00Z", "2017-11-01T10:00:00Z"}
+
+	s.mustFail("Only alice can record its payments", "acme", "createPayment", args...)
+	s.mustFailFrom("is not the registered alice", "Org2MSP", "alice", "createPayment", args...)
+	if bill := s.bill("B1"); bill.PaidAmount != 0 {
+		t.Fatalf("B1 paid amount is %d, expected 0", bill.PaidAmount)
+	}
+}
+
+func TestDecimalPaymentAllocatesWholeUnits(t *testing.T) {
+	s := allocationSetup(t)
+	s.mustInvoke("alice", "createPayment", "P1", "alice", "", "", "PROCESSED", "1", "0", "1", "120.75", "120.75", "USD", "USD", "", "2017-11-01T10:00:00Z", "2017-11-01T10:00:00Z")
+
+	if b1, b2 := s.bill("B1"), s.bill("B2"); b1.PaidAmount != 100 || b2.PaidAmount != 20 {
+		t.Fatalf("B1 and B2 received %d and %d, expected 100 and 20", b1.PaidAmount, b2.PaidAmount)
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+var allowanceIndexName = "owner~spender" //composite key holding the allowance an owner granted a spender
+
+// Allowance lets Spender move up to Remaining units out of Owner's account until it expires
+type Allowance struct {
+	Owner     string `json:"owner"`
+	Spender   string `json:"spender"`
+	Remaining int    `json:"remaining"`
+	ExpiresAt string `json:"expires_at"`
+	UpdatedAt string `json:"updated_at"`
+}
+
+func allowanceKey(stub shim.ChaincodeStubInterface, owner, spender string) (string, error) {
+	return stub.CreateCompositeKey(allowanceIndexName, []string{owner, spender})
+}
+
+func getAllowance(stub shim.ChaincodeStubInterface, owner, spender string) (Allowance, error) {
+	var allowance Allowance
+	key, err := allowanceKey(stub, owner, spender)
+	if err != nil {
+		return allowance, err
+	}
+	allowanceAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return allowance, fmt.Errorf("Failed to get allowance of %s for %s", owner, spender)
+	}
+	if allowanceAsBytes == nil {
+		return allowance, fmt.Errorf("No allowance from %s to %s", owner, spender)
+	}
+	err = json.Unmarshal(allowanceAsBytes, &allowance)
+	return allowance, err
+}
+
+func putAllowance(stub shim.ChaincodeStubInterface, allowance Allowance) error {
+	key, err := allowanceKey(stub, allowance.Owner, allowance.Spender)
+	if err != nil {
+		return err
+	}
+	allowanceAsBytes, _ := json.Marshal(allowance)
+	return stub.PutState(key, allowanceAsBytes)
+}
+
+// ==== approve =========================================
+// approve lets a spender pull up to amount from the submitter's own account
+// until the expiry. Approving again replaces the previous allowance.
+// 0          1         2
+// "spender"  "amount"  "2017-11-20T00:00:00Z"
+// ===========================================================================================
+func (t *SimpleChaincode) approve(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 3")
+	}
+
+	submitter, err := getParty(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	spender := args[0]
+	amount, err := strconv.Atoi(args[1])
+	if err != nil || amount <= 0 {
+		return shim.Error("Invalid allowance amount, expecting a positive integer value")
+	}
+	expiry, err := time.Parse(time.RFC3339, args[2])
+	if err != nil {
+		return shim.Error("Invalid expiry, expecting an RFC3339 timestamp")
+	}
+	if spender == submitter.ID {
+		return shim.Error("An owner cannot approve itself as spender")
+	}
+	if _, err := getBalance(stub, submitter.ID); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	now, err := txTime(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if !expiry.After(now) {
+		return shim.Error("Expiry must be after the transaction time")
+	}
+
+	allowance := Allowance{Owner: submitter.ID, Spender: spender, Remaining: amount, ExpiresAt: expiry.UTC().Format(time.RFC3339), UpdatedAt: now.Format(time.RFC3339)}
+	if err := putAllowance(stub, allowance); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	allowanceAsBytes, _ := json.Marshal(allowance)
+	return shim.Success(allowanceAsBytes)
+}
+
+// ==== transferFrom =========================================
+// transferFrom moves units out of an owner's account on behalf of the
+// submitter, consuming the allowance the owner granted the submitter.
+// 0        1     2
+// "owner"  "to"  "amount"
+// ===========================================================================================
+func (t *SimpleChaincode) transferFrom(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 3")
+	}
+
+	submitter, err := getParty(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	owner, to := args[0], args[1]
+	amount, err := strconv.Atoi(args[2])
+	if err != nil || amount <= 0 {
+		return shim.Error("Invalid transaction amount, expecting a positive integer value")
+	}
+
+	allowance, err := getAllowance(stub, owner, submitter.ID)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	now, err := txTime(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	expiry, _ := time.Parse(time.RFC3339, allowance.ExpiresAt)
+	if !now.Before(expiry) {
+		return shim.Error(fmt.Sprintf("Allowance from %s to %s expired at %s", owner, submitter.ID, allowance.ExpiresAt))
+	}
+	if amount > allowance.Remaining {
+		return shim.Error(fmt.Sprintf("Amount %d exceeds remaining allowance %d", amount, allowance.Remaining))
+	}
+
+	if err := transfer(stub, "transferFrom", owner, to, amount); err != nil {
+		return shim.Error(err.Error())
+	}
+	allowance.Remaining -= amount
+	allowance.UpdatedAt = now.Format(time.RFC3339)
+	if err := putAllowance(stub, allowance); err != nil {
+		return shim.Error(err.Error())
+	}
+	if _, err := writeJournal(stub, "transferFrom", []TransferLeg{{From: owner, To: to, Amount: amount}}); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	allowanceAsBytes, _ := json.Marshal(allowance)
+	return shim.Success(allowanceAsBytes)
+}
+
+// ==== allowance =========================================
+// allowance returns what a spender may still pull from an owner's account.
+// 0        1
+// "owner"  "spender"
+// ===========================================================================================
+func (t *SimpleChaincode) allowance(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+
+	allowance, err := getAllowance(stub, args[0], args[1])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	now, err := txTime(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	expiry, _ := time.Parse(time.RFC3339, allowance.ExpiresAt)
+	if !now.Before(expiry) {
+		allowance.Remaining = 0
+	}
+
+	allowanceAsBytes, _ := json.Marshal(allowance)
+	return shim.Success(allowanceAsBytes)
+}
+
+// ==== revokeAllowance =========================================
+// revokeAllowance removes the allowance the submitter granted a spender.
+// 0
+// "spender"
+// ===========================================================================================
+func (t *SimpleChaincode) revokeAllowance(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	submitter, err := getParty(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if _, err := getAllowance(stub, submitter.ID, args[0]); err != nil {
+		return shim.Error(err.Error())
+	}
+	key, err := allowanceKey(stub, submitter.ID, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := stub.DelState(key); err != nil {
+		return shim.Error("Failed to delete state")
+	}
+
+	return shim.Success(nil)
+}
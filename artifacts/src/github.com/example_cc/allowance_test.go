@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestTransferFromConsumesAllowance(t *testing.T) {
+	s := newTestStub(t)
+	s.register("c", "Cy", "Cole", "")
+	s.fund("c", 0)
+	expiry := "2017-11-20T00:00:00Z"
+
+	s.mustFail("No allowance from a to b", "b", "transferFrom", "a", "c", "10")
+	s.mustInvoke("a", "approve", "b", "100", expiry)
+	s.mustInvoke("b", "transferFrom", "a", "c", "60")
+	s.expectBalance("a", 940)
+	s.expectBalance("c", 60)
+
+	s.mustFail("exceeds remaining allowance 40", "b", "transferFrom", "a", "c", "41")
+	var allowance Allowance
+	if err := json.Unmarshal(s.mustInvoke("c", "allowance", "a", "b"), &allowance); err != nil {
+		t.Fatal(err)
+	}
+	if allowance.Remaining != 40 {
+		t.Fatalf("remaining allowance is %d, expected 40", allowance.Remaining)
+	}
+
+	// The allowance is the owner's to give: the spender cannot pull for anyone else
+	s.mustFail("No allowance from a to c", "c", "transferFrom", "a", "c", "10")
+	s.expectBalance("a", 940)
+}
+
+func TestAllowanceExpiresAndCanBeRevoked(t *testing.T) {
+	s := newTestStub(t)
+	s.mustInvoke("a", "approve", "b", "100", "2017-11-20T00:00:00Z")
+	s.mustInvoke("a", "revokeAllowance", "b")
+	s.mustFail("No allowance from a to b", "b", "transferFrom", "a", "b", "10")
+	s.mustFail("No allowance from a to b", "a", "revokeAllowance", "b")
+
+	s.mustInvoke("a", "approve", "b", "100", "2017-11-20T00:00:00Z")
+	s.now = time.Date(2017, 11, 20, 0, 0, 0, 0, time.UTC)
+	s.mustFail("expired", "b", "transferFrom", "a", "b", "10")
+	s.expectBalance("a", 1000)
+}
+
+func TestTransferFromAppliesOwnerLimits(t *testing.T) {
+	s := newTestStub(t)
+	s.register("alice", "Alice", "Able", KYCTier0)
+	s.fund("alice", 1000)
+
+	// Approving another identity must not lift the owner's TIER0 limits
+	s.mustInvoke("alice", "approve", "b", "1000", "2017-11-20T00:00:00Z")
+	s.mustFail(LimitExceeded, "b", "transferFrom", "alice", "b", "101")
+	for i := 0; i < 5; i++ {
+		s.mustInvoke("b", "transferFrom", "alice", "b", "100")
+	}
+	s.mustFail(LimitExceeded, "b", "transferFrom", "alice", "b", "1")
+	s.expectBalance("alice", 500)
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+var billVersionIndexName = "billversion~id~version" //composite key holding a superseded version of a bill
+
+// billVersionKey zero-pads the version so range queries return versions in order
+func billVersionKey(stub shim.ChaincodeStubInterface, id string, version int) (string, error) {
+	return stub.CreateCompositeKey(billVersionIndexName, []string{id, fmt.Sprintf("%06d", version)})
+}
+
+// ==== amendBill =========================================
+// amendBill lets the bill's recipient correct the due date or description
+// of a bill nothing has been paid on. The current version is kept in the
+// bill's history and the bill's version number is incremented. Empty
+// values leave a field unchanged.
+// 0     1              2               3
+// "id"  "2017-11-30"   "description"   "reason"
+// ===========================================================================================
+func (t *SimpleChaincode) amendBill(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 4 {
+		return shim.Error("Incorrect number of arguments. Expecting 4")
+	}
+
+	submitter, err := getParty(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	bill, err := getBill(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if bill.RecipientID != submitter.ID {
+		return shim.Error(fmt.Sprintf("Only recipient %s can amend bill %s", bill.RecipientID, bill.ID))
+	}
+	if bill.PaidAmount > 0 || !bill.isOpen() {
+		return shim.Error(fmt.Sprintf("Bill %s can no longer be amended, it is %s", bill.ID, bill.Status))
+	}
+	if bill.DisputeID != "" {
+		return shim.Error(fmt.Sprintf("Bill %s is under dispute %s, resolve it instead", bill.ID, bill.DisputeID))
+	}
+	dueDate, description, reason := args[1], args[2], args[3]
+	if dueDate == "" && description == "" {
+		return shim.Error("Nothing to amend, expecting a new due date or description")
+	}
+	if dueDate != "" {
+		if _, err := time.Parse(dateLayout, dueDate); err != nil {
+			return shim.Error("Invalid due date, expecting YYYY-MM-DD")
+		}
+	}
+	if reason == "" {
+		return shim.Error("An amendment reason is required")
+	}
+
+	if bill.Version == 0 {
+		bill.Version = 1
+	}
+	previousKey, err := billVersionKey(stub, bill.ID, bill.Version)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	previousAsBytes, _ := json.Marshal(bill)
+	if err := stub.PutState(previousKey, previousAsBytes); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	now, err := txTime(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if dueDate != "" {
+		bill.BillDueDate = dueDate
+	}
+	if description != "" {
+		bill.Description = description
+	}
+	bill.Version++
+	bill.AmendReason = reason
+	bill.AmendedBy = submitter.ID
+	bill.AmendedAt = now.Format(time.RFC3339)
+	if err := putBill(stub, bill); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	billAsBytes, _ := json.Marshal(bill)
+	return shim.Success(billAsBytes)
+}
+
+// ==== queryBillVersions =========================================
+// queryBillVersions returns the current version of a bill and every earlier version, oldest first.
+// 0
+// "id"
+// ===========================================================================================
+func (t *SimpleChaincode) queryBillVersions(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	bill, err := getBill(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if bill.Version == 0 {
+		bill.Version = 1
+	}
+
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(billVersionIndexName, []string{bill.ID})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	versions := []Bill{}
+	for resultsIterator.HasNext() {
+		responseRange, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		var version Bill
+		json.Unmarshal(responseRange.Value, &version)
+		versions = append(versions, version)
+	}
+
+	respAsBytes, _ := json.Marshal(struct {
+		Current  Bill   `json:"current"`
+		Previous []Bill `json:"previous"`
+	}{bill, versions})
+	return shim.Success(respAsBytes)
+}
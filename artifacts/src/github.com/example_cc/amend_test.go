@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestAmendBillKeepsVersions(t *testing.T) {
+	s := newTestStub(t)
+	s.biller("acme")
+	s.register("alice", "Alice", "Able", KYCTier1)
+	s.issueBill("B1", "acme", "alice", 100, "2017-11-30")
+
+	s.mustFail("Only recipient acme can amend", "alice", "amendBill", "B1", "2017-12-15", "", "late delivery")
+	s.mustFail("An amendment reason is required", "acme", "amendBill", "B1", "2017-12-15", "", "")
+	s.mustFail("Nothing to amend", "acme", "amendBill", "B1", "", "", "late delivery")
+	s.mustInvoke("acme", "amendBill", "B1", "2017-12-15", "", "late delivery")
+	s.mustInvoke("acme", "amendBill", "B1", "", "consulting", "wrong description")
+
+	var versions struct {
+		Current  Bill   `json:"current"`
+		Previous []Bill `json:"previous"`
+	}
+	if err := json.Unmarshal(s.mustInvoke("alice", "queryBillVersions", "B1"), &versions); err != nil {
+		t.Fatal(err)
+	}
+	current := versions.Current
+	if current.Version != 3 || current.BillDueDate != "2017-12-15" || current.Description != "consulting" || current.AmendReason != "wrong description" || current.AmendedBy != "acme" {
+		t.Fatalf("current version is %+v", current)
+	}
+	if len(versions.Previous) != 2 {
+		t.Fatalf("%d earlier versions, expected 2", len(versions.Previous))
+	}
+	first, second := versions.Previous[0], versions.Previous[1]
+	if first.Version != 1 || first.BillDueDate != "2017-11-30" || first.Description != "services" {
+		t.Fatalf("version 1 is %+v", first)
+	}
+	if second.Version != 2 || second.BillDueDate != "2017-12-15" || second.Description != "services" {
+		t.Fatalf("version 2 is %+v", second)
+	}
+}
+
+func TestPaidBillsCannotBeAmended(t *testing.T) {
+	s := newTestStub(t)
+	s.biller("acme")
+	s.register("alice", "Alice", "Able", KYCTier1)
+	s.issueBill("B1", "acme", "alice", 100, "2017-11-30")
+
+	s.mustPay("p1", "alice", 40, `[{"billid":"B1","amount":40}]`)
+	s.mustFail("Bill B1 can no longer be amended, it is PARTIAL", "acme", "amendBill", "B1", "2017-12-15", "", "late delivery")
+	if bill := s.bill("B1"); bill.Version != 1 || bill.BillDueDate != "2017-11-30" {
+		t.Fatalf("B1 is version %d due %s, expected it unchanged", bill.Version, bill.BillDueDate)
+	}
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+var autoPayIndexName = "autopay~userid" //composite key holding a user's auto-pay settings
+
+// Auto-pay outcomes reported per bill by autoPayDue
+const (
+	AutoPayPaid    = "PAID"
+	AutoPaySkipped = "SKIPPED"
+)
+
+// AutoPaySetting tells autoPayDue to settle a user's due bills from FundingAccount.
+// An empty Recipients list includes every recipient.
+type AutoPaySetting struct {
+	UserID         string   `json:"userid"`
+	FundingAccount string   `json:"fundingaccount"`
+	MaxAmount      int      `json:"maxamount"`
+	Recipients     []string `json:"recipients"`
+	Enabled        bool     `json:"enabled"`
+	UpdatedAt      string   `json:"updated_at"`
+}
+
+// AutoPayOutcome reports what autoPayDue did with one bill
+type AutoPayOutcome struct {
+	BillID string `json:"billid"`
+	UserID string `json:"userid"`
+	Amount string `json:"amount"`
+	Status string `json:"status"`
+	Reason string `json:"reason"`
+}
+
+// covers reports whether the setting allows paying the bill automatically
+func (s AutoPaySetting) covers(bill Bill, amount int) (bool, string) {
+	if !s.Enabled {
+		return false, "auto-pay disabled"
+	}
+	if len(s.Recipients) > 0 && !containsString(s.Recipients, bill.RecipientID) {
+		return false, "recipient not included in auto-pay"
+	}
+	if amount > s.MaxAmount {
+		return false, fmt.Sprintf("amount %d exceeds auto-pay maximum %d", amount, s.MaxAmount)
+	}
+	return true, ""
+}
+
+func getAutoPaySetting(stub shim.ChaincodeStubInterface, userID string) (AutoPaySetting, bool, error) {
+	var setting AutoPaySetting
+	key, err := stub.CreateCompositeKey(autoPayIndexName, []string{userID})
+	if err != nil {
+		return setting, false, err
+	}
+	settingAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return setting, false, fmt.Errorf("Failed to get auto-pay settings of %s", userID)
+	}
+	if settingAsBytes == nil {
+		return setting, false, nil
+	}
+	err = json.Unmarshal(settingAsBytes, &setting)
+	return setting, true, err
+}
+
+func putAutoPaySetting(stub shim.ChaincodeStubInterface, setting AutoPaySetting) error {
+	key, err := stub.CreateCompositeKey(autoPayIndexName, []string{setting.UserID})
+	if err != nil {
+		return err
+	}
+	settingAsBytes, _ := json.Marshal(setting)
+	return stub.PutState(key, settingAsBytes)
+}
+
+// ==== setAutoPay =========================================
+// setAutoPay enables auto-pay of the submitter's bills from the submitter's
+// own account. recipients may be an empty list to include every recipient.
+// 0                 1            2
+// "fundingaccount"  "maxamount"  '["recipient1","recipient2"]'
+// ===========================================================================================
+func (t *SimpleChaincode) setAutoPay(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 3")
+	}
+
+	submitter, err := getParty(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	fundingAccount := args[0]
+	maxAmount, err := strconv.Atoi(args[1])
+	if err != nil || maxAmount <= 0 {
+		return shim.Error("Invalid maximum amount, expecting a positive integer value")
+	}
+	recipients := []string{}
+	if err := json.Unmarshal([]byte(args[2]), &recipients); err != nil {
+		return shim.Error("Invalid recipients, expecting a JSON list of recipient IDs")
+	}
+	if fundingAccount != submitter.ID {
+		return shim.Error(fmt.Sprintf("%s can only fund auto-pay from its own account", submitter.ID))
+	}
+	if _, err := getBalance(stub, fundingAccount); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	now, err := txTime(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	setting := AutoPaySetting{UserID: submitter.ID, FundingAccount: fundingAccount, MaxAmount: maxAmount, Recipients: recipients, Enabled: true, UpdatedAt: now.Format(time.RFC3339)}
+	if err := putAutoPaySetting(stub, setting); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	settingAsBytes, _ := json.Marshal(setting)
+	return shim.Success(settingAsBytes)
+}
+
+// ==== disableAutoPay =========================================
+// disableAutoPay turns off auto-pay for the submitter, keeping the settings for later.
+// ===========================================================================================
+func (t *SimpleChaincode) disableAutoPay(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 0 {
+		return shim.Error("Incorrect number of arguments. Expecting 0")
+	}
+
+	submitter, err := getParty(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	setting, found, err := getAutoPaySetting(stub, submitter.ID)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if !found {
+		return shim.Error("No auto-pay settings for " + submitter.ID)
+	}
+
+	now, err := txTime(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	setting.Enabled = false
+	setting.UpdatedAt = now.Format(time.RFC3339)
+	if err := putAutoPaySetting(stub, setting); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	settingAsBytes, _ := json.Marshal(setting)
+	return shim.Success(settingAsBytes)
+}
+
+// ==== queryAutoPay =========================================
+// 0
+// "userid"
+// ===========================================================================================
+func (t *SimpleChaincode) queryAutoPay(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	setting, found, err := getAutoPaySetting(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if !found {
+		return shim.Error("No auto-pay settings for " + args[0])
+	}
+
+	settingAsBytes, _ := json.Marshal(setting)
+	return shim.Success(settingAsBytes)
+}
+
+// ==== autoPayDue =========================================
+// autoPayDue settles every issued bill due on or before the given date whose
+// user has auto-pay enabled. Bills that fail the auto-pay rules or the funds
+// check are skipped, and the outcome of every bill considered is returned.
+// 0
+// "2017-11-20"
+// ===========================================================================================
+func (t *SimpleChaincode) autoPayDue(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	logger.Info("########### autoPayDue ###########")
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	dueDate, err := time.Parse(dateLayout, args[0])
+	if err != nil {
+		return shim.Error("Invalid date, expecting YYYY-MM-DD")
+	}
+	now, err := txTime(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if dueDate.After(now) {
+		return shim.Error("Cannot auto-pay bills due after the transaction date")
+	}
+
+	indexAsBytes, err := stub.GetState(billIndexStr)
+	if err != nil {
+		return shim.Error("Failed to get bill index")
+	}
+	var bills AllBills
+	json.Unmarshal(indexAsBytes, &bills)
+
+	outcomes := []AutoPayOutcome{}
+	var legs []TransferLeg
+	for _, indexed := range bills.Bills {
+		if !indexed.isOpen() || indexed.BillDueDate == "" || indexed.BillDueDate > args[0] {
+			continue
+		}
+		setting, found, err := getAutoPaySetting(stub, indexed.UserID)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if !found {
+			continue
+		}
+
+		bill, err := getBill(stub, indexed.ID)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		outcome := AutoPayOutcome{BillID: bill.ID, UserID: bill.UserID, Amount: bill.Amount, Status: AutoPaySkipped}
+		amount, err := bill.amountToSettle(now)
+		if err != nil {
+			outcome.Reason = err.Error()
+			outcomes = append(outcomes, outcome)
+			continue
+		}
+		if ok, reason := setting.covers(bill, amount); !ok {
+			outcome.Reason = reason
+			outcomes = append(outcomes, outcome)
+			continue
+		}
+		if _, err := settleBill(stub, bill, setting.FundingAccount, now); err != nil {
+			outcome.Reason = err.Error()
+			outcomes = append(outcomes, outcome)
+			continue
+		}
+		payout, err := payoutAccount(stub, bill.RecipientID)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		outcome.Status = AutoPayPaid
+		outcomes = append(outcomes, outcome)
+		legs = append(legs, TransferLeg{From: setting.FundingAccount, To: payout, Amount: amount})
+	}
+
+	if len(legs) > 0 {
+		if _, err := writeJournal(stub, "autoPayDue", legs); err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+	logger.Infof("autoPayDue paid %d of %d bills\n", len(legs), len(outcomes))
+
+	outcomesAsBytes, _ := json.Marshal(outcomes)
+	return shim.Success(outcomesAsBytes)
+}
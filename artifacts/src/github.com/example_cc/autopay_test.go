@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestSetAutoPayRequiresOwnAccount(t *testing.T) {
+	s := newTestStub(t)
+	s.register("alice", "Alice", "Able", "")
+	s.register("mallory", "Mal", "Lory", "")
+	s.fund("alice", 1000)
+
+	// Funding auto-pay from someone else's account would let bills drain it
+	s.mustFail("can only fund auto-pay from its own account", "mallory", "setAutoPay", "alice", "500", "[]")
+	s.mustInvoke("alice", "setAutoPay", "alice", "500", "[]")
+}
+
+func TestAutoPaySkipLeavesNoWrites(t *testing.T) {
+	s := newTestStub(t)
+	s.grant(RoleCompliance, "officer")
+	s.biller("acme")
+	s.register("alice", "Alice", "Able", KYCTier1)
+	s.fund("alice", 50)
+	s.mustInvoke("alice", "setAutoPay", "alice", "500", "[]")
+	s.issueBill("B1", "acme", "alice", 80, "2017-11-01")
+	// A fuzzy match would be flagged if the payment went ahead
+	s.mustInvoke("officer", "addSanctionsEntry", "SDN-1", "Alise", "Able", SanctionFlag, "OFAC")
+
+	var outcomes []AutoPayOutcome
+	if err := json.Unmarshal(s.mustInvoke("keeper", "autoPayDue", "2017-11-01"), &outcomes); err != nil {
+		t.Fatal(err)
+	}
+	if len(outcomes) != 1 || outcomes[0].Status != AutoPaySkipped {
+		t.Fatalf("expected the bill to be skipped, got %+v", outcomes)
+	}
+	if s.State[s.compositeKey(kycUsageIndexName, "alice")] != nil {
+		t.Fatal("a skipped bill recorded KYC usage")
+	}
+	var flags []ScreeningFlag
+	if err := json.Unmarshal(s.mustInvoke("officer", "queryScreeningFlags"), &flags); err != nil {
+		t.Fatal(err)
+	}
+	if len(flags) != 0 {
+		t.Fatalf("a skipped bill recorded screening flags: %+v", flags)
+	}
+
+	s.fund("alice", 500)
+	if err := json.Unmarshal(s.mustInvoke("keeper", "autoPayDue", "2017-11-01"), &outcomes); err != nil {
+		t.Fatal(err)
+	}
+	if len(outcomes) != 1 || outcomes[0].Status != AutoPayPaid {
+		t.Fatalf("expected the bill to be paid, got %+v", outcomes)
+	}
+	s.expectBalance("alice", 420)
+	s.expectBalance("acme", 80)
+	var usage KYCUsage
+	s.get(s.compositeKey(kycUsageIndexName, "alice"), &usage)
+	if usage.DayTotal != 80 {
+		t.Fatalf("KYC usage is %d, expected 80", usage.DayTotal)
+	}
+}
+
+func TestAutoPayDueSettlesCoveredBills(t *testing.T) {
+	s := newTestStub(t)
+	s.biller("acme")
+	s.biller("b")
+	s.register("alice", "Alice", "Able", KYCTier1)
+	s.register("bob", "Bob", "Baker", KYCTier1)
+	s.fund("alice", 1000)
+	s.mustInvoke("alice", "setAutoPay", "alice", "500", `["acme"]`)
+	s.issueBill("B1", "acme", "alice", 80, "2017-11-01")
+	s.issueBill("B2", "acme", "alice", 600, "2017-11-01")
+	s.issueBill("B3", "acme", "alice", 80, "2017-11-30")
+	s.issueBill("B4", "b", "alice", 80, "2017-11-01")
+	s.issueBill("B5", "acme", "bob", 80, "2017-11-01")
+
+	s.mustFail("Cannot auto-pay bills due after the transaction date", "keeper", "autoPayDue", "2017-11-30")
+	var outcomes []AutoPayOutcome
+	if err := json.Unmarshal(s.mustInvoke("keeper", "autoPayDue", "2017-11-01"), &outcomes); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"B1": AutoPayPaid, "B2": AutoPaySkipped, "B4": AutoPaySkipped}
+	if len(outcomes) != len(want) {
+		t.Fatalf("autoPayDue considered %+v, expected B1, B2 and B4", outcomes)
+	}
+	for _, outcome := range outcomes {
+		if want[outcome.BillID] != outcome.Status {
+			t.Fatalf("bill %s was %s (%s), expected %s", outcome.BillID, outcome.Status, outcome.Reason, want[outcome.BillID])
+		}
+	}
+	s.expectBalance("alice", 920)
+	s.expectBalance("acme", 80)
+	if bill := s.bill("B1"); bill.Status != BillPaid {
+		t.Fatalf("B1 is %s, expected %s", bill.Status, BillPaid)
+	}
+
+	// Paid bills are not paid again, and disabled settings pay nothing
+	s.mustInvoke("alice", "disableAutoPay")
+	if err := json.Unmarshal(s.mustInvoke("keeper", "autoPayDue", "2017-11-01"), &outcomes); err != nil {
+		t.Fatal(err)
+	}
+	for _, outcome := range outcomes {
+		if outcome.Status != AutoPaySkipped || outcome.Reason != "auto-pay disabled" {
+			t.Fatalf("bill %s was %s (%s) with auto-pay disabled", outcome.BillID, outcome.Status, outcome.Reason)
+		}
+	}
+	s.expectBalance("alice", 920)
+}
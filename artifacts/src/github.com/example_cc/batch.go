@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// ==== batchMove =========================================
+// batchMove applies a list of A->B legs atomically. Legs touching the same
+// account are netted first, so an account may spend what it receives earlier
+// in the batch. Every account left with a net debit must be the submitter's.
+// Either every leg is applied or none is.
+//   0
+// '[{"from":"a","to":"b","amount":10},{"from":"b","to":"c","amount":5}]'
+// ===========================================================================================
+func (t *SimpleChaincode) batchMove(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1, a JSON list of legs")
+	}
+
+	var legs []TransferLeg
+	if err := json.Unmarshal([]byte(args[0]), &legs); err != nil {
+		return shim.Error("Invalid legs, expecting a JSON list of {from, to, amount}")
+	}
+	if len(legs) == 0 {
+		return shim.Error("Batch must contain at least one leg")
+	}
+
+	net := map[string]int{}
+	for i, leg := range legs {
+		if leg.From == "" || leg.To == "" {
+			return shim.Error(fmt.Sprintf("Leg %d: from and to are required", i))
+		}
+		if leg.From == leg.To {
+			return shim.Error(fmt.Sprintf("Leg %d: from and to must differ", i))
+		}
+		if leg.Amount <= 0 {
+			return shim.Error(fmt.Sprintf("Leg %d: amount must be positive", i))
+		}
+		net[leg.From] -= leg.Amount
+		net[leg.To] += leg.Amount
+	}
+
+	// Visit accounts in a fixed order so every endorser writes the same read/write set
+	accounts := make([]string, 0, len(net))
+	for name := range net {
+		accounts = append(accounts, name)
+	}
+	sort.Strings(accounts)
+
+	// Accounts may receive from anyone, but only the submitter's own account may end the batch with less
+	submitter, err := getParty(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	for _, name := range accounts {
+		if net[name] < 0 && name != submitter.ID {
+			return shim.Error(fmt.Sprintf("Only %s can debit its account, the batch takes %d from it", name, -net[name]))
+		}
+	}
+
+	balances := map[string]int{}
+	for _, name := range accounts {
+		val, available, err := availableBalance(stub, name)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if net[name] < 0 && available+net[name] < 0 {
+			return shim.Error(fmt.Sprintf("Insufficient available funds in %s: available %d, net debit %d", name, available, -net[name]))
+		}
+		balances[name] = val + net[name]
+	}
+
+	now, err := txTime(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	// Sanctions screening of every party, and KYC tier limits of every net debit
+	if err := screenParties(stub, "batchMove", accounts...); err != nil {
+		return shim.Error(err.Error())
+	}
+	for _, name := range accounts {
+		if net[name] < 0 {
+			if err := enforceLimits(stub, name, -net[name], now); err != nil {
+				return shim.Error(err.Error())
+			}
+		}
+	}
+	// Velocity and anomaly rules, leg by leg. The batch is applied as a whole,
+	// so a leg that would be held for review rejects it.
+	var alerts []VelocityAlert
+	for i, leg := range legs {
+		alert, err := checkVelocity(stub, "batchMove", leg.From, leg.To, leg.Amount, now)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if alert == nil {
+			continue
+		}
+		if alert.Action == VelocityHold {
+			return shim.Error(fmt.Sprintf("%s: leg %d matched rules %v, move it on its own to have it held for review", VelocityHeld, i, alert.RuleIDs))
+		}
+		alerts = append(alerts, *alert)
+	}
+	if len(alerts) > 0 {
+		if err := raiseAlerts(stub, alerts); err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	for _, name := range accounts {
+		if err := putBalance(stub, name, balances[name]); err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	entry, err := writeJournal(stub, "batchMove", legs)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	logger.Infof("batchMove applied %d legs across %d accounts\n", len(legs), len(accounts))
+
+	entryAsBytes, _ := json.Marshal(entry)
+	return shim.Success(entryAsBytes)
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+func TestBatchMoveNetsLegs(t *testing.T) {
+	s := newTestStub(t)
+	s.register("c", "Cy", "Cole", "")
+	s.fund("c", 0)
+
+	// b passes on more than it holds out of what a pays it in the same batch,
+	// so only a ends the batch with less
+	s.fund("b", 40)
+	s.mustInvoke("a", "batchMove", `[{"from":"a","to":"b","amount":60},{"from":"b","to":"c","amount":50}]`)
+	s.expectBalance("a", 940)
+	s.expectBalance("b", 50)
+	s.expectBalance("c", 50)
+
+	s.mustFail("Insufficient available funds in c", "c", "batchMove", `[{"from":"c","to":"a","amount":51}]`)
+	s.expectBalance("c", 50)
+}
+
+func TestBatchMoveRequiresDebitedOwner(t *testing.T) {
+	s := newTestStub(t)
+	s.register("c", "Cy", "Cole", "")
+	s.fund("c", 0)
+
+	s.mustFail("Only b can debit its account", "a", "batchMove", `[{"from":"a","to":"b","amount":10},{"from":"b","to":"c","amount":30}]`)
+	s.mustFail("Only b can debit its account", "c", "batchMove", `[{"from":"b","to":"c","amount":30}]`)
+	s.expectBalance("b", 1000)
+	s.expectBalance("c", 0)
+}
+
+func TestBatchMoveIsAtomic(t *testing.T) {
+	s := newTestStub(t)
+
+	s.mustFail("Leg 1: amount must be positive", "a", "batchMove", `[{"from":"a","to":"b","amount":10},{"from":"b","to":"a","amount":0}]`)
+	s.mustFail("Entity not found: c", "a", "batchMove", `[{"from":"a","to":"b","amount":10},{"from":"b","to":"c","amount":5}]`)
+	s.expectBalance("a", 1000)
+	s.expectBalance("b", 1000)
+
+	var entry JournalEntry
+	s.mustInvoke("a", "batchMove", `[{"from":"a","to":"b","amount":10},{"from":"b","to":"a","amount":5}]`)
+	s.get(journalPrefix+fmt.Sprintf("tx%04d", s.txs), &entry)
+	if entry.Type != "batchMove" || len(entry.Legs) != 2 {
+		t.Fatalf("journal entry is %+v, expected both legs of the batch", entry)
+	}
+}
+
+func TestBatchMoveEnforcesLimits(t *testing.T) {
+	s := newTestStub(t)
+	s.register("alice", "Alice", "Able", KYCTier0)
+	s.fund("alice", 1000)
+
+	// Two legs of 60 are a net debit of 120, over the TIER0 per transaction limit
+	s.mustFail(LimitExceeded, "alice", "batchMove", `[{"from":"alice","to":"a","amount":60},{"from":"alice","to":"b","amount":60}]`)
+	s.expectBalance("alice", 1000)
+}
+
+func TestBatchMoveScreensParties(t *testing.T) {
+	s := newTestStub(t)
+	s.grant(RoleCompliance, "officer")
+	s.mustInvoke("officer", "addSanctionsEntry", "SDN-1", "Mallory", "Evil", SanctionBlock, "OFAC")
+	s.register("mallory", "Mallory", "Evil", "")
+	s.fund("mallory", 0)
+
+	s.mustFail(SanctionsBlocked, "a", "batchMove", `[{"from":"a","to":"b","amount":10},{"from":"a","to":"mallory","amount":10}]`)
+	s.expectBalance("mallory", 0)
+}
+
+func TestBatchMoveVelocity(t *testing.T) {
+	s := newTestStub(t)
+	s.grant(RoleCompliance, "officer")
+	s.mustInvoke("officer", "setVelocityRule", `{"id":"newpayee","type":"NEW_COUNTERPARTY","minamount":50,"action":"FLAG"}`)
+
+	s.mustInvoke("a", "batchMove", `[{"from":"a","to":"b","amount":50},{"from":"b","to":"a","amount":10}]`)
+	var alerts []VelocityAlert
+	if err := json.Unmarshal(s.events[velocityAlertEvent], &alerts); err != nil {
+		t.Fatal(err)
+	}
+	if len(alerts) != 1 || alerts[0].Account != "a" || alerts[0].Counterparty != "b" {
+		t.Fatalf("expected one alert for a paying b, got %+v", alerts)
+	}
+	s.expectBalance("b", 1040)
+
+	// A leg that would be held rejects the whole batch
+	s.mustInvoke("officer", "setVelocityRule", `{"id":"newpayee","type":"NEW_COUNTERPARTY","minamount":50,"action":"HOLD"}`)
+	s.register("c", "Cy", "Cole", "")
+	s.fund("c", 0)
+	s.mustFail(VelocityHeld, "a", "batchMove", `[{"from":"a","to":"c","amount":50}]`)
+	s.expectBalance("c", 0)
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+var billerPrefix = "BILLER" //prefix for the key/value that stores a registered biller
+
+// Biller statuses
+const (
+	BillerPending   = "PENDING"
+	BillerVerified  = "VERIFIED"
+	BillerSuspended = "SUSPENDED"
+)
+
+// Biller is a registered recipient of bills. ID is the biller's enrollment
+// ID, which bills name as their RecipientID, and MSPID the organization that
+// owns it. Settlements of its bills are paid into PayoutAccount.
+type Biller struct {
+	ID            string   `json:"id"`
+	MSPID         string   `json:"mspid"`
+	LegalName     string   `json:"legalname"`
+	PayoutAccount string   `json:"payoutaccount"`
+	Currencies    []string `json:"currencies"`
+	Status        string   `json:"status"`
+	StatusReason  string   `json:"statusreason"`
+	StatusBy      string   `json:"statusby"`
+	RegisteredAt  string   `json:"registered_at"`
+	UpdatedAt     string   `json:"updated_at"`
+}
+
+func getBiller(stub shim.ChaincodeStubInterface, id string) (Biller, bool, error) {
+	var biller Biller
+	billerAsBytes, err := stub.GetState(billerPrefix + id)
+	if err != nil {
+		return biller, false, fmt.Errorf("Failed to get biller %s", id)
+	}
+	if billerAsBytes == nil {
+		return biller, false, nil
+	}
+	err = json.Unmarshal(billerAsBytes, &biller)
+	return biller, true, err
+}
+
+func putBiller(stub shim.ChaincodeStubInterface, biller Biller) error {
+	billerAsBytes, _ := json.Marshal(biller)
+	return stub.PutState(billerPrefix+biller.ID, billerAsBytes)
+}
+
+// checkBillIssuer makes sure a bill names a verified biller that accepts its
+// currency, and that the submitter is that biller's own identity
+func checkBillIssuer(stub shim.ChaincodeStubInterface, bill Bill) error {
+	biller, found, err := getBiller(stub, bill.RecipientID)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("Unknown biller: %s", bill.RecipientID)
+	}
+	if biller.Status != BillerVerified {
+		return fmt.Errorf("Biller %s is %s", biller.ID, biller.Status)
+	}
+	submitter, err := getSubmitter(stub)
+	if err != nil {
+		return err
+	}
+	if submitter.ID != biller.ID || submitter.MSPID != biller.MSPID {
+		return fmt.Errorf("Only biller %s of %s can issue bills in its name", biller.ID, biller.MSPID)
+	}
+	if !containsString(biller.Currencies, bill.Currency) {
+		return fmt.Errorf("Biller %s does not bill in %s", biller.ID, bill.Currency)
+	}
+	return nil
+}
+
+// payoutAccount is the account that receives settlements of a recipient's
+// bills: the registered biller's payout account, or the recipient's own account
+func payoutAccount(stub shim.ChaincodeStubInterface, recipientID string) (string, error) {
+	biller, found, err := getBiller(stub, recipientID)
+	if err != nil {
+		return "", err
+	}
+	if !found || biller.PayoutAccount == "" {
+		return recipientID, nil
+	}
+	return biller.PayoutAccount, nil
+}
+
+// ==== registerBiller =========================================
+// registerBiller registers the submitter as a biller, pending verification by an admin.
+// 0            1                2
+// "legalname"  "payoutaccount"  '["USD","EUR"]'
+// ===========================================================================================
+func (t *SimpleChaincode) registerBiller(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 3")
+	}
+
+	submitter, err := getSubmitter(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if _, found, err := getBiller(stub, submitter.ID); err != nil || found {
+		return shim.Error("Biller already registered: " + submitter.ID)
+	}
+	if args[0] == "" {
+		return shim.Error("Legal name is required")
+	}
+	if _, err := getBalance(stub, args[1]); err != nil {
+		return shim.Error(err.Error())
+	}
+	currencies := []string{}
+	if err := json.Unmarshal([]byte(args[2]), &currencies); err != nil || len(currencies) == 0 {
+		return shim.Error("Invalid currencies, expecting a non-empty JSON list of currency codes")
+	}
+
+	now, err := txTime(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	biller := Biller{ID: submitter.ID, MSPID: submitter.MSPID, LegalName: args[0], PayoutAccount: args[1], Currencies: currencies, Status: BillerPending, RegisteredAt: now.Format(time.RFC3339), UpdatedAt: now.Format(time.RFC3339)}
+	if err := putBiller(stub, biller); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	billerAsBytes, _ := json.Marshal(biller)
+	return shim.Success(billerAsBytes)
+}
+
+// setBillerStatus is shared by verifyBiller and suspendBiller, which are admin only
+func (t *SimpleChaincode) setBillerStatus(stub shim.ChaincodeStubInterface, args []string, status string) pb.Response {
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+	admin, err := requireRole(stub, RoleAdmin)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	biller, found, err := getBiller(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if !found {
+		return shim.Error("Unknown biller: " + args[0])
+	}
+	if biller.Status == status {
+		return shim.Error(fmt.Sprintf("Biller %s is already %s", biller.ID, status))
+	}
+
+	now, err := txTime(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	biller.Status = status
+	biller.StatusReason = args[1]
+	biller.StatusBy = admin.ID
+	biller.UpdatedAt = now.Format(time.RFC3339)
+	if err := putBiller(stub, biller); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	billerAsBytes, _ := json.Marshal(biller)
+	return shim.Success(billerAsBytes)
+}
+
+// ==== verifyBiller =========================================
+// verifyBiller lets a biller issue bills, also after a suspension.
+// 0         1
+// "id"      "reason"
+// ===========================================================================================
+func (t *SimpleChaincode) verifyBiller(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	return t.setBillerStatus(stub, args, BillerVerified)
+}
+
+// ==== suspendBiller =========================================
+// suspendBiller stops a biller from issuing bills. Bills already issued can still be paid.
+// 0         1
+// "id"      "reason"
+// ===========================================================================================
+func (t *SimpleChaincode) suspendBiller(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	return t.setBillerStatus(stub, args, BillerSuspended)
+}
+
+// ==== queryBiller =========================================
+// 0
+// "id"
+// ===========================================================================================
+func (t *SimpleChaincode) queryBiller(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	biller, found, err := getBiller(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if !found {
+		return shim.Error("Unknown biller: " + args[0])
+	}
+
+	billerAsBytes, _ := json.Marshal(biller)
+	return shim.Success(billerAsBytes)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestBillerMustBeVerifiedToIssueBills(t *testing.T) {
+	s := newTestStub(t)
+	s.register("alice", "Alice", "Able", KYCTier1)
+	s.fund("acme", 0)
+	args := []string{"B1", "INV-B1", "acme", "alice", "", "", "2017-10-01", "2017-11-30", "2017-10-01T00:00:00Z", "services", "100", "USD", ""}
+
+	s.mustFail("Unknown biller: acme", "acme", "createBill", args...)
+	s.mustFail("Invalid currencies", "acme", "registerBiller", "Acme Ltd", "acme", "[]")
+	s.mustInvoke("acme", "registerBiller", "Acme Ltd", "acme", `["USD"]`)
+	s.mustFail("Biller already registered: acme", "acme", "registerBiller", "Acme Ltd", "acme", `["USD"]`)
+	s.mustFail("Biller acme is PENDING", "acme", "createBill", args...)
+
+	s.mustFail("admin", "acme", "verifyBiller", "acme", "self approval")
+	s.mustInvoke("admin", "verifyBiller", "acme", "documents checked")
+	s.mustFail("Only biller acme of Org1MSP can issue bills in its name", "mallory", "createBill", args...)
+	s.mustFail("Biller acme does not bill in EUR", "acme", "createBill", "B1", "INV-B1", "acme", "alice", "", "", "2017-10-01", "2017-11-30", "2017-10-01T00:00:00Z", "services", "100", "EUR", "")
+	s.mustInvoke("acme", "createBill", args...)
+
+	var biller Biller
+	if err := json.Unmarshal(s.mustInvoke("alice", "queryBiller", "acme"), &biller); err != nil {
+		t.Fatal(err)
+	}
+	if biller.Status != BillerVerified || biller.MSPID != testMSPID || biller.StatusBy != "admin" {
+		t.Fatalf("biller is %+v", biller)
+	}
+}
+
+func TestSuspendedBillerIsStillPaid(t *testing.T) {
+	s := newTestStub(t)
+	s.biller("acme")
+	s.register("alice", "Alice", "Able", KYCTier1)
+	s.fund("alice", 1000)
+	s.issueBill("B1", "acme", "alice", 100, "2017-11-30")
+
+	s.mustInvoke("admin", "suspendBiller", "acme", "complaints")
+	s.mustFail("Biller acme is already SUSPENDED", "admin", "suspendBiller", "acme", "complaints")
+	s.mustFail("Biller acme is SUSPENDED", "acme", "createBill", "B2", "INV-B2", "acme", "alice", "", "", "2017-10-01", "2017-11-30", "2017-10-01T00:00:00Z", "services", "100", "USD", "")
+
+	// Bills issued before the suspension can still be paid
+	s.mustPay("p1", "alice", 100, `[{"billid":"B1","amount":100}]`)
+	if bill := s.bill("B1"); bill.Status != BillPaid {
+		t.Fatalf("B1 is %s, expected %s", bill.Status, BillPaid)
+	}
+
+	s.mustInvoke("admin", "verifyBiller", "acme", "resolved")
+	s.issueBill("B2", "acme", "alice", 100, "2017-11-30")
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+var billPrefix = "BILL" //prefix for the key/value that stores a bill, as written by createBill
+
+// Bill statuses. Bills written before statuses existed have an empty status and count as issued.
+const (
+	BillIssued    = "ISSUED"
+	BillPartial   = "PARTIAL"
+	BillPaid      = "PAID"
+	BillCredited  = "CREDITED"  //credit notes brought the amount to zero before anything was paid
+	BillCancelled = "CANCELLED" //cancelled by resolving a dispute
+)
+
+// LineItem is one itemized charge on a bill. Prices and discounts are in the
+// same integer units as account balances; Discount is taken off the line.
+type LineItem struct {
+	Description string `json:"description"`
+	Quantity    int    `json:"quantity"`
+	UnitPrice   int    `json:"unitprice"`
+	TaxCode     string `json:"taxcode"`
+	Discount    int    `json:"discount"`
+	Total       int    `json:"total"` //computed by createBill: Quantity * UnitPrice - Discount
+	Tax         int    `json:"tax"`   //computed by createBill from the jurisdiction's rate for TaxCode
+}
+
+// applyLineItems computes every line total, the tax on them and the bill
+// amount. If the caller also supplied an amount it must match the computed total.
+func (b *Bill) applyLineItems(stub shim.ChaincodeStubInterface) error {
+	if len(b.LineItems) == 0 {
+		return fmt.Errorf("Bill %s: line items must not be empty", b.ID)
+	}
+	total := 0
+	for i := range b.LineItems {
+		item := &b.LineItems[i]
+		if item.Description == "" {
+			return fmt.Errorf("Line item %d: description is required", i)
+		}
+		if item.Quantity <= 0 {
+			return fmt.Errorf("Line item %d: quantity must be positive", i)
+		}
+		if item.UnitPrice < 0 || item.Discount < 0 {
+			return fmt.Errorf("Line item %d: unit price and discount must not be negative", i)
+		}
+		gross := item.Quantity * item.UnitPrice
+		if item.Discount > gross {
+			return fmt.Errorf("Line item %d: discount %d exceeds line amount %d", i, item.Discount, gross)
+		}
+		item.Total = gross - item.Discount
+		total += item.Total
+	}
+	if total <= 0 {
+		return fmt.Errorf("Bill %s: line items total must be positive", b.ID)
+	}
+	b.Subtotal = total
+	if err := b.applyTax(stub); err != nil {
+		return err
+	}
+	total += b.TaxTotal
+	if b.Amount != "" && b.Amount != strconv.Itoa(total) {
+		return fmt.Errorf("Bill %s: amount %s does not match line items total %d", b.ID, b.Amount, total)
+	}
+	b.Amount = strconv.Itoa(total)
+	return nil
+}
+
+// isOpen reports whether the bill can still be settled
+func (b Bill) isOpen() bool {
+	return b.Status == "" || b.Status == BillIssued || b.Status == BillPartial
+}
+
+// amountDue parses the bill amount, which is held in the same integer units as account balances
+func (b Bill) amountDue() (int, error) {
+	amount, err := strconv.Atoi(b.Amount)
+	if err != nil || amount <= 0 {
+		return 0, fmt.Errorf("Bill %s has an invalid amount: %s", b.ID, b.Amount)
+	}
+	return amount, nil
+}
+
+// effectiveAmount is the original amount after credit and debit notes
+func (b Bill) effectiveAmount() (int, error) {
+	amount, err := b.amountDue()
+	if err != nil {
+		return 0, err
+	}
+	return amount + b.AdjustmentTotal, nil
+}
+
+// outstanding is what remains to be paid on the bill
+func (b Bill) outstanding() (int, error) {
+	amount, err := b.effectiveAmount()
+	if err != nil {
+		return 0, err
+	}
+	return amount - b.PaidAmount - b.DiscountTaken, nil
+}
+
+func getBill(stub shim.ChaincodeStubInterface, id string) (Bill, error) {
+	var bill Bill
+	billAsBytes, err := stub.GetState(billPrefix + id)
+	if err != nil {
+		return bill, fmt.Errorf("Failed to get bill %s", id)
+	}
+	if billAsBytes == nil {
+		return bill, fmt.Errorf("Bill not found: %s", id)
+	}
+	err = json.Unmarshal(billAsBytes, &bill)
+	return bill, err
+}
+
+// addBill stores a new bill and indexes it under its user and its recipient,
+// and in the bill index
+func addBill(stub shim.ChaincodeStubInterface, bill Bill) error {
+	billAsBytes, _ := json.Marshal(bill)
+	if err := stub.PutState(billPrefix+bill.ID, billAsBytes); err != nil {
+		return err
+	}
+	indexKey, err := stub.CreateCompositeKey("userid~id", []string{bill.UserID, bill.ID})
+	if err != nil {
+		return err
+	}
+	if err := stub.PutState(indexKey, []byte{0x00}); err != nil {
+		return err
+	}
+	recipientIndexKey, err := stub.CreateCompositeKey(recipientIndexName, []string{bill.RecipientID, bill.ID})
+	if err != nil {
+		return err
+	}
+	if err := stub.PutState(recipientIndexKey, []byte{0x00}); err != nil {
+		return err
+	}
+
+	indexAsBytes, err := stub.GetState(billIndexStr)
+	if err != nil {
+		return fmt.Errorf("Failed to get bill index")
+	}
+	var bills AllBills
+	json.Unmarshal(indexAsBytes, &bills)
+	bills.Bills = append(bills.Bills, bill)
+	indexAsBytes, _ = json.Marshal(bills)
+	return stub.PutState(billIndexStr, indexAsBytes)
+}
+
+// putBill rewrites a bill and the copy of it kept in the bill index, so
+// queryByDate stays current. Changes to a share of a split bill carry over
+// to the parent, and changes to a bill with an installment plan to the plan.
+func putBill(stub shim.ChaincodeStubInterface, bill Bill) error {
+	billAsBytes, _ := json.Marshal(bill)
+	if err := stub.PutState(billPrefix+bill.ID, billAsBytes); err != nil {
+		return err
+	}
+
+	indexAsBytes, err := stub.GetState(billIndexStr)
+	if err != nil {
+		return fmt.Errorf("Failed to get bill index")
+	}
+	var bills AllBills
+	json.Unmarshal(indexAsBytes, &bills)
+	for i := range bills.Bills {
+		if bills.Bills[i].ID == bill.ID {
+			bills.Bills[i] = bill
+		}
+	}
+	indexAsBytes, _ = json.Marshal(bills)
+	if err := stub.PutState(billIndexStr, indexAsBytes); err != nil {
+		return err
+	}
+	if err := reconcileInstallmentPlan(stub, bill); err != nil {
+		return err
+	}
+	if bill.ParentID != "" {
+		return refreshSplitParent(stub, bill.ParentID)
+	}
+	return nil
+}
+
+// applyPayment records amount paid against an open bill by a payment (or,
+// for settlements, by the settling transaction) and updates its status. A
+// payment inside the early-payment window that covers the discounted
+// outstanding amount settles the bill, and the discount is recorded on the
+// returned allocation.
+func applyPayment(stub shim.ChaincodeStubInterface, bill Bill, paymentID string, amount int, now time.Time) (Bill, Allocation, error) {
+	allocation := Allocation{BillID: bill.ID, PaymentID: paymentID, Amount: amount, TxID: stub.GetTxID(), Timestamp: now.Format(time.RFC3339)}
+	if !bill.isOpen() {
+		return bill, allocation, fmt.Errorf("Bill %s is already %s", bill.ID, bill.Status)
+	}
+	outstanding, err := bill.outstanding()
+	if err != nil {
+		return bill, allocation, err
+	}
+	if amount <= 0 || amount > outstanding {
+		return bill, allocation, fmt.Errorf("Cannot apply %d to bill %s with %d outstanding", amount, bill.ID, outstanding)
+	}
+	if discount := bill.earlyDiscount(now); discount > 0 && amount >= outstanding-discount {
+		allocation.Discount = outstanding - amount
+	}
+
+	bill.PaidAmount += amount
+	bill.DiscountTaken += allocation.Discount
+	bill.Outstanding = outstanding - amount - allocation.Discount
+	if bill.Outstanding == 0 {
+		bill.Status = BillPaid
+		bill.PaidAt = now.Format(time.RFC3339)
+	} else {
+		bill.Status = BillPartial
+	}
+	// The installments are paid before the bill is stored, so storing it finds the plan already in line
+	if err := applyToInstallments(stub, bill.ID, amount+allocation.Discount, now); err != nil {
+		return bill, allocation, err
+	}
+	if err := putBill(stub, bill); err != nil {
+		return bill, allocation, err
+	}
+	if err := putAllocation(stub, allocation); err != nil {
+		return bill, allocation, err
+	}
+	return bill, allocation, nil
+}
+
+// settleBill pays what is outstanding on an open bill, less any early-payment
+// discount, from the given account to the recipient's payout account
+func settleBill(stub shim.ChaincodeStubInterface, bill Bill, account string, now time.Time) (Bill, error) {
+	if !bill.isOpen() {
+		return bill, fmt.Errorf("Bill %s is already %s", bill.ID, bill.Status)
+	}
+	if bill.DisputeID != "" {
+		return bill, fmt.Errorf("Bill %s is under dispute %s", bill.ID, bill.DisputeID)
+	}
+	amount, err := bill.amountToSettle(now)
+	if err != nil {
+		return bill, err
+	}
+	payout, err := payoutAccount(stub, bill.RecipientID)
+	if err != nil {
+		return bill, err
+	}
+	if err := transfer(stub, "settleBill", account, payout, amount); err != nil {
+		return bill, err
+	}
+	bill, _, err = applyPayment(stub, bill, stub.GetTxID(), amount, now)
+	return bill, err
+}
@@ -0,0 +1,40 @@
+package main
+
+import "testing"
+
+func TestLineItemsComputeAmount(t *testing.T) {
+	s := newTestStub(t)
+	s.biller("acme")
+	s.register("alice", "Alice", "Able", KYCTier1)
+	items := `[{"description":"widget","quantity":3,"unitprice":40,"discount":20},{"description":"delivery","quantity":1,"unitprice":15}]`
+	s.mustInvoke("acme", "createBill", "B1", "INV-B1", "acme", "alice", "", "", "2017-10-01", "2017-11-30", "2017-10-01T00:00:00Z", "goods", "", "USD", "", items)
+
+	bill := s.bill("B1")
+	if bill.Amount != "115" {
+		t.Fatalf("B1 is %s, expected 115", bill.Amount)
+	}
+	if len(bill.LineItems) != 2 || bill.LineItems[0].Total != 100 || bill.LineItems[1].Total != 15 {
+		t.Fatalf("line items are %+v, expected totals 100 and 15", bill.LineItems)
+	}
+
+	// A supplied amount must agree with the line items
+	s.mustInvoke("acme", "createBill", "B2", "INV-B2", "acme", "alice", "", "", "2017-10-01", "2017-11-30", "2017-10-01T00:00:00Z", "goods", "115", "USD", "", items)
+	s.mustFail("amount 120 does not match line items total 115", "acme", "createBill", "B3", "INV-B3", "acme", "alice", "", "", "2017-10-01", "2017-11-30", "2017-10-01T00:00:00Z", "goods", "120", "USD", "", items)
+}
+
+func TestLineItemsAreValidated(t *testing.T) {
+	s := newTestStub(t)
+	s.biller("acme")
+	s.register("alice", "Alice", "Able", KYCTier1)
+	for items, want := range map[string]string{
+		`[]`: "line items must not be empty",
+		`[{"description":"","quantity":1,"unitprice":10}]`:                     "Line item 0: description is required",
+		`[{"description":"widget","quantity":0,"unitprice":10}]`:               "Line item 0: quantity must be positive",
+		`[{"description":"widget","quantity":1,"unitprice":-10}]`:              "Line item 0: unit price and discount must not be negative",
+		`[{"description":"widget","quantity":2,"unitprice":10,"discount":21}]`: "Line item 0: discount 21 exceeds line amount 20",
+		`[{"description":"widget","quantity":1,"unitprice":0}]`:                "line items total must be positive",
+		`{"description":"widget"}`:                                             "Invalid line items",
+	} {
+		s.mustFail(want, "acme", "createBill", "B1", "INV-B1", "acme", "alice", "", "", "2017-10-01", "2017-11-30", "2017-10-01T00:00:00Z", "goods", "", "USD", "", items)
+	}
+}
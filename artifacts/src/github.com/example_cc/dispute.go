@@ -0,0 +1,309 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+var disputePrefix = "DISPUTE"                  //prefix for the key/value that stores a dispute
+var disputeIndexName = "dispute~billid~id"     //composite index of the disputes raised against a bill
+var disputeOpenedEvent = "DisputeOpened"       //chaincode event emitted by openDispute
+var disputeRespondedEvent = "DisputeResponded" //chaincode event emitted by respondDispute
+var disputeResolvedEvent = "DisputeResolved"   //chaincode event emitted by resolveDispute
+
+// Dispute statuses
+const (
+	DisputeOpen      = "OPEN"
+	DisputeResponded = "RESPONDED"
+	DisputeResolved  = "RESOLVED"
+)
+
+// Dispute outcomes
+const (
+	DisputeUphold = "UPHOLD" //the bill stands as issued
+	DisputeAdjust = "ADJUST" //a credit note is raised against the bill
+	DisputeCancel = "CANCEL" //the bill is cancelled
+)
+
+// Reason codes accepted at each step of a dispute
+var disputeReasons = []string{"NOT_RECEIVED", "INCORRECT_AMOUNT", "DUPLICATE", "QUALITY", "UNAUTHORIZED", "OTHER"}
+var disputeResponseReasons = []string{"DELIVERED", "AMOUNT_CORRECT", "NOT_DUPLICATE", "CREDIT_OFFERED", "ACCEPTED", "OTHER"}
+var disputeResolutionReasons = []string{"BILLER_EVIDENCE", "USER_EVIDENCE", "AGREED", "OTHER"}
+
+// DisputeStep is one action taken on a dispute
+type DisputeStep struct {
+	Action       string `json:"action"`
+	By           string `json:"by"`
+	ReasonCode   string `json:"reasoncode"`
+	EvidenceHash string `json:"evidencehash"` //hex SHA-256 of evidence kept off-ledger
+	Memo         string `json:"memo"`
+	TxID         string `json:"txid"`
+	Timestamp    string `json:"tr_time"`
+}
+
+// Dispute is a user contesting a bill. The bill carries the dispute's ID
+// while it is open, and collection and auto-pay skip it until it is resolved.
+type Dispute struct {
+	ID           string        `json:"id"`
+	BillID       string        `json:"billid"`
+	UserID       string        `json:"userid"`
+	RecipientID  string        `json:"recipientid"`
+	Status       string        `json:"status"`
+	Outcome      string        `json:"outcome"`
+	CreditNoteID string        `json:"creditnoteid"`
+	Steps        []DisputeStep `json:"steps"`
+}
+
+func getDispute(stub shim.ChaincodeStubInterface, id string) (Dispute, error) {
+	var dispute Dispute
+	disputeAsBytes, err := stub.GetState(disputePrefix + id)
+	if err != nil {
+		return dispute, fmt.Errorf("Failed to get dispute %s", id)
+	}
+	if disputeAsBytes == nil {
+		return dispute, fmt.Errorf("Dispute not found: %s", id)
+	}
+	err = json.Unmarshal(disputeAsBytes, &dispute)
+	return dispute, err
+}
+
+func putDispute(stub shim.ChaincodeStubInterface, dispute Dispute) error {
+	disputeAsBytes, _ := json.Marshal(dispute)
+	return stub.PutState(disputePrefix+dispute.ID, disputeAsBytes)
+}
+
+// disputeStep validates the reason code and evidence hash of a step and stamps it with the transaction
+func disputeStep(stub shim.ChaincodeStubInterface, action, by, reasonCode, evidenceHash, memo string, reasons []string) (DisputeStep, error) {
+	step := DisputeStep{Action: action, By: by, ReasonCode: reasonCode, EvidenceHash: evidenceHash, Memo: memo, TxID: stub.GetTxID()}
+	if !containsString(reasons, reasonCode) {
+		return step, fmt.Errorf("Unknown reason code %s, expecting one of %v", reasonCode, reasons)
+	}
+	if evidenceHash != "" {
+		if decoded, err := hex.DecodeString(evidenceHash); err != nil || len(decoded) != sha256.Size {
+			return step, fmt.Errorf("Evidence hash must be a hex encoded SHA-256 digest")
+		}
+	}
+	now, err := txTime(stub)
+	if err != nil {
+		return step, err
+	}
+	step.Timestamp = now.Format(time.RFC3339)
+	return step, nil
+}
+
+// emitDispute stores the dispute and emits it as the named chaincode event
+func emitDispute(stub shim.ChaincodeStubInterface, dispute Dispute, event string) pb.Response {
+	if err := putDispute(stub, dispute); err != nil {
+		return shim.Error(err.Error())
+	}
+	disputeAsBytes, _ := json.Marshal(dispute)
+	if err := stub.SetEvent(event, disputeAsBytes); err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(disputeAsBytes)
+}
+
+// ==== openDispute =========================================
+// openDispute lets a bill's user contest an open bill. evidencehash is the
+// hex SHA-256 of the evidence, which is kept off-ledger.
+// 0            1       2              3               4
+// "disputeid"  "id"    "reasoncode"   "evidencehash"  "memo"
+// ===========================================================================================
+func (t *SimpleChaincode) openDispute(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 5 {
+		return shim.Error("Incorrect number of arguments. Expecting 5")
+	}
+
+	submitter, err := getParty(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	bill, err := getBill(stub, args[1])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if bill.UserID != submitter.ID {
+		return shim.Error(fmt.Sprintf("Only user %s can dispute bill %s", bill.UserID, bill.ID))
+	}
+	if !bill.isOpen() {
+		return shim.Error(fmt.Sprintf("Bill %s is already %s", bill.ID, bill.Status))
+	}
+	if bill.DisputeID != "" {
+		return shim.Error(fmt.Sprintf("Bill %s is already under dispute %s", bill.ID, bill.DisputeID))
+	}
+	if existing, err := stub.GetState(disputePrefix + args[0]); err != nil || existing != nil {
+		return shim.Error("Dispute already exists: " + args[0])
+	}
+	if args[3] == "" {
+		return shim.Error("An evidence hash is required to open a dispute")
+	}
+	step, err := disputeStep(stub, "OPEN", submitter.ID, args[2], args[3], args[4], disputeReasons)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	dispute := Dispute{ID: args[0], BillID: bill.ID, UserID: bill.UserID, RecipientID: bill.RecipientID, Status: DisputeOpen, Steps: []DisputeStep{step}}
+	bill.DisputeID = dispute.ID
+	if err := putBill(stub, bill); err != nil {
+		return shim.Error(err.Error())
+	}
+	indexKey, err := stub.CreateCompositeKey(disputeIndexName, []string{bill.ID, dispute.ID})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := stub.PutState(indexKey, []byte{0x00}); err != nil {
+		return shim.Error(err.Error())
+	}
+	return emitDispute(stub, dispute, disputeOpenedEvent)
+}
+
+// ==== respondDispute =========================================
+// respondDispute lets the bill's recipient answer an open dispute.
+// 0            1              2               3
+// "disputeid"  "reasoncode"   "evidencehash"  "memo"
+// ===========================================================================================
+func (t *SimpleChaincode) respondDispute(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 4 {
+		return shim.Error("Incorrect number of arguments. Expecting 4")
+	}
+
+	submitter, err := getParty(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	dispute, err := getDispute(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if dispute.RecipientID != submitter.ID {
+		return shim.Error(fmt.Sprintf("Only recipient %s can respond to dispute %s", dispute.RecipientID, dispute.ID))
+	}
+	if dispute.Status == DisputeResolved {
+		return shim.Error(fmt.Sprintf("Dispute %s is already resolved", dispute.ID))
+	}
+	step, err := disputeStep(stub, "RESPOND", submitter.ID, args[1], args[2], args[3], disputeResponseReasons)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	dispute.Status = DisputeResponded
+	dispute.Steps = append(dispute.Steps, step)
+	return emitDispute(stub, dispute, disputeRespondedEvent)
+}
+
+// ==== resolveDispute =========================================
+// resolveDispute closes a dispute. The recipient may concede by adjusting or
+// cancelling the bill; upholding it takes an admin. amount is the credit
+// raised by ADJUST and must be empty otherwise. A bill that has been paid
+// into cannot be cancelled, adjust it instead.
+// 0            1                             2         3              4               5
+// "disputeid"  "UPHOLD"|"ADJUST"|"CANCEL"    "amount"  "reasoncode"   "evidencehash"  "memo"
+// ===========================================================================================
+func (t *SimpleChaincode) resolveDispute(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 6 {
+		return shim.Error("Incorrect number of arguments. Expecting 6")
+	}
+
+	submitter, err := getSubmitter(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	dispute, err := getDispute(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if dispute.Status == DisputeResolved {
+		return shim.Error(fmt.Sprintf("Dispute %s is already resolved", dispute.ID))
+	}
+	outcome := args[1]
+	if outcome != DisputeUphold && outcome != DisputeAdjust && outcome != DisputeCancel {
+		return shim.Error(fmt.Sprintf("Outcome must be one of '%s', '%s' or '%s'. But got: %v", DisputeUphold, DisputeAdjust, DisputeCancel, outcome))
+	}
+	isAdmin, err := hasRole(stub, submitter, RoleAdmin)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if !isAdmin {
+		if outcome == DisputeUphold || dispute.RecipientID != submitter.ID {
+			return shim.Error(fmt.Sprintf("%s cannot resolve dispute %s as %s", submitter.ID, dispute.ID, outcome))
+		}
+		if err := checkParty(stub, submitter); err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+	step, err := disputeStep(stub, outcome, submitter.ID, args[3], args[4], args[5], disputeResolutionReasons)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	bill, err := getBill(stub, dispute.BillID)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	now, err := txTime(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	bill.DisputeID = ""
+	switch outcome {
+	case DisputeAdjust:
+		amount, err := strconv.Atoi(args[2])
+		if err != nil {
+			return shim.Error("Invalid credit amount, expecting a positive integer value")
+		}
+		note := Adjustment{ID: dispute.ID + "-CREDIT", Type: CreditNote, Amount: amount, ReasonCode: "DISPUTE", Memo: args[5], CreatedBy: submitter.ID}
+		if _, err := adjustBill(stub, bill, note, now); err != nil {
+			return shim.Error(err.Error())
+		}
+		dispute.CreditNoteID = note.ID
+	case DisputeCancel:
+		if args[2] != "" {
+			return shim.Error("Amount must be empty unless the outcome is " + DisputeAdjust)
+		}
+		if bill.PaidAmount > 0 {
+			return shim.Error(fmt.Sprintf("Bill %s has %d paid, it can only be adjusted", bill.ID, bill.PaidAmount))
+		}
+		bill.Status = BillCancelled
+		bill.Outstanding = 0
+		if err := putBill(stub, bill); err != nil {
+			return shim.Error(err.Error())
+		}
+	default:
+		if args[2] != "" {
+			return shim.Error("Amount must be empty unless the outcome is " + DisputeAdjust)
+		}
+		if err := putBill(stub, bill); err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	dispute.Status = DisputeResolved
+	dispute.Outcome = outcome
+	dispute.Steps = append(dispute.Steps, step)
+	return emitDispute(stub, dispute, disputeResolvedEvent)
+}
+
+// ==== queryDispute =========================================
+// 0
+// "disputeid"
+// ===========================================================================================
+func (t *SimpleChaincode) queryDispute(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	dispute, err := getDispute(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	disputeAsBytes, _ := json.Marshal(dispute)
+	return shim.Success(disputeAsBytes)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestDisputeLifecycle(t *testing.T) {
+	s := newTestStub(t)
+	s.biller("acme")
+	s.register("alice", "Alice", "Able", KYCTier1)
+	s.fund("alice", 1000)
+	s.mustInvoke("alice", "createMandate", "acme", "500", "500", FrequencyMonthly)
+	s.issueBill("B1", "acme", "alice", 100, "2017-11-01")
+	evidence := strings.Repeat("ab", 32)
+
+	s.mustFail("Only user alice can dispute", "acme", "openDispute", "D1", "B1", "NOT_RECEIVED", evidence, "")
+	s.mustFail("An evidence hash is required", "alice", "openDispute", "D1", "B1", "NOT_RECEIVED", "", "")
+	s.mustFail("Evidence hash must be a hex encoded SHA-256 digest", "alice", "openDispute", "D1", "B1", "NOT_RECEIVED", "abcd", "")
+	s.mustFail("Unknown reason code", "alice", "openDispute", "D1", "B1", "NO_REASON", evidence, "")
+	s.mustInvoke("alice", "openDispute", "D1", "B1", "NOT_RECEIVED", evidence, "never arrived")
+	if s.events[disputeOpenedEvent] == nil {
+		t.Fatal("openDispute emitted no event")
+	}
+	s.mustFail("already under dispute D1", "alice", "openDispute", "D2", "B1", "NOT_RECEIVED", evidence, "")
+
+	// Collection waits for the dispute
+	s.mustFail("Bill B1 is under dispute D1", "acme", "collectBill", "B1")
+
+	s.mustFail("Only recipient acme can respond", "alice", "respondDispute", "D1", "DELIVERED", evidence, "")
+	s.mustInvoke("acme", "respondDispute", "D1", "DELIVERED", evidence, "signed for")
+
+	// Only an admin upholds a bill
+	s.mustFail("acme cannot resolve dispute D1 as UPHOLD", "acme", "resolveDispute", "D1", DisputeUphold, "", "BILLER_EVIDENCE", evidence, "")
+	s.mustFail("alice cannot resolve dispute D1 as CANCEL", "alice", "resolveDispute", "D1", DisputeCancel, "", "AGREED", evidence, "")
+	s.mustInvoke("admin", "resolveDispute", "D1", DisputeUphold, "", "BILLER_EVIDENCE", evidence, "")
+	s.mustFail("Dispute D1 is already resolved", "acme", "respondDispute", "D1", "DELIVERED", evidence, "")
+
+	var dispute Dispute
+	if err := json.Unmarshal(s.mustInvoke("alice", "queryDispute", "D1"), &dispute); err != nil {
+		t.Fatal(err)
+	}
+	if dispute.Status != DisputeResolved || dispute.Outcome != DisputeUphold || len(dispute.Steps) != 3 {
+		t.Fatalf("dispute is %+v", dispute)
+	}
+	if bill := s.bill("B1"); bill.DisputeID != "" {
+		t.Fatalf("B1 is still under dispute %s", bill.DisputeID)
+	}
+	s.mustInvoke("acme", "collectBill", "B1")
+	s.expectBalance("alice", 900)
+}
+
+func TestRecipientConcedesDispute(t *testing.T) {
+	s := newTestStub(t)
+	s.biller("acme")
+	s.register("alice", "Alice", "Able", KYCTier1)
+	s.fund("alice", 1000)
+	s.issueBill("B1", "acme", "alice", 100, "2017-11-30")
+	s.issueBill("B2", "acme", "alice", 100, "2017-11-30")
+	evidence := strings.Repeat("ab", 32)
+
+	s.mustInvoke("alice", "openDispute", "D1", "B1", "INCORRECT_AMOUNT", evidence, "")
+	s.mustFail("Amount must be empty", "acme", "resolveDispute", "D1", DisputeCancel, "30", "AGREED", evidence, "")
+	s.mustInvoke("acme", "resolveDispute", "D1", DisputeCancel, "", "AGREED", evidence, "")
+	if bill := s.bill("B1"); bill.Status != BillCancelled || bill.Outstanding != 0 {
+		t.Fatalf("B1 is %s with %d outstanding, expected %s with nothing outstanding", bill.Status, bill.Outstanding, BillCancelled)
+	}
+
+	// A bill that has been paid into can only be adjusted
+	s.mustPay("p1", "alice", 40, `[{"billid":"B2","amount":40}]`)
+	s.mustInvoke("alice", "openDispute", "D2", "B2", "QUALITY", evidence, "")
+	s.mustFail("Bill B2 has 40 paid, it can only be adjusted", "acme", "resolveDispute", "D2", DisputeCancel, "", "AGREED", evidence, "")
+	s.mustInvoke("acme", "resolveDispute", "D2", DisputeAdjust, "20", "AGREED", evidence, "")
+	var dispute Dispute
+	s.get(disputePrefix+"D2", &dispute)
+	if dispute.CreditNoteID != "D2-CREDIT" {
+		t.Fatalf("dispute D2 raised credit note %q, expected D2-CREDIT", dispute.CreditNoteID)
+	}
+	if bill := s.bill("B2"); bill.Outstanding != 40 || bill.DisputeID != "" {
+		t.Fatalf("B2 has %d outstanding under dispute %q, expected 40 and no dispute", bill.Outstanding, bill.DisputeID)
+	}
+}
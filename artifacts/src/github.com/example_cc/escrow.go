@@ -0,0 +1,273 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+var escrowPrefix = "ESCROW" //prefix for the key/value that stores an escrow contract
+
+// Escrow statuses
+const (
+	EscrowLocked   = "LOCKED"
+	EscrowReleased = "RELEASED"
+	EscrowRefunded = "REFUNDED"
+)
+
+// Escrow holds a payer's funds until enough release parties approve, or the deadline passes
+type Escrow struct {
+	ID                string   `json:"id"`
+	Payer             string   `json:"payer"`
+	Recipient         string   `json:"recipient"`
+	Amount            int      `json:"amount"`
+	ReleaseParties    []string `json:"release_parties"`
+	RequiredApprovals int      `json:"required_approvals"`
+	Approvals         []string `json:"approvals"`
+	Deadline          string   `json:"deadline"`
+	Status            string   `json:"status"`
+	CreatedAt         string   `json:"created_at"`
+	SettledAt         string   `json:"settled_at"`
+}
+
+func getEscrow(stub shim.ChaincodeStubInterface, id string) (Escrow, error) {
+	var escrow Escrow
+	escrowAsBytes, err := stub.GetState(escrowPrefix + id)
+	if err != nil {
+		return escrow, fmt.Errorf("Failed to get escrow %s", id)
+	}
+	if escrowAsBytes == nil {
+		return escrow, fmt.Errorf("Escrow not found: %s", id)
+	}
+	err = json.Unmarshal(escrowAsBytes, &escrow)
+	return escrow, err
+}
+
+func putEscrow(stub shim.ChaincodeStubInterface, escrow Escrow) error {
+	escrowAsBytes, _ := json.Marshal(escrow)
+	return stub.PutState(escrowPrefix+escrow.ID, escrowAsBytes)
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// ==== createEscrow =========================================
+// createEscrow takes the amount out of the payer's account and locks it under
+// the escrow ID. Only the payer may lock its own funds.
+//   0          1        2            3         4                    5    6
+// "escrowid"  "payer"  "recipient"  "amount"  '["alice","bob"]'     "2"  "2017-11-20T00:00:00Z"
+// ===========================================================================================
+func (t *SimpleChaincode) createEscrow(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 7 {
+		return shim.Error("Incorrect number of arguments. Expecting 7")
+	}
+
+	id, payer, recipient := args[0], args[1], args[2]
+	amount, err := strconv.Atoi(args[3])
+	if err != nil || amount <= 0 {
+		return shim.Error("Invalid escrow amount, expecting a positive integer value")
+	}
+	var parties []string
+	if err := json.Unmarshal([]byte(args[4]), &parties); err != nil || len(parties) == 0 {
+		return shim.Error("Invalid release parties, expecting a non-empty JSON list of names")
+	}
+	required, err := strconv.Atoi(args[5])
+	if err != nil || required <= 0 || required > len(parties) {
+		return shim.Error(fmt.Sprintf("Required approvals must be between 1 and %d", len(parties)))
+	}
+	deadline, err := time.Parse(time.RFC3339, args[6])
+	if err != nil {
+		return shim.Error("Invalid deadline, expecting an RFC3339 timestamp")
+	}
+	if payer == recipient {
+		return shim.Error("Payer and recipient must differ")
+	}
+	submitter, err := getParty(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if submitter.ID != payer {
+		return shim.Error(fmt.Sprintf("Only %s can lock its funds in escrow", payer))
+	}
+
+	existing, err := stub.GetState(escrowPrefix + id)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if existing != nil {
+		return shim.Error("Escrow already exists: " + id)
+	}
+
+	now, err := txTime(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if !deadline.After(now) {
+		return shim.Error("Deadline must be after the transaction time")
+	}
+	if _, err := getBalance(stub, recipient); err != nil {
+		return shim.Error(err.Error())
+	}
+	// Sanctions screening, KYC tier limits and velocity rules apply as to any
+	// transfer. Escrowed funds are already locked, so a transfer that would be
+	// held for review is refused instead.
+	if err := screenParties(stub, "createEscrow", payer, recipient); err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := enforceLimits(stub, payer, amount, now); err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := enforceVelocity(stub, "createEscrow", payer, recipient, amount, now); err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := debit(stub, payer, amount); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	escrow := Escrow{ID: id, Payer: payer, Recipient: recipient, Amount: amount, ReleaseParties: parties, RequiredApprovals: required, Approvals: []string{}, Deadline: deadline.UTC().Format(time.RFC3339), Status: EscrowLocked, CreatedAt: now.Format(time.RFC3339)}
+	if err := putEscrow(stub, escrow); err != nil {
+		return shim.Error(err.Error())
+	}
+	if _, err := writeJournal(stub, "createEscrow", []TransferLeg{{From: payer, To: escrowPrefix + id, Amount: amount}}); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	escrowAsBytes, _ := json.Marshal(escrow)
+	return shim.Success(escrowAsBytes)
+}
+
+// ==== approveEscrow =========================================
+// approveEscrow records the submitter's approval. The submitter must be one of
+// the release parties. Once the required number of approvals is reached the
+// parties are screened again and the funds are paid to the recipient in the
+// same transaction.
+//   0
+// "escrowid"
+// ===========================================================================================
+func (t *SimpleChaincode) approveEscrow(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	escrow, err := getEscrow(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if escrow.Status != EscrowLocked {
+		return shim.Error(fmt.Sprintf("Escrow %s is already %s", escrow.ID, escrow.Status))
+	}
+
+	submitter, err := getParty(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if !containsString(escrow.ReleaseParties, submitter.ID) {
+		return shim.Error(fmt.Sprintf("%s is not a release party of escrow %s", submitter.ID, escrow.ID))
+	}
+	if containsString(escrow.Approvals, submitter.ID) {
+		return shim.Error(fmt.Sprintf("%s has already approved escrow %s", submitter.ID, escrow.ID))
+	}
+
+	now, err := txTime(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	deadline, _ := time.Parse(time.RFC3339, escrow.Deadline)
+	if !now.Before(deadline) {
+		return shim.Error(fmt.Sprintf("Escrow %s passed its deadline and can only be refunded", escrow.ID))
+	}
+
+	escrow.Approvals = append(escrow.Approvals, submitter.ID)
+	if len(escrow.Approvals) >= escrow.RequiredApprovals {
+		// Either party may have been listed since the funds were locked
+		if err := screenParties(stub, "approveEscrow", escrow.Payer, escrow.Recipient); err != nil {
+			return shim.Error(err.Error())
+		}
+		if err := credit(stub, escrow.Recipient, escrow.Amount); err != nil {
+			return shim.Error(err.Error())
+		}
+		escrow.Status = EscrowReleased
+		escrow.SettledAt = now.Format(time.RFC3339)
+		if _, err := writeJournal(stub, "releaseEscrow", []TransferLeg{{From: escrowPrefix + escrow.ID, To: escrow.Recipient, Amount: escrow.Amount}}); err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+	if err := putEscrow(stub, escrow); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	escrowAsBytes, _ := json.Marshal(escrow)
+	return shim.Success(escrowAsBytes)
+}
+
+// ==== refundEscrow =========================================
+// refundEscrow returns locked funds to the payer once the deadline has passed.
+//   0
+// "escrowid"
+// ===========================================================================================
+func (t *SimpleChaincode) refundEscrow(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	escrow, err := getEscrow(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if escrow.Status != EscrowLocked {
+		return shim.Error(fmt.Sprintf("Escrow %s is already %s", escrow.ID, escrow.Status))
+	}
+
+	now, err := txTime(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	deadline, _ := time.Parse(time.RFC3339, escrow.Deadline)
+	if now.Before(deadline) {
+		return shim.Error(fmt.Sprintf("Escrow %s cannot be refunded before %s", escrow.ID, escrow.Deadline))
+	}
+
+	if err := credit(stub, escrow.Payer, escrow.Amount); err != nil {
+		return shim.Error(err.Error())
+	}
+	escrow.Status = EscrowRefunded
+	escrow.SettledAt = now.Format(time.RFC3339)
+	if err := putEscrow(stub, escrow); err != nil {
+		return shim.Error(err.Error())
+	}
+	if _, err := writeJournal(stub, "refundEscrow", []TransferLeg{{From: escrowPrefix + escrow.ID, To: escrow.Payer, Amount: escrow.Amount}}); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	escrowAsBytes, _ := json.Marshal(escrow)
+	return shim.Success(escrowAsBytes)
+}
+
+// ==== queryEscrow =========================================
+//   0
+// "escrowid"
+// ===========================================================================================
+func (t *SimpleChaincode) queryEscrow(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	escrowAsBytes, err := stub.GetState(escrowPrefix + args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if escrowAsBytes == nil {
+		return shim.Error("Escrow not found: " + args[0])
+	}
+	return shim.Success(escrowAsBytes)
+}
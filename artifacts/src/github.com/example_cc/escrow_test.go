@@ -0,0 +1,110 @@
+package main
+
+import "testing"
+
+const escrowDeadline = "2017-11-20T00:00:00Z"
+
+func TestCreateEscrowRequiresPayer(t *testing.T) {
+	s := newTestStub(t)
+	s.register("mallory", "Mal", "Lory", "")
+	s.register("carol", "Carol", "Cole", "")
+	s.register("dave", "Dave", "Dunn", "")
+	s.fund("mallory", 0)
+
+	// Naming someone else as payer must not lock, and later release, their funds
+	s.mustFail("Only a can lock its funds", "mallory", "createEscrow", "e1", "a", "mallory", "100", `["mallory"]`, "1", escrowDeadline)
+	s.expectBalance("a", 1000)
+
+	s.mustInvoke("a", "createEscrow", "e1", "a", "b", "100", `["carol","dave"]`, "2", escrowDeadline)
+	s.expectBalance("a", 900)
+	s.mustFail("not a release party", "mallory", "approveEscrow", "e1")
+	s.mustInvoke("carol", "approveEscrow", "e1")
+	s.expectBalance("b", 1000)
+	s.mustInvoke("dave", "approveEscrow", "e1")
+	s.expectBalance("b", 1100)
+}
+
+func TestCreateEscrowChecks(t *testing.T) {
+	s := newTestStub(t)
+	s.grant(RoleCompliance, "officer")
+	s.register("alice", "Alice", "Able", KYCTier0)
+	s.fund("alice", 1000)
+
+	s.mustFail(LimitExceeded, "alice", "createEscrow", "e1", "alice", "b", "150", `["carol"]`, "1", escrowDeadline)
+
+	s.mustInvoke("officer", "addSanctionsEntry", "SDN-1", "Mallory", "Evil", SanctionBlock, "OFAC")
+	s.register("mallory", "Mallory", "Evil", "")
+	s.fund("mallory", 0)
+	s.mustFail(SanctionsBlocked, "alice", "createEscrow", "e1", "alice", "mallory", "50", `["carol"]`, "1", escrowDeadline)
+
+	s.mustInvoke("officer", "setVelocityRule", `{"id":"newpayee","type":"NEW_COUNTERPARTY","minamount":50,"action":"HOLD"}`)
+	s.mustFail(VelocityHeld, "alice", "createEscrow", "e1", "alice", "b", "50", `["carol"]`, "1", escrowDeadline)
+	s.expectBalance("alice", 1000)
+}
+
+func TestEscrowReleasesOnApprovals(t *testing.T) {
+	s := newTestStub(t)
+	s.register("mallory", "Mal", "Lory", "")
+	s.register("carol", "Carol", "Cole", "")
+	s.register("dave", "Dave", "Dunn", "")
+
+	s.mustFail("Required approvals must be between 1 and 2", "a", "createEscrow", "e1", "a", "b", "100", `["carol","dave"]`, "3", escrowDeadline)
+	s.mustInvoke("a", "createEscrow", "e1", "a", "b", "100", `["carol","dave"]`, "2", escrowDeadline)
+	s.expectBalance("a", 900)
+	s.mustFail("Escrow already exists: e1", "a", "createEscrow", "e1", "a", "b", "100", `["carol"]`, "1", escrowDeadline)
+
+	s.mustFail("mallory is not a release party of escrow e1", "mallory", "approveEscrow", "e1")
+	s.mustInvoke("carol", "approveEscrow", "e1")
+	s.mustFail("carol has already approved escrow e1", "carol", "approveEscrow", "e1")
+	s.expectBalance("b", 1000)
+	s.mustInvoke("dave", "approveEscrow", "e1")
+	s.expectBalance("b", 1100)
+
+	var escrow Escrow
+	s.get(escrowPrefix+"e1", &escrow)
+	if escrow.Status != EscrowReleased {
+		t.Fatalf("escrow e1 is %s, expected %s", escrow.Status, EscrowReleased)
+	}
+	s.mustFail("Escrow e1 is already RELEASED", "a", "refundEscrow", "e1")
+}
+
+func TestEscrowRefundsAfterDeadline(t *testing.T) {
+	s := newTestStub(t)
+	s.register("carol", "Carol", "Cole", "")
+	s.mustInvoke("a", "createEscrow", "e1", "a", "b", "100", `["carol"]`, "1", escrowDeadline)
+
+	s.mustFail("Escrow e1 cannot be refunded before "+escrowDeadline, "a", "refundEscrow", "e1")
+	s.now = s.now.AddDate(0, 0, 19)
+	s.mustFail("Escrow e1 passed its deadline and can only be refunded", "carol", "approveEscrow", "e1")
+	s.mustInvoke("a", "refundEscrow", "e1")
+	s.expectBalance("a", 1000)
+	s.expectBalance("b", 1000)
+}
+
+func TestEscrowPartiesMatchTheirMSP(t *testing.T) {
+	s := newTestStub(t)
+	s.register("carol", "Carol", "Cole", "")
+
+	// The same enrollment ID issued by another organization is not the same party
+	s.mustFailFrom("is not the registered a", "Org2MSP", "a", "createEscrow", "e1", "a", "b", "100", `["carol"]`, "1", escrowDeadline)
+	s.expectBalance("a", 1000)
+
+	s.mustInvoke("a", "createEscrow", "e1", "a", "b", "100", `["carol"]`, "1", escrowDeadline)
+	s.mustFailFrom("is not the registered carol", "Org2MSP", "carol", "approveEscrow", "e1")
+	s.mustFail("is not a registered user or biller", "dave", "approveEscrow", "e1")
+	s.expectBalance("b", 1000)
+}
+
+func TestApproveEscrowScreensParties(t *testing.T) {
+	s := newTestStub(t)
+	s.grant(RoleCompliance, "officer")
+	s.register("carol", "Carol", "Cole", "")
+	s.register("mallory", "Mal", "Lory", "")
+	s.fund("mallory", 0)
+	s.mustInvoke("a", "createEscrow", "e1", "a", "mallory", "100", `["carol"]`, "1", escrowDeadline)
+
+	// Listed after the funds were locked
+	s.mustInvoke("officer", "addSanctionsEntry", "SDN-1", "Mal", "Lory", SanctionBlock, "OFAC")
+	s.mustFail(SanctionsBlocked, "carol", "approveEscrow", "e1")
+	s.expectBalance("mallory", 0)
+}
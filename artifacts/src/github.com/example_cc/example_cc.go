@@ -21,7 +21,9 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"math"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/hyperledger/fabric/core/chaincode/shim"
@@ -42,9 +44,7 @@ type Bill struct {
         ID string `json:"id"`
         BillID string `json:"billid"`
         RecipientID string `json:"recipientid"`
-        UserID string `json:"userid"`
-        FirstName  string `json:"firstname"`
-        LastName string `json:"lastname"`
+        UserID string `json:"userid"`		//registered user, see UserProfile in user.go
         BillDate string `json:"billdate"`
 		BillDueDate string `json:"billduedate"`
 		CreatedAt string `json:"created_at"`
@@ -53,6 +53,29 @@ type Bill struct {
         Currency string `json:"currency"`
         Image string `json:"image"`
         Timestamp string `json:"tr_time"`	//utc timestamp of creation
+        Status string `json:"status"`		//see the Bill statuses in bills.go
+        LineItems []LineItem `json:"lineitems"`
+        Jurisdiction string `json:"jurisdiction"`
+        Subtotal int `json:"subtotal"`		//line items total before tax
+        TaxTotal int `json:"taxtotal"`
+        TaxBreakdown []TaxLine `json:"taxbreakdown"`
+        PaidAmount int `json:"paidamount"`
+        Outstanding int `json:"outstanding"`
+        AdjustmentTotal int `json:"adjustmenttotal"`		//net of debit notes less credit notes; Amount itself never changes
+        EffectiveAmount int `json:"effectiveamount"`
+        PaidAt string `json:"paidat"`
+        Version int `json:"version"`		//starts at 1, incremented by amendBill
+        AmendReason string `json:"amendreason"`
+        AmendedBy string `json:"amendedby"`
+        AmendedAt string `json:"amendedat"`
+        DisputeID string `json:"disputeid"`		//open dispute, blocks collection and auto-pay until resolved
+        Overdue bool `json:"overdue"`		//set by markOverdue once the due date has passed
+        LateFeeTotal int `json:"latefeetotal"`
+        LateFeeThrough string `json:"latefeethrough"`		//date up to which late fees have been charged
+        Terms PaymentTerms `json:"terms"`
+        DiscountTaken int `json:"discounttaken"`		//early-payment discount granted when the bill was paid
+        ParentID string `json:"parentid"`		//split bill this bill is a share of
+        Shares []BillShare `json:"shares"`		//payers of a split bill
 }
 
 type AllBills struct{
@@ -62,9 +85,7 @@ type AllBills struct{
 // Define the Payment structure, with 12 properties.  Structure tags are used by encoding/json library
 type Payment struct {
         ID string `json:"id"`
-        UserID string `json:"userid"`
-        FirstName  string `json:"firstname"`
-        LastName string `json:"lastname"`
+        UserID string `json:"userid"`		//registered user, see UserProfile in user.go
         Status string `json:"status"`        
         ExchRate string `json:"exchrate"`
         Fees string `json:"fees"`
@@ -77,6 +98,11 @@ type Payment struct {
         ProcessedAt string `json:"processedat"`
         CreatedAt string `json:"createdat"`
         Timestamp string `json:"tr_time"`	//utc timestamp of creation
+        Allocations []Allocation `json:"allocations"`	//bills this payment was applied to
+        Unallocated int `json:"unallocated"`
+        DiscountTotal int `json:"discounttotal"`	//early-payment discounts taken on the bills paid
+        Held bool `json:"held"`	//held by a velocity rule, not applied to bills until released
+        Requested []Allocation `json:"requested"`	//allocations requested for a held payment, applied on release
 }
 
 func (t *SimpleChaincode) Init(stub shim.ChaincodeStubInterface) pb.Response  {
@@ -126,6 +152,16 @@ func (t *SimpleChaincode) Init(stub shim.ChaincodeStubInterface) pb.Response  {
 		return shim.Error(err.Error())
 	}
 
+	// The instantiating identity becomes the first admin, who can grant further roles
+	instantiator, err := getSubmitter(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = putRole(stub, instantiator, RoleAdmin)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
 	return shim.Success(nil)
 }
 
@@ -133,6 +169,7 @@ func (t *SimpleChaincode) Init(stub shim.ChaincodeStubInterface) pb.Response  {
 func (t *SimpleChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
 	logger.Info("########### example_cc0 Invoke ###########")
 
+	stub = newTxStub(stub)
 	function, args := stub.GetFunctionAndParameters()
 	
 	if function == "delete" {
@@ -147,6 +184,250 @@ func (t *SimpleChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
 		// Deletes an entity from its state
 		return t.move(stub, args)
 	}
+	if function == "batchMove" {
+		// Applies several transfers atomically
+		return t.batchMove(stub, args)
+	}
+	if function == "authorize" {
+		return t.authorize(stub, args)
+	}
+	if function == "capture" {
+		return t.capture(stub, args)
+	}
+	if function == "voidHold" {
+		return t.voidHold(stub, args)
+	}
+	if function == "listHolds" {
+		return t.listHolds(stub, args)
+	}
+	if function == "queryBalance" {
+		return t.queryBalance(stub, args)
+	}
+	if function == "createEscrow" {
+		return t.createEscrow(stub, args)
+	}
+	if function == "approveEscrow" {
+		return t.approveEscrow(stub, args)
+	}
+	if function == "refundEscrow" {
+		return t.refundEscrow(stub, args)
+	}
+	if function == "queryEscrow" {
+		return t.queryEscrow(stub, args)
+	}
+	if function == "lockHTLC" {
+		return t.lockHTLC(stub, args)
+	}
+	if function == "claimHTLC" {
+		return t.claimHTLC(stub, args)
+	}
+	if function == "refundHTLC" {
+		return t.refundHTLC(stub, args)
+	}
+	if function == "queryHTLC" {
+		return t.queryHTLC(stub, args)
+	}
+	if function == "createStandingOrder" {
+		return t.createStandingOrder(stub, args)
+	}
+	if function == "cancelStandingOrder" {
+		return t.cancelStandingOrder(stub, args)
+	}
+	if function == "queryStandingOrder" {
+		return t.queryStandingOrder(stub, args)
+	}
+	if function == "processDue" {
+		return t.processDue(stub, args)
+	}
+	if function == "approve" {
+		return t.approve(stub, args)
+	}
+	if function == "transferFrom" {
+		return t.transferFrom(stub, args)
+	}
+	if function == "allowance" {
+		return t.allowance(stub, args)
+	}
+	if function == "revokeAllowance" {
+		return t.revokeAllowance(stub, args)
+	}
+	if function == "createMandate" {
+		return t.createMandate(stub, args)
+	}
+	if function == "revokeMandate" {
+		return t.revokeMandate(stub, args)
+	}
+	if function == "queryMandate" {
+		return t.queryMandate(stub, args)
+	}
+	if function == "collectBill" {
+		return t.collectBill(stub, args)
+	}
+	if function == "setAutoPay" {
+		return t.setAutoPay(stub, args)
+	}
+	if function == "disableAutoPay" {
+		return t.disableAutoPay(stub, args)
+	}
+	if function == "queryAutoPay" {
+		return t.queryAutoPay(stub, args)
+	}
+	if function == "autoPayDue" {
+		return t.autoPayDue(stub, args)
+	}
+	if function == "grantRole" {
+		return t.grantRole(stub, args)
+	}
+	if function == "revokeRole" {
+		return t.revokeRole(stub, args)
+	}
+	if function == "queryRoles" {
+		return t.queryRoles(stub, args)
+	}
+	if function == "setTaxRate" {
+		return t.setTaxRate(stub, args)
+	}
+	if function == "queryTaxRates" {
+		return t.queryTaxRates(stub, args)
+	}
+	if function == "queryTaxCollected" {
+		return t.queryTaxCollected(stub, args)
+	}
+	if function == "setAllocationRule" {
+		return t.setAllocationRule(stub, args)
+	}
+	if function == "queryBillAllocations" {
+		return t.queryBillAllocations(stub, args)
+	}
+	if function == "createCreditNote" {
+		return t.createCreditNote(stub, args)
+	}
+	if function == "createDebitNote" {
+		return t.createDebitNote(stub, args)
+	}
+	if function == "queryBillAdjustments" {
+		return t.queryBillAdjustments(stub, args)
+	}
+	if function == "amendBill" {
+		return t.amendBill(stub, args)
+	}
+	if function == "queryBillVersions" {
+		return t.queryBillVersions(stub, args)
+	}
+	if function == "openDispute" {
+		return t.openDispute(stub, args)
+	}
+	if function == "respondDispute" {
+		return t.respondDispute(stub, args)
+	}
+	if function == "resolveDispute" {
+		return t.resolveDispute(stub, args)
+	}
+	if function == "queryDispute" {
+		return t.queryDispute(stub, args)
+	}
+	if function == "setLateFeeRule" {
+		return t.setLateFeeRule(stub, args)
+	}
+	if function == "queryLateFeeRule" {
+		return t.queryLateFeeRule(stub, args)
+	}
+	if function == "markOverdue" {
+		return t.markOverdue(stub, args)
+	}
+	if function == "queryAging" {
+		return t.queryAging(stub, args)
+	}
+	if function == "createInstallmentPlan" {
+		return t.createInstallmentPlan(stub, args)
+	}
+	if function == "queryInstallmentPlan" {
+		return t.queryInstallmentPlan(stub, args)
+	}
+	if function == "createBillTemplate" {
+		return t.createBillTemplate(stub, args)
+	}
+	if function == "cancelBillTemplate" {
+		return t.cancelBillTemplate(stub, args)
+	}
+	if function == "queryBillTemplate" {
+		return t.queryBillTemplate(stub, args)
+	}
+	if function == "generateBills" {
+		return t.generateBills(stub, args)
+	}
+	if function == "splitBill" {
+		return t.splitBill(stub, args)
+	}
+	if function == "querySplitBill" {
+		return t.querySplitBill(stub, args)
+	}
+	if function == "queryRecipientBills" {
+		return t.queryRecipientBills(stub, args)
+	}
+	if function == "queryRecipientOutstanding" {
+		return t.queryRecipientOutstanding(stub, args)
+	}
+	if function == "reindexRecipients" {
+		return t.reindexRecipients(stub, args)
+	}
+	if function == "registerBiller" {
+		return t.registerBiller(stub, args)
+	}
+	if function == "verifyBiller" {
+		return t.verifyBiller(stub, args)
+	}
+	if function == "suspendBiller" {
+		return t.suspendBiller(stub, args)
+	}
+	if function == "queryBiller" {
+		return t.queryBiller(stub, args)
+	}
+	if function == "registerUser" {
+		return t.registerUser(stub, args)
+	}
+	if function == "updateUserProfile" {
+		return t.updateUserProfile(stub, args)
+	}
+	if function == "setKYCStatus" {
+		return t.setKYCStatus(stub, args)
+	}
+	if function == "queryUser" {
+		return t.queryUser(stub, args)
+	}
+	if function == "setKYCTier" {
+		return t.setKYCTier(stub, args)
+	}
+	if function == "setTierLimits" {
+		return t.setTierLimits(stub, args)
+	}
+	if function == "queryLimits" {
+		return t.queryLimits(stub, args)
+	}
+	if function == "addSanctionsEntry" {
+		return t.addSanctionsEntry(stub, args)
+	}
+	if function == "removeSanctionsEntry" {
+		return t.removeSanctionsEntry(stub, args)
+	}
+	if function == "queryScreeningFlags" {
+		return t.queryScreeningFlags(stub, args)
+	}
+	if function == "setVelocityRule" {
+		return t.setVelocityRule(stub, args)
+	}
+	if function == "removeVelocityRule" {
+		return t.removeVelocityRule(stub, args)
+	}
+	if function == "queryVelocityRules" {
+		return t.queryVelocityRules(stub, args)
+	}
+	if function == "queryVelocityAlerts" {
+		return t.queryVelocityAlerts(stub, args)
+	}
+	if function == "releaseHeldPayment" {
+		return t.releaseHeldPayment(stub, args)
+	}
     if function == "createBill" {
             return t.createBill(stub, args)
     }
@@ -217,8 +498,48 @@ func (t *SimpleChaincode) move(stub shim.ChaincodeStubInterface, args []string)
 
 	// Perform the execution
 	X, err = strconv.Atoi(args[2])
+	if err != nil || X <= 0 {
+		return shim.Error("Invalid transaction amount, expecting a positive integer value")
+	}
+
+	// Funds reserved by holds are not available to move
+	now, err := txTime(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	held, err := heldAmount(stub, A, now)
 	if err != nil {
-		return shim.Error("Invalid transaction amount, expecting a integer value")
+		return shim.Error(err.Error())
+	}
+	if X > Aval-held {
+		return shim.Error(fmt.Sprintf("Insufficient available funds in %s: available %d, requested %d", A, Aval-held, X))
+	}
+	// KYC tier limits of the paying account
+	if err := enforceLimits(stub, A, X, now); err != nil {
+		return shim.Error(err.Error())
+	}
+	// Sanctions screening of both parties
+	if err := screenParties(stub, "move", A, B); err != nil {
+		return shim.Error(err.Error())
+	}
+	// Velocity and anomaly rules. A held transfer reserves the funds for review instead of moving them.
+	alert, err := checkVelocity(stub, "move", A, B, X, now)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if alert != nil {
+		if alert.Action == VelocityHold {
+			if err := holdForReview(stub, alert, now); err != nil {
+				return shim.Error(err.Error())
+			}
+		}
+		if err := raiseAlerts(stub, []VelocityAlert{*alert}); err != nil {
+			return shim.Error(err.Error())
+		}
+		if alert.Action == VelocityHold {
+			alertAsBytes, _ := json.Marshal(alert)
+			return shim.Success(alertAsBytes)
+		}
 	}
 	Aval = Aval - X
 	Bval = Bval + X
@@ -279,6 +600,13 @@ func (t *SimpleChaincode) query(stub shim.ChaincodeStubInterface, args []string)
 		return shim.Error(jsonResp)
 	}
 
+	// Report the available amount, i.e. the holding less any active holds
+	_, available, err := availableBalance(stub, A)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	Avalbytes = []byte(strconv.Itoa(available))
+
 	jsonResp := "{\"Name\":\"" + A + "\",\"Amount\":\"" + string(Avalbytes) + "\"}"
 	logger.Infof("Query Response:%s\n", jsonResp)
 	return shim.Success(Avalbytes)
@@ -286,89 +614,182 @@ func (t *SimpleChaincode) query(stub shim.ChaincodeStubInterface, args []string)
 
 func (t *SimpleChaincode) createBill(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 
-        if len(args) != 13 {
-                return shim.Error("Incorrect number of arguments. Expecting 13")
+        if len(args) < 13 || len(args) > 16 {
+                return shim.Error("Incorrect number of arguments. Expecting 13, or 14 with line items, 15 with line items and tax jurisdiction, or 16 with payment terms")
         }
 
-        billTrTime := time.Now().String()
-
-        var bill = Bill{ID: args[0], BillID: args[1], RecipientID: args[2], UserID: args[3], FirstName: args[4], LastName: args[5], BillDate: args[6], BillDueDate: args[7], CreatedAt: args[8], Description: args[9], Amount: args[10], Currency: args[11], Image: args[12], Timestamp: billTrTime}
-
-        billAsBytes, _ := json.Marshal(bill)
-        stub.PutState("BILL"+args[0], billAsBytes)
-        //stub.PutState("BILL"+strconv.Itoa(args[0]), billAsBytes)
-        //stub.PutState(args[0], billAsBytes)
-
-
-		//  ==== Index the marble to enable color-based range queries, e.g. return all blue marbles ====
-		//  An 'index' is a normal key/value entry in state.
-		//  The key is a composite key, with the elements that you want to range query on listed first.
-		//  In our case, the composite key is based on indexName~color~name.
-		//  This will enable very efficient state range queries based on composite keys matching indexName~color~*
-		indexName := "userid~id"
-		personIndexKey, err := stub.CreateCompositeKey(indexName, []string{bill.UserID, bill.ID})
-		if err != nil {
-			return shim.Error(err.Error())
-		}
-		//  Save index entry to state. Only the key name is needed, no need to store a duplicate copy of the marble.
-		//  Note - passing a 'nil' value will effectively delete the key from state, therefore we pass null character as value
-		value := []byte{0x00}
-		stub.PutState(personIndexKey, value)
-
+        now, err := txTime(stub)
+        if err != nil {
+                return shim.Error(err.Error())
+        }
+        billTrTime := now.Format(time.RFC3339)
 
-		//  ==== Index the bills ====
-		//  Write all bill IDs under the predefined string index
-		//  ==== Index the bills ====
-		
-		jsonAsBytes, _ := json.Marshal(bill)
-		//get the bills index
-		billAsByte , err := stub.GetState(billIndexStr)
-		if err != nil {
-			return shim.Error("Failed to get bill index")
-		}
-/*		var billIndex []string
-		json.Unmarshal(billAsByte, &billIndex)							//un stringify it aka JSON.parse()
-		
-		//append
-		billIndex = append(billIndex, args[0])									//add points name to index list
-		fmt.Println("! bill index: ", billIndex)
-		jsonAsBytes, _ := json.Marshal(billIndex)
-		err = stub.PutState(billIndexStr, jsonAsBytes) 
-		//  ==== End Index the bills ====*/
+        var bill = Bill{ID: args[0], BillID: args[1], RecipientID: args[2], UserID: args[3], BillDate: args[6], BillDueDate: args[7], CreatedAt: args[8], Description: args[9], Amount: args[10], Currency: args[11], Image: args[12], Timestamp: billTrTime, Status: BillIssued, Version: 1}
 
+        // Only a verified biller may issue bills, and only in its own name
+        if err := checkBillIssuer(stub, bill); err != nil {
+                return shim.Error(err.Error())
+        }
+        // The user's name lives in the user registry; args 4 and 5 are accepted for compatibility and ignored
+        if _, err := getUserProfile(stub, bill.UserID); err != nil {
+                return shim.Error(err.Error())
+        }
+        // Reissuing an ID would overwrite the bill and index it twice
+        existing, err := stub.GetState(billPrefix + bill.ID)
+        if err != nil {
+                return shim.Error(err.Error())
+        }
+        if existing != nil {
+                return shim.Error("Bill already exists: " + bill.ID)
+        }
 
-		var bills AllBills
-		json.Unmarshal(billAsByte, &bills)										//un stringify it aka JSON.parse()
-		
-		bills.Bills = append(bills.Bills, bill);						        //append new bill to Bills
-		fmt.Println("! appended new Bill to Bills")
-		jsonAsBytes, _ = json.Marshal(bills)
-		stub.PutState(billIndexStr, jsonAsBytes)								//rewrite Bills
+        // Itemized bills carry their line items as a JSON list; the amount is computed from them
+        if len(args) >= 14 && args[13] != "" {
+                if err := json.Unmarshal([]byte(args[13]), &bill.LineItems); err != nil {
+                        return shim.Error("Invalid line items, expecting a JSON list of {description, quantity, unitprice, taxcode, discount}")
+                }
+                if len(args) >= 15 {
+                        bill.Jurisdiction = args[14]
+                }
+                if err := bill.applyLineItems(stub); err != nil {
+                        return shim.Error(err.Error())
+                }
+        }
+        // Payment terms such as "2/10 net 30"; without a due date the bill is due after the net period
+        if len(args) == 16 && args[15] != "" {
+                terms, err := parsePaymentTerms(args[15])
+                if err != nil {
+                        return shim.Error(err.Error())
+                }
+                billDate, err := time.Parse(dateLayout, bill.BillDate)
+                if err != nil {
+                        return shim.Error("Bills with payment terms need a bill date formatted YYYY-MM-DD")
+                }
+                bill.Terms = terms
+                if bill.BillDueDate == "" {
+                        bill.BillDueDate = billDate.AddDate(0, 0, terms.NetDays).Format(dateLayout)
+                }
+        }
+        if amount, err := strconv.Atoi(bill.Amount); err == nil {
+                bill.Outstanding = amount
+                bill.EffectiveAmount = amount
+        }
 
+        // Store the bill and index it under its user, its recipient and in the bill index
+        if err := addBill(stub, bill); err != nil {
+                return shim.Error(err.Error())
+        }
 
 		// ==== Bill saved and indexed. Return success ====
         return shim.Success(nil)
 }
 
+// ==== queryBill =========================================
+// Pass "profile" as the second argument to get the bill together with its user's profile
+// 0        1
+// "key"    "profile"
+// ===========================================================================================
 func (t *SimpleChaincode) queryBill(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 
-	if len(args) != 1 {
-		return shim.Error("Incorrect number of arguments. Expecting 1")
+	if len(args) != 1 && len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 1, or 2 to expand the user profile")
 	}
 
 	billAsBytes, _ := stub.GetState(args[0])
+
+	// Report the amounts after credit and debit notes, also for bills stored before they were tracked
+	var bill Bill
+	if err := json.Unmarshal(billAsBytes, &bill); err == nil && bill.ID != "" {
+		if effective, err := bill.effectiveAmount(); err == nil {
+			bill.EffectiveAmount = effective
+			bill.Outstanding = effective - bill.PaidAmount - bill.DiscountTaken
+			billAsBytes, _ = json.Marshal(bill)
+		}
+		if len(args) == 2 && args[1] == "profile" {
+			respAsBytes, err := withProfile(stub, "bill", billAsBytes, bill.UserID)
+			if err != nil {
+				return shim.Error(err.Error())
+			}
+			return shim.Success(respAsBytes)
+		}
+	}
 	return shim.Success(billAsBytes)
 }
 
 func (t *SimpleChaincode) createPayment(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 
-        if len(args) != 15 {
-                return shim.Error("Incorrect number of arguments. Expecting 15")
+        if len(args) != 15 && len(args) != 16 {
+                return shim.Error("Incorrect number of arguments. Expecting 15, or 16 with bill allocations")
         }
 
         paymentTrTime := time.Now().String()
 
-        var pay = Payment{ID: args[0], UserID: args[1], FirstName: args[2], LastName: args[3], Status: args[4], ExchRate: args[5], Fees: args[6], FxRate: args[7], SourceAmount: args[8], TargetAmount: args[9], SourceCurrency: args[10], TargetCurrency: args[11], Memo: args[12], ProcessedAt: args[13], CreatedAt: args[14], Timestamp: paymentTrTime}
+        var pay = Payment{ID: args[0], UserID: args[1], Status: args[4], ExchRate: args[5], Fees: args[6], FxRate: args[7], SourceAmount: args[8], TargetAmount: args[9], SourceCurrency: args[10], TargetCurrency: args[11], Memo: args[12], ProcessedAt: args[13], CreatedAt: args[14], Timestamp: paymentTrTime}
+
+        // A payment ID is used once; replaying it would apply its allocations again
+        if existing, err := stub.GetState("PAYMENT" + pay.ID); err != nil {
+                return shim.Error(err.Error())
+        } else if existing != nil {
+                return shim.Error("Payment already exists: " + pay.ID)
+        }
+
+        // The user's name lives in the user registry; args 2 and 3 are accepted for compatibility and ignored
+        if _, err := getUserProfile(stub, pay.UserID); err != nil {
+                return shim.Error(err.Error())
+        }
+        // Recording a payment marks the user's bills paid and uses up its limits, so only the user may record it
+        submitter, err := getParty(stub)
+        if err != nil {
+                return shim.Error(err.Error())
+        }
+        if submitter.ID != pay.UserID {
+                return shim.Error(fmt.Sprintf("Only %s can record its payments", pay.UserID))
+        }
+
+        // KYC tier limits of the paying user. Payments without bills to allocate
+        // to may carry a decimal target amount, which counts rounded up.
+        target, err := strconv.ParseFloat(pay.TargetAmount, 64)
+        if err != nil || target <= 0 {
+                return shim.Error("Invalid target amount, expecting a positive value")
+        }
+        payAmount := int(math.Ceil(target))
+        now, err := txTime(stub)
+        if err != nil {
+                return shim.Error(err.Error())
+        }
+        if err := enforceLimits(stub, pay.UserID, payAmount, now); err != nil {
+                return shim.Error(err.Error())
+        }
+        // Sanctions screening of the paying user; the recipients are screened as bills are paid
+        if err := screenParties(stub, "createPayment", pay.UserID); err != nil {
+                return shim.Error(err.Error())
+        }
+
+        // Apply the payment to the user's bills, as requested or by the user's allocation rule
+        var requested []Allocation
+        if len(args) == 16 && args[15] != "" {
+                if err := json.Unmarshal([]byte(args[15]), &requested); err != nil {
+                        return shim.Error("Invalid allocations, expecting a JSON list of {billid, amount}")
+                }
+        }
+        // Velocity and anomaly rules. A held payment stays unallocated until compliance releases it.
+        alert, err := checkVelocity(stub, "createPayment", pay.UserID, "", payAmount, now)
+        if err != nil {
+                return shim.Error(err.Error())
+        }
+        if alert != nil {
+                alert.PaymentID = pay.ID
+                pay.Held = alert.Action == VelocityHold
+                if err := raiseAlerts(stub, []VelocityAlert{*alert}); err != nil {
+                        return shim.Error(err.Error())
+                }
+        }
+        if pay.Held {
+                pay.Unallocated = payAmount
+                pay.Requested = requested
+        } else if err := allocatePayment(stub, &pay, requested); err != nil {
+                return shim.Error(err.Error())
+        }
 
         payAsBytes, _ := json.Marshal(pay)
         stub.PutState("PAYMENT"+args[0], payAsBytes)
@@ -389,13 +810,26 @@ func (t *SimpleChaincode) createPayment(stub shim.ChaincodeStubInterface, args [
         return shim.Success(nil)
 }
 
+// ==== queryPayment =========================================
+// Pass "profile" as the second argument to get the payment together with its user's profile
+// 0        1
+// "key"    "profile"
+// ===========================================================================================
 func (t *SimpleChaincode) queryPayment(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 
-	if len(args) != 1 {
-		return shim.Error("Incorrect number of arguments. Expecting 1")
+	if len(args) != 1 && len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 1, or 2 to expand the user profile")
 	}
 
 	payAsBytes, _ := stub.GetState(args[0])
+	var pay Payment
+	if len(args) == 2 && args[1] == "profile" && json.Unmarshal(payAsBytes, &pay) == nil && pay.ID != "" {
+		respAsBytes, err := withProfile(stub, "payment", payAsBytes, pay.UserID)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		return shim.Success(respAsBytes)
+	}
 	return shim.Success(payAsBytes)
 }
 
@@ -0,0 +1,450 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+var holdPrefix = "HOLD"                               //prefix for the key/value that stores a hold
+var holdIndexName = "account~holdid"                  //composite index of holds by the account they reserve funds on
+var activeHoldIndexName = "activehold~account~holdid" //composite index of the authorized holds of an account, which heldAmount sums
+
+// Hold statuses
+const (
+	HoldAuthorized = "AUTHORIZED"
+	HoldCaptured   = "CAPTURED"
+	HoldVoided     = "VOIDED"
+	HoldExpired    = "EXPIRED" //reported by listHolds only, never stored
+)
+
+// Hold reserves part of an account's holding for a payee until it is captured, voided or expires
+type Hold struct {
+	ID        string `json:"id"`
+	Account   string `json:"account"`
+	Payee     string `json:"payee"`
+	Amount    int    `json:"amount"`
+	Captured  int    `json:"captured"`
+	Status    string `json:"status"`
+	ExpiresAt string `json:"expires_at"`
+	CreatedAt string `json:"created_at"`
+	UpdatedAt string `json:"updated_at"`
+}
+
+// active reports whether the hold still reserves funds at the given time
+func (h Hold) active(now time.Time) bool {
+	if h.Status != HoldAuthorized {
+		return false
+	}
+	expiry, err := time.Parse(time.RFC3339, h.ExpiresAt)
+	return err == nil && now.Before(expiry)
+}
+
+// isReview reports whether a velocity rule placed the hold, in which case
+// only compliance may capture or void it
+func (h Hold) isReview() bool {
+	return strings.HasPrefix(h.ID, reviewHoldPrefix)
+}
+
+func getHold(stub shim.ChaincodeStubInterface, id string) (Hold, error) {
+	var hold Hold
+	holdAsBytes, err := stub.GetState(holdPrefix + id)
+	if err != nil {
+		return hold, fmt.Errorf("Failed to get hold %s", id)
+	}
+	if holdAsBytes == nil {
+		return hold, fmt.Errorf("Hold not found: %s", id)
+	}
+	err = json.Unmarshal(holdAsBytes, &hold)
+	return hold, err
+}
+
+func putHold(stub shim.ChaincodeStubInterface, hold Hold) error {
+	holdAsBytes, _ := json.Marshal(hold)
+	return stub.PutState(holdPrefix+hold.ID, holdAsBytes)
+}
+
+// indexHold adds a new hold to the indexes of its account
+func indexHold(stub shim.ChaincodeStubInterface, hold Hold) error {
+	holdIndexKey, err := stub.CreateCompositeKey(holdIndexName, []string{hold.Account, hold.ID})
+	if err != nil {
+		return err
+	}
+	if err := stub.PutState(holdIndexKey, []byte{0x00}); err != nil {
+		return err
+	}
+	activeKey, err := stub.CreateCompositeKey(activeHoldIndexName, []string{hold.Account, hold.ID})
+	if err != nil {
+		return err
+	}
+	return stub.PutState(activeKey, []byte{0x00})
+}
+
+// settleHold drops a captured, voided or expired hold from the active holds of its account
+func settleHold(stub shim.ChaincodeStubInterface, hold Hold) error {
+	activeKey, err := stub.CreateCompositeKey(activeHoldIndexName, []string{hold.Account, hold.ID})
+	if err != nil {
+		return err
+	}
+	return stub.DelState(activeKey)
+}
+
+// holdsForAccount returns every hold ever placed on an account, in hold ID order
+func holdsForAccount(stub shim.ChaincodeStubInterface, account string) ([]Hold, error) {
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(holdIndexName, []string{account})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	var holds []Hold
+	for resultsIterator.HasNext() {
+		responseRange, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		_, compositeKeyParts, err := stub.SplitCompositeKey(responseRange.Key)
+		if err != nil {
+			return nil, err
+		}
+		hold, err := getHold(stub, compositeKeyParts[1])
+		if err != nil {
+			return nil, err
+		}
+		holds = append(holds, hold)
+	}
+	return holds, nil
+}
+
+// heldAmount sums the unexpired authorized holds on an account. Only the
+// active index is read, so settled holds cost nothing; expired holds are
+// dropped from it as they are found.
+func heldAmount(stub shim.ChaincodeStubInterface, account string, now time.Time) (int, error) {
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(activeHoldIndexName, []string{account})
+	if err != nil {
+		return 0, err
+	}
+	defer resultsIterator.Close()
+
+	var expired []Hold
+	held := 0
+	for resultsIterator.HasNext() {
+		responseRange, err := resultsIterator.Next()
+		if err != nil {
+			return 0, err
+		}
+		_, compositeKeyParts, err := stub.SplitCompositeKey(responseRange.Key)
+		if err != nil {
+			return 0, err
+		}
+		hold, err := getHold(stub, compositeKeyParts[1])
+		if err != nil {
+			return 0, err
+		}
+		if hold.active(now) {
+			held += hold.Amount
+		} else {
+			expired = append(expired, hold)
+		}
+	}
+	for _, hold := range expired {
+		if err := settleHold(stub, hold); err != nil {
+			return 0, err
+		}
+	}
+	return held, nil
+}
+
+// availableBalance returns an account's holding and the part of it not reserved by holds
+func availableBalance(stub shim.ChaincodeStubInterface, account string) (int, int, error) {
+	val, err := getBalance(stub, account)
+	if err != nil {
+		return 0, 0, err
+	}
+	now, err := txTime(stub)
+	if err != nil {
+		return 0, 0, err
+	}
+	held, err := heldAmount(stub, account, now)
+	if err != nil {
+		return 0, 0, err
+	}
+	return val, val - held, nil
+}
+
+// ==== authorize =========================================
+// authorize reserves funds on an account for a payee until the expiry time.
+// Only the account's owner may place a hold on it.
+//   0        1          2        3        4
+// "holdid"  "account"  "payee"  "amount" "2017-11-20T00:00:00Z"
+// ===========================================================================================
+func (t *SimpleChaincode) authorize(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 5 {
+		return shim.Error("Incorrect number of arguments. Expecting 5")
+	}
+
+	id, account, payee := args[0], args[1], args[2]
+	amount, err := strconv.Atoi(args[3])
+	if err != nil || amount <= 0 {
+		return shim.Error("Invalid hold amount, expecting a positive integer value")
+	}
+	expiry, err := time.Parse(time.RFC3339, args[4])
+	if err != nil {
+		return shim.Error("Invalid expiry, expecting an RFC3339 timestamp")
+	}
+	if account == payee {
+		return shim.Error("Account and payee must differ")
+	}
+	submitter, err := getParty(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if submitter.ID != account {
+		return shim.Error(fmt.Sprintf("Only %s can place holds on its account", account))
+	}
+	if strings.HasPrefix(id, reviewHoldPrefix) {
+		return shim.Error(fmt.Sprintf("Hold IDs starting with %s are reserved for velocity reviews", reviewHoldPrefix))
+	}
+
+	existing, err := stub.GetState(holdPrefix + id)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if existing != nil {
+		return shim.Error("Hold already exists: " + id)
+	}
+
+	now, err := txTime(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if !expiry.After(now) {
+		return shim.Error("Expiry must be after the transaction time")
+	}
+	if _, err := getBalance(stub, payee); err != nil {
+		return shim.Error(err.Error())
+	}
+	_, available, err := availableBalance(stub, account)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if available < amount {
+		return shim.Error(fmt.Sprintf("Insufficient available funds in %s: available %d, requested %d", account, available, amount))
+	}
+
+	hold := Hold{ID: id, Account: account, Payee: payee, Amount: amount, Status: HoldAuthorized, ExpiresAt: expiry.UTC().Format(time.RFC3339), CreatedAt: now.Format(time.RFC3339), UpdatedAt: now.Format(time.RFC3339)}
+	if err := putHold(stub, hold); err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := indexHold(stub, hold); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	holdAsBytes, _ := json.Marshal(hold)
+	return shim.Success(holdAsBytes)
+}
+
+// ==== capture =========================================
+// capture moves all or part of a held amount to the payee and releases the rest.
+// Only the payee or the account's owner may capture a hold, and only
+// compliance a hold placed by a velocity rule.
+//   0        1
+// "holdid"  "amount"
+// ===========================================================================================
+func (t *SimpleChaincode) capture(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+
+	hold, err := getHold(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	submitter, err := getSubmitter(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if hold.isReview() {
+		if _, err := requireRole(stub, RoleCompliance); err != nil {
+			return shim.Error(err.Error())
+		}
+	} else if submitter.ID != hold.Payee && submitter.ID != hold.Account {
+		return shim.Error(fmt.Sprintf("Only %s or %s can capture hold %s", hold.Payee, hold.Account, hold.ID))
+	} else if err := checkParty(stub, submitter); err != nil {
+		return shim.Error(err.Error())
+	}
+	amount, err := strconv.Atoi(args[1])
+	if err != nil || amount <= 0 {
+		return shim.Error("Invalid capture amount, expecting a positive integer value")
+	}
+	if amount > hold.Amount {
+		return shim.Error(fmt.Sprintf("Capture amount %d exceeds held amount %d", amount, hold.Amount))
+	}
+
+	now, err := txTime(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if !hold.active(now) {
+		return shim.Error("Hold is not active: " + hold.ID)
+	}
+
+	Aval, err := getBalance(stub, hold.Account)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	Bval, err := getBalance(stub, hold.Payee)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	// The held funds were already counted against the account, so only the holding must cover them
+	if Aval < amount {
+		return shim.Error(fmt.Sprintf("Insufficient funds in %s: holding %d, capture %d", hold.Account, Aval, amount))
+	}
+	if err := screenParties(stub, "capture", hold.Account, hold.Payee); err != nil {
+		return shim.Error(err.Error())
+	}
+	// A hold placed by a velocity rule was counted when its transfer was
+	// submitted. Any other capture is the transfer itself, and a capture that
+	// would be held for review is refused, as the funds are already held.
+	if !hold.isReview() {
+		if err := enforceLimits(stub, hold.Account, amount, now); err != nil {
+			return shim.Error(err.Error())
+		}
+		if err := enforceVelocity(stub, "capture", hold.Account, hold.Payee, amount, now); err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+	if err := putBalance(stub, hold.Account, Aval-amount); err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := putBalance(stub, hold.Payee, Bval+amount); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	hold.Captured = amount
+	hold.Status = HoldCaptured
+	hold.UpdatedAt = now.Format(time.RFC3339)
+	if err := putHold(stub, hold); err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := settleHold(stub, hold); err != nil {
+		return shim.Error(err.Error())
+	}
+	if _, err := writeJournal(stub, "capture", []TransferLeg{{From: hold.Account, To: hold.Payee, Amount: amount}}); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	holdAsBytes, _ := json.Marshal(hold)
+	return shim.Success(holdAsBytes)
+}
+
+// ==== voidHold =========================================
+// voidHold releases an authorized hold without moving any funds. Only the
+// account's owner may void a hold.
+//   0
+// "holdid"
+// ===========================================================================================
+func (t *SimpleChaincode) voidHold(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	hold, err := getHold(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if hold.Status != HoldAuthorized {
+		return shim.Error(fmt.Sprintf("Hold %s is %s and cannot be voided", hold.ID, hold.Status))
+	}
+	submitter, err := getSubmitter(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if hold.isReview() {
+		if _, err := requireRole(stub, RoleCompliance); err != nil {
+			return shim.Error(err.Error())
+		}
+	} else if submitter.ID != hold.Account {
+		return shim.Error(fmt.Sprintf("Only %s can void hold %s", hold.Account, hold.ID))
+	} else if err := checkParty(stub, submitter); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	now, err := txTime(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	hold.Status = HoldVoided
+	hold.UpdatedAt = now.Format(time.RFC3339)
+	if err := putHold(stub, hold); err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := settleHold(stub, hold); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	holdAsBytes, _ := json.Marshal(hold)
+	return shim.Success(holdAsBytes)
+}
+
+// ==== listHolds =========================================
+// listHolds returns every hold on an account. Authorized holds past their
+// expiry are reported as EXPIRED.
+//   0
+// "account"
+// ===========================================================================================
+func (t *SimpleChaincode) listHolds(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	holds, err := holdsForAccount(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	now, err := txTime(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	for i := range holds {
+		if holds[i].Status == HoldAuthorized && !holds[i].active(now) {
+			holds[i].Status = HoldExpired
+		}
+	}
+	if holds == nil {
+		holds = []Hold{}
+	}
+
+	holdsAsBytes, _ := json.Marshal(holds)
+	return shim.Success(holdsAsBytes)
+}
+
+// ==== queryBalance =========================================
+// queryBalance returns an account's holding, the amount reserved by holds and what remains available.
+//   0
+// "account"
+// ===========================================================================================
+func (t *SimpleChaincode) queryBalance(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting name of the account to query")
+	}
+
+	val, available, err := availableBalance(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	jsonResp, _ := json.Marshal(struct {
+		Name      string
+		Balance   int
+		Held      int
+		Available int
+	}{args[0], val, val - available, available})
+	return shim.Success(jsonResp)
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestHoldsRequireOwner(t *testing.T) {
+	s := newTestStub(t)
+	s.register("mallory", "Mal", "Lory", "")
+	expiry := "2017-11-20T00:00:00Z"
+
+	s.mustFail("Only a can place holds", "mallory", "authorize", "h1", "a", "mallory", "100", expiry)
+	s.mustInvoke("a", "authorize", "h1", "a", "b", "100", expiry)
+	s.mustInvoke("a", "authorize", "h2", "a", "b", "50", expiry)
+
+	s.mustFail("Only b or a can capture", "mallory", "capture", "h1", "100")
+	s.mustFail("Only a can void", "mallory", "voidHold", "h1")
+	s.mustFail("Only a can void", "b", "voidHold", "h1")
+
+	s.mustInvoke("b", "capture", "h1", "80")
+	s.expectBalance("a", 920)
+	s.expectBalance("b", 1080)
+
+	s.mustInvoke("a", "voidHold", "h2")
+	var hold Hold
+	s.get(holdPrefix+"h2", &hold)
+	if hold.Status != HoldVoided {
+		t.Fatalf("hold h2 is %s, expected %s", hold.Status, HoldVoided)
+	}
+}
+
+func TestHoldReservesFunds(t *testing.T) {
+	s := newTestStub(t)
+	s.mustInvoke("a", "authorize", "h1", "a", "b", "950", "2017-11-20T00:00:00Z")
+
+	s.mustFail("Insufficient available funds", "a", "move", "a", "b", "60")
+	s.mustFail("Insufficient available funds", "a", "authorize", "h2", "a", "b", "60", "2017-11-20T00:00:00Z")
+	s.mustInvoke("a", "move", "a", "b", "50")
+	s.expectBalance("a", 950)
+}
+
+func TestSettledHoldsLeaveActiveIndex(t *testing.T) {
+	s := newTestStub(t)
+	s.mustInvoke("a", "authorize", "h1", "a", "b", "100", "2017-11-20T00:00:00Z")
+	s.mustInvoke("a", "authorize", "h2", "a", "b", "100", "2017-11-20T00:00:00Z")
+	s.mustInvoke("a", "authorize", "h3", "a", "b", "100", "2017-11-02T00:00:00Z")
+	s.mustInvoke("b", "capture", "h1", "100")
+	s.mustInvoke("a", "voidHold", "h2")
+
+	// h3 has expired by the next day and is dropped by the next move
+	s.now = s.now.AddDate(0, 0, 1)
+	s.mustInvoke("a", "move", "a", "b", "10")
+	for _, id := range []string{"h1", "h2", "h3"} {
+		if _, ok := s.State[s.compositeKey(activeHoldIndexName, "a", id)]; ok {
+			t.Fatalf("hold %s is still in the active index", id)
+		}
+		if _, ok := s.State[s.compositeKey(holdIndexName, "a", id)]; !ok {
+			t.Fatalf("hold %s is missing from the account index", id)
+		}
+	}
+	s.expectBalance("a", 890)
+}
+
+func TestCaptureReleasesTheRest(t *testing.T) {
+	s := newTestStub(t)
+	expiry := "2017-11-20T00:00:00Z"
+	s.mustInvoke("a", "authorize", "h1", "a", "b", "600", expiry)
+	s.mustInvoke("a", "authorize", "h2", "a", "b", "300", expiry)
+
+	s.mustFail("Capture amount 700 exceeds held amount 600", "b", "capture", "h1", "700")
+	s.mustInvoke("b", "capture", "h1", "450")
+	s.expectBalance("a", 550)
+	s.expectBalance("b", 1450)
+	s.mustFail("Hold is not active: h1", "b", "capture", "h1", "150")
+
+	s.mustInvoke("a", "voidHold", "h2")
+	s.mustFail("Hold h2 is VOIDED and cannot be voided", "a", "voidHold", "h2")
+	s.mustInvoke("a", "move", "a", "b", "550")
+	s.expectBalance("a", 0)
+}
+
+func TestExpiredHoldsReleaseFunds(t *testing.T) {
+	s := newTestStub(t)
+	s.mustFail("Expiry must be after the transaction time", "a", "authorize", "h1", "a", "b", "100", "2017-11-01T00:00:00Z")
+	s.mustInvoke("a", "authorize", "h1", "a", "b", "1000", "2017-11-02T00:00:00Z")
+	s.mustFail("Insufficient available funds", "a", "move", "a", "b", "1")
+
+	s.now = s.now.AddDate(0, 0, 1)
+	s.mustFail("Hold is not active: h1", "b", "capture", "h1", "100")
+	var holds []Hold
+	if err := json.Unmarshal(s.mustInvoke("a", "listHolds", "a"), &holds); err != nil {
+		t.Fatal(err)
+	}
+	if len(holds) != 1 || holds[0].Status != HoldExpired {
+		t.Fatalf("holds on a are %+v, expected h1 reported as %s", holds, HoldExpired)
+	}
+	s.mustInvoke("a", "move", "a", "b", "1000")
+	s.expectBalance("b", 2000)
+}
+
+func TestCaptureAppliesVelocityRules(t *testing.T) {
+	s := newTestStub(t)
+	s.grant(RoleCompliance, "officer")
+	s.mustInvoke("a", "authorize", "h1", "a", "b", "60", "2017-11-20T00:00:00Z")
+
+	// The funds are already held, so a capture that would be held for review is refused
+	s.mustInvoke("officer", "setVelocityRule", `{"id":"newpayee","type":"NEW_COUNTERPARTY","minamount":50,"action":"HOLD"}`)
+	s.mustFail(VelocityHeld, "b", "capture", "h1", "60")
+	s.mustInvoke("officer", "setVelocityRule", `{"id":"newpayee","type":"NEW_COUNTERPARTY","minamount":50,"action":"FLAG"}`)
+	s.mustInvoke("b", "capture", "h1", "60")
+	if s.events[velocityAlertEvent] == nil {
+		t.Fatal("capture raised no alert")
+	}
+	s.expectBalance("b", 1060)
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+var htlcPrefix = "HTLC"              //prefix for the key/value that stores a hash-time-locked transfer
+var htlcClaimedEvent = "HTLCClaimed" //chaincode event carrying the revealed preimage
+
+// HTLC statuses
+const (
+	HTLCLocked   = "LOCKED"
+	HTLCClaimed  = "CLAIMED"
+	HTLCRefunded = "REFUNDED"
+)
+
+// HTLC locks a sender's funds for a receiver until the preimage of HashLock is revealed or the timeout passes
+type HTLC struct {
+	ID        string `json:"id"`
+	Sender    string `json:"sender"`
+	Receiver  string `json:"receiver"`
+	Amount    int    `json:"amount"`
+	HashLock  string `json:"hashlock"` //hex encoded SHA-256 of the secret
+	Timeout   string `json:"timeout"`
+	Preimage  string `json:"preimage"` //hex encoded secret, set once claimed
+	Status    string `json:"status"`
+	CreatedAt string `json:"created_at"`
+	SettledAt string `json:"settled_at"`
+}
+
+// HTLCClaim is the payload of the HTLCClaimed event
+type HTLCClaim struct {
+	ID       string `json:"id"`
+	HashLock string `json:"hashlock"`
+	Preimage string `json:"preimage"`
+	Receiver string `json:"receiver"`
+	Amount   int    `json:"amount"`
+}
+
+func getHTLC(stub shim.ChaincodeStubInterface, id string) (HTLC, error) {
+	var htlc HTLC
+	htlcAsBytes, err := stub.GetState(htlcPrefix + id)
+	if err != nil {
+		return htlc, fmt.Errorf("Failed to get HTLC %s", id)
+	}
+	if htlcAsBytes == nil {
+		return htlc, fmt.Errorf("HTLC not found: %s", id)
+	}
+	err = json.Unmarshal(htlcAsBytes, &htlc)
+	return htlc, err
+}
+
+func putHTLC(stub shim.ChaincodeStubInterface, htlc HTLC) error {
+	htlcAsBytes, _ := json.Marshal(htlc)
+	return stub.PutState(htlcPrefix+htlc.ID, htlcAsBytes)
+}
+
+// ==== lockHTLC =========================================
+// lockHTLC takes the amount out of the sender's account and locks it under
+// the hash of a secret. Only the sender may lock its own funds.
+//   0        1         2           3         4                          5
+// "htlcid"  "sender"  "receiver"  "amount"  "<hex sha256 of secret>"  "2017-11-20T00:00:00Z"
+// ===========================================================================================
+func (t *SimpleChaincode) lockHTLC(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 6 {
+		return shim.Error("Incorrect number of arguments. Expecting 6")
+	}
+
+	id, sender, receiver := args[0], args[1], args[2]
+	amount, err := strconv.Atoi(args[3])
+	if err != nil || amount <= 0 {
+		return shim.Error("Invalid HTLC amount, expecting a positive integer value")
+	}
+	hashLock := strings.ToLower(args[4])
+	if decoded, err := hex.DecodeString(hashLock); err != nil || len(decoded) != sha256.Size {
+		return shim.Error("Invalid hash lock, expecting a hex encoded SHA-256 digest")
+	}
+	timeout, err := time.Parse(time.RFC3339, args[5])
+	if err != nil {
+		return shim.Error("Invalid timeout, expecting an RFC3339 timestamp")
+	}
+	if sender == receiver {
+		return shim.Error("Sender and receiver must differ")
+	}
+	submitter, err := getParty(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if submitter.ID != sender {
+		return shim.Error(fmt.Sprintf("Only %s can lock its funds in an HTLC", sender))
+	}
+
+	existing, err := stub.GetState(htlcPrefix + id)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if existing != nil {
+		return shim.Error("HTLC already exists: " + id)
+	}
+
+	now, err := txTime(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if !timeout.After(now) {
+		return shim.Error("Timeout must be after the transaction time")
+	}
+	if _, err := getBalance(stub, receiver); err != nil {
+		return shim.Error(err.Error())
+	}
+	// Sanctions screening, KYC tier limits and velocity rules apply as to any
+	// transfer, and count the payment once, when it is locked. Locked funds
+	// cannot be held for review, so a lock that would be is refused instead.
+	if err := screenParties(stub, "lockHTLC", sender, receiver); err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := enforceLimits(stub, sender, amount, now); err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := enforceVelocity(stub, "lockHTLC", sender, receiver, amount, now); err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := debit(stub, sender, amount); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	htlc := HTLC{ID: id, Sender: sender, Receiver: receiver, Amount: amount, HashLock: hashLock, Timeout: timeout.UTC().Format(time.RFC3339), Status: HTLCLocked, CreatedAt: now.Format(time.RFC3339)}
+	if err := putHTLC(stub, htlc); err != nil {
+		return shim.Error(err.Error())
+	}
+	if _, err := writeJournal(stub, "lockHTLC", []TransferLeg{{From: sender, To: htlcPrefix + id, Amount: amount}}); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	htlcAsBytes, _ := json.Marshal(htlc)
+	return shim.Success(htlcAsBytes)
+}
+
+// ==== claimHTLC =========================================
+// claimHTLC pays the receiver when the revealed secret hashes to the lock,
+// and publishes the secret in an HTLCClaimed event so the counterparty
+// ledger can complete its side of the swap.
+//   0        1
+// "htlcid"  "<hex secret>"
+// ===========================================================================================
+func (t *SimpleChaincode) claimHTLC(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+
+	htlc, err := getHTLC(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if htlc.Status != HTLCLocked {
+		return shim.Error(fmt.Sprintf("HTLC %s is already %s", htlc.ID, htlc.Status))
+	}
+
+	preimage, err := hex.DecodeString(args[1])
+	if err != nil {
+		return shim.Error("Invalid preimage, expecting a hex encoded secret")
+	}
+	digest := sha256.Sum256(preimage)
+	if hex.EncodeToString(digest[:]) != htlc.HashLock {
+		return shim.Error("Preimage does not match the hash lock")
+	}
+
+	now, err := txTime(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	timeout, _ := time.Parse(time.RFC3339, htlc.Timeout)
+	if !now.Before(timeout) {
+		return shim.Error(fmt.Sprintf("HTLC %s timed out at %s and can only be refunded", htlc.ID, htlc.Timeout))
+	}
+	// The parties are screened again in case either was listed while the funds were locked
+	if err := screenParties(stub, "claimHTLC", htlc.Sender, htlc.Receiver); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	if err := credit(stub, htlc.Receiver, htlc.Amount); err != nil {
+		return shim.Error(err.Error())
+	}
+	htlc.Preimage = hex.EncodeToString(preimage)
+	htlc.Status = HTLCClaimed
+	htlc.SettledAt = now.Format(time.RFC3339)
+	if err := putHTLC(stub, htlc); err != nil {
+		return shim.Error(err.Error())
+	}
+	if _, err := writeJournal(stub, "claimHTLC", []TransferLeg{{From: htlcPrefix + htlc.ID, To: htlc.Receiver, Amount: htlc.Amount}}); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	claimAsBytes, _ := json.Marshal(HTLCClaim{ID: htlc.ID, HashLock: htlc.HashLock, Preimage: htlc.Preimage, Receiver: htlc.Receiver, Amount: htlc.Amount})
+	if err := stub.SetEvent(htlcClaimedEvent, claimAsBytes); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	htlcAsBytes, _ := json.Marshal(htlc)
+	return shim.Success(htlcAsBytes)
+}
+
+// ==== refundHTLC =========================================
+// refundHTLC returns locked funds to the sender once the timeout has passed.
+//   0
+// "htlcid"
+// ===========================================================================================
+func (t *SimpleChaincode) refundHTLC(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	htlc, err := getHTLC(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if htlc.Status != HTLCLocked {
+		return shim.Error(fmt.Sprintf("HTLC %s is already %s", htlc.ID, htlc.Status))
+	}
+
+	now, err := txTime(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	timeout, _ := time.Parse(time.RFC3339, htlc.Timeout)
+	if now.Before(timeout) {
+		return shim.Error(fmt.Sprintf("HTLC %s cannot be refunded before %s", htlc.ID, htlc.Timeout))
+	}
+
+	if err := credit(stub, htlc.Sender, htlc.Amount); err != nil {
+		return shim.Error(err.Error())
+	}
+	htlc.Status = HTLCRefunded
+	htlc.SettledAt = now.Format(time.RFC3339)
+	if err := putHTLC(stub, htlc); err != nil {
+		return shim.Error(err.Error())
+	}
+	if _, err := writeJournal(stub, "refundHTLC", []TransferLeg{{From: htlcPrefix + htlc.ID, To: htlc.Sender, Amount: htlc.Amount}}); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	htlcAsBytes, _ := json.Marshal(htlc)
+	return shim.Success(htlcAsBytes)
+}
+
+// ==== queryHTLC =========================================
+//   0
+// "htlcid"
+// ===========================================================================================
+func (t *SimpleChaincode) queryHTLC(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	htlcAsBytes, err := stub.GetState(htlcPrefix + args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if htlcAsBytes == nil {
+		return shim.Error("HTLC not found: " + args[0])
+	}
+	return shim.Success(htlcAsBytes)
+}
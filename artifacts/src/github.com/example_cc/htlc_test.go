@@ -0,0 +1,82 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestLockHTLCRequiresSender(t *testing.T) {
+	s := newTestStub(t)
+	s.register("mallory", "Mal", "Lory", "")
+	s.fund("mallory", 0)
+	secret := []byte("open sesame")
+	digest := sha256.Sum256(secret)
+	hashLock := hex.EncodeToString(digest[:])
+
+	// Knowing the preimage must not let anyone lock, and claim, another account's funds
+	s.mustFail("Only a can lock its funds", "mallory", "lockHTLC", "x1", "a", "mallory", "100", hashLock, "2017-11-20T00:00:00Z")
+	s.expectBalance("a", 1000)
+
+	s.mustInvoke("a", "lockHTLC", "x1", "a", "b", "100", hashLock, "2017-11-20T00:00:00Z")
+	s.expectBalance("a", 900)
+	s.mustInvoke("mallory", "claimHTLC", "x1", hex.EncodeToString(secret))
+	s.expectBalance("b", 1100)
+	s.expectBalance("mallory", 0)
+	if s.events[htlcClaimedEvent] == nil {
+		t.Fatal("claimHTLC emitted no event")
+	}
+}
+
+func TestClaimHTLCChecksPreimage(t *testing.T) {
+	s := newTestStub(t)
+	secret := []byte("open sesame")
+	digest := sha256.Sum256(secret)
+	s.mustInvoke("a", "lockHTLC", "x1", "a", "b", "100", hex.EncodeToString(digest[:]), "2017-11-20T00:00:00Z")
+
+	s.mustFail("Preimage does not match", "b", "claimHTLC", "x1", hex.EncodeToString([]byte("open sesame!")))
+	s.mustFail("cannot be refunded before", "a", "refundHTLC", "x1")
+	s.expectBalance("b", 1000)
+
+	s.mustInvoke("b", "claimHTLC", "x1", hex.EncodeToString(secret))
+	s.expectBalance("a", 900)
+	s.expectBalance("b", 1100)
+	var claim HTLCClaim
+	if err := json.Unmarshal(s.events[htlcClaimedEvent], &claim); err != nil {
+		t.Fatal(err)
+	}
+	if claim.Preimage != hex.EncodeToString(secret) {
+		t.Fatalf("HTLCClaimed event published %q, expected the secret", claim.Preimage)
+	}
+	s.mustFail("already "+HTLCClaimed, "b", "claimHTLC", "x1", hex.EncodeToString(secret))
+}
+
+func TestRefundHTLCAfterTimeout(t *testing.T) {
+	s := newTestStub(t)
+	secret := []byte("open sesame")
+	digest := sha256.Sum256(secret)
+	s.mustInvoke("a", "lockHTLC", "x1", "a", "b", "100", hex.EncodeToString(digest[:]), "2017-11-20T00:00:00Z")
+
+	s.now = time.Date(2017, 11, 20, 0, 0, 0, 0, time.UTC)
+	s.mustFail("timed out", "b", "claimHTLC", "x1", hex.EncodeToString(secret))
+	s.mustInvoke("a", "refundHTLC", "x1")
+	s.expectBalance("a", 1000)
+	s.expectBalance("b", 1000)
+	s.mustFail("already "+HTLCRefunded, "a", "refundHTLC", "x1")
+}
+
+func TestLockHTLCEnforcesLimitsAndVelocity(t *testing.T) {
+	s := newTestStub(t)
+	s.grant(RoleCompliance, "officer")
+	s.register("alice", "Alice", "Able", KYCTier0)
+	s.fund("alice", 1000)
+	digest := sha256.Sum256([]byte("open sesame"))
+	hashLock := hex.EncodeToString(digest[:])
+
+	s.mustFail(LimitExceeded, "alice", "lockHTLC", "x1", "alice", "b", "150", hashLock, "2017-11-20T00:00:00Z")
+	s.mustInvoke("officer", "setVelocityRule", `{"id":"newpayee","type":"NEW_COUNTERPARTY","minamount":50,"action":"HOLD"}`)
+	s.mustFail(VelocityHeld, "alice", "lockHTLC", "x1", "alice", "b", "60", hashLock, "2017-11-20T00:00:00Z")
+	s.expectBalance("alice", 1000)
+}
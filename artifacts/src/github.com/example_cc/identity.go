@@ -0,0 +1,74 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	mspprotos "github.com/hyperledger/fabric/protos/msp"
+)
+
+// Submitter identifies the client that signed the proposal. ID is the
+// enrollment ID (certificate common name), which is also the name of the
+// account the client owns.
+type Submitter struct {
+	MSPID string `json:"mspid"`
+	ID    string `json:"id"`
+}
+
+// getSubmitter decodes the creator of the current transaction
+func getSubmitter(stub shim.ChaincodeStubInterface) (Submitter, error) {
+	creator, err := stub.GetCreator()
+	if err != nil {
+		return Submitter{}, err
+	}
+	sid := &mspprotos.SerializedIdentity{}
+	if err := proto.Unmarshal(creator, sid); err != nil {
+		return Submitter{}, fmt.Errorf("Failed to decode submitter identity: %s", err)
+	}
+	block, _ := pem.Decode(sid.IdBytes)
+	if block == nil {
+		return Submitter{}, fmt.Errorf("Submitter identity does not hold a PEM certificate")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return Submitter{}, fmt.Errorf("Failed to parse submitter certificate: %s", err)
+	}
+	return Submitter{MSPID: sid.Mspid, ID: cert.Subject.CommonName}, nil
+}
+
+// checkParty makes sure the submitter is the party registered under its
+// enrollment ID: a user profile or biller record must exist for the ID, and
+// every such record must name the submitter's MSP. Accounts, bills and other
+// records name their parties by enrollment ID only, so without this check an
+// identity with the same common name in another organization would pass as
+// the party.
+func checkParty(stub shim.ChaincodeStubInterface, submitter Submitter) error {
+	profile, isUser, err := findUserProfile(stub, submitter.ID)
+	if err != nil {
+		return err
+	}
+	biller, isBiller, err := getBiller(stub, submitter.ID)
+	if err != nil {
+		return err
+	}
+	if !isUser && !isBiller {
+		return fmt.Errorf("%s of %s is not a registered user or biller", submitter.ID, submitter.MSPID)
+	}
+	if (isUser && profile.MSPID != submitter.MSPID) || (isBiller && biller.MSPID != submitter.MSPID) {
+		return fmt.Errorf("%s of %s is not the registered %s", submitter.ID, submitter.MSPID, submitter.ID)
+	}
+	return nil
+}
+
+// getParty decodes the submitter of a transaction that it makes as a
+// registered party, and checks it with checkParty
+func getParty(stub shim.ChaincodeStubInterface) (Submitter, error) {
+	submitter, err := getSubmitter(stub)
+	if err != nil {
+		return submitter, err
+	}
+	return submitter, checkParty(stub, submitter)
+}
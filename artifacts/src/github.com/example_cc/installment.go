@@ -0,0 +1,329 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+var installmentPlanIndexName = "installmentplan~billid" //composite key holding the installment plan of a bill
+
+// Installment plan statuses
+const (
+	PlanActive    = "ACTIVE"
+	PlanCompleted = "COMPLETED"
+	PlanCancelled = "CANCELLED" //the bill was cancelled or split before the plan was paid
+)
+
+// Installment is one dated part of an installment plan
+type Installment struct {
+	Number  int    `json:"number"`
+	DueDate string `json:"duedate"`
+	Amount  int    `json:"amount"`
+	Paid    int    `json:"paid"`
+	Overdue bool   `json:"overdue"`
+	PaidAt  string `json:"paidat"`
+}
+
+// InstallmentPlan splits what is outstanding on a bill, plus any interest,
+// into dated installments. Interest is raised against the bill as a debit
+// note, and every later change to the bill is carried over to the plan, so
+// what remains unpaid on the plan matches what is outstanding on the bill.
+type InstallmentPlan struct {
+	BillID       string        `json:"billid"`
+	Frequency    string        `json:"frequency"`
+	InterestRate int           `json:"interestrate"` //basis points of the outstanding amount
+	Interest     int           `json:"interest"`
+	Total        int           `json:"total"`
+	Installments []Installment `json:"installments"`
+	Status       string        `json:"status"`
+	CreatedBy    string        `json:"createdby"`
+	CreatedAt    string        `json:"created_at"`
+}
+
+// InstallmentProgress summarizes how far an installment plan has been paid
+type InstallmentProgress struct {
+	Paid             int    `json:"paid"`
+	Remaining        int    `json:"remaining"`
+	InstallmentsPaid int    `json:"installmentspaid"`
+	OverdueCount     int    `json:"overduecount"`
+	OverdueAmount    int    `json:"overdueamount"`
+	NextDueDate      string `json:"nextduedate"`
+	NextDueAmount    int    `json:"nextdueamount"`
+}
+
+func getInstallmentPlan(stub shim.ChaincodeStubInterface, billID string) (InstallmentPlan, bool, error) {
+	var plan InstallmentPlan
+	key, err := stub.CreateCompositeKey(installmentPlanIndexName, []string{billID})
+	if err != nil {
+		return plan, false, err
+	}
+	planAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return plan, false, fmt.Errorf("Failed to get installment plan of bill %s", billID)
+	}
+	if planAsBytes == nil {
+		return plan, false, nil
+	}
+	err = json.Unmarshal(planAsBytes, &plan)
+	return plan, true, err
+}
+
+func putInstallmentPlan(stub shim.ChaincodeStubInterface, plan InstallmentPlan) error {
+	key, err := stub.CreateCompositeKey(installmentPlanIndexName, []string{plan.BillID})
+	if err != nil {
+		return err
+	}
+	planAsBytes, _ := json.Marshal(plan)
+	return stub.PutState(key, planAsBytes)
+}
+
+// refresh flags the installments past their due date and not fully paid as overdue
+func (p *InstallmentPlan) refresh(now time.Time) {
+	today := now.Format(dateLayout)
+	done := true
+	for i := range p.Installments {
+		item := &p.Installments[i]
+		item.Overdue = item.Paid < item.Amount && item.DueDate < today
+		if item.Paid < item.Amount {
+			done = false
+		}
+	}
+	if done {
+		p.Status = PlanCompleted
+	}
+}
+
+// reconcile re-derives the unpaid part of the plan from what is outstanding
+// on its bill. A debit raised after the plan is added to the last
+// installment; a credit comes off the unpaid installments from the last one
+// back. The plan closes with its bill.
+func (p *InstallmentPlan) reconcile(bill Bill, now time.Time) error {
+	target, err := bill.outstanding()
+	if err != nil {
+		return err
+	}
+	if !bill.isOpen() {
+		target = 0
+	}
+	remaining := 0
+	for _, item := range p.Installments {
+		remaining += item.Amount - item.Paid
+	}
+	diff := target - remaining
+	p.Total += diff
+	if diff > 0 {
+		p.Installments[len(p.Installments)-1].Amount += diff
+	}
+	for i := len(p.Installments) - 1; diff < 0 && i >= 0; i-- {
+		item := &p.Installments[i]
+		share := item.Amount - item.Paid
+		if share > -diff {
+			share = -diff
+		}
+		item.Amount -= share
+		diff += share
+	}
+	p.refresh(now)
+	if !bill.isOpen() && bill.Status != BillPaid && bill.Status != BillCredited {
+		p.Status = PlanCancelled
+	}
+	return nil
+}
+
+// reconcileInstallmentPlan brings the active plan of a bill, if any, in line with the bill
+func reconcileInstallmentPlan(stub shim.ChaincodeStubInterface, bill Bill) error {
+	plan, found, err := getInstallmentPlan(stub, bill.ID)
+	if err != nil || !found || plan.Status != PlanActive {
+		return err
+	}
+	now, err := txTime(stub)
+	if err != nil {
+		return err
+	}
+	if err := plan.reconcile(bill, now); err != nil {
+		return err
+	}
+	return putInstallmentPlan(stub, plan)
+}
+
+func (p InstallmentPlan) progress() InstallmentProgress {
+	var progress InstallmentProgress
+	for _, item := range p.Installments {
+		progress.Paid += item.Paid
+		progress.Remaining += item.Amount - item.Paid
+		if item.Paid == item.Amount {
+			progress.InstallmentsPaid++
+			continue
+		}
+		if item.Overdue {
+			progress.OverdueCount++
+			progress.OverdueAmount += item.Amount - item.Paid
+		}
+		if progress.NextDueDate == "" {
+			progress.NextDueDate = item.DueDate
+			progress.NextDueAmount = item.Amount - item.Paid
+		}
+	}
+	return progress
+}
+
+// applyToInstallments spreads an amount paid on a bill over its plan's
+// installments in order. Bills without a plan are left alone.
+func applyToInstallments(stub shim.ChaincodeStubInterface, billID string, amount int, now time.Time) error {
+	plan, found, err := getInstallmentPlan(stub, billID)
+	if err != nil || !found {
+		return err
+	}
+	for i := range plan.Installments {
+		if amount == 0 {
+			break
+		}
+		item := &plan.Installments[i]
+		share := item.Amount - item.Paid
+		if share > amount {
+			share = amount
+		}
+		item.Paid += share
+		amount -= share
+		if share > 0 && item.Paid == item.Amount {
+			item.PaidAt = now.Format(time.RFC3339)
+		}
+	}
+	plan.refresh(now)
+	return putInstallmentPlan(stub, plan)
+}
+
+// refreshInstallmentPlan stores the overdue state of a bill's installments as of the given time
+func refreshInstallmentPlan(stub shim.ChaincodeStubInterface, billID string, now time.Time) error {
+	plan, found, err := getInstallmentPlan(stub, billID)
+	if err != nil || !found || plan.Status != PlanActive {
+		return err
+	}
+	plan.refresh(now)
+	return putInstallmentPlan(stub, plan)
+}
+
+// ==== createInstallmentPlan =========================================
+// createInstallmentPlan lets a bill's recipient split what is outstanding on
+// it into count installments, the first due on firstduedate and the rest
+// following at the given frequency. interestrate is in basis points of the
+// outstanding amount and may be 0. The bill becomes due with the last installment.
+// 0       1        2                             3               4
+// "id"    "count"  "DAILY"|"WEEKLY"|"MONTHLY"    "2017-12-01"    "interestrate"
+// ===========================================================================================
+func (t *SimpleChaincode) createInstallmentPlan(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 5 {
+		return shim.Error("Incorrect number of arguments. Expecting 5")
+	}
+
+	submitter, err := getParty(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	bill, err := getBill(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if bill.RecipientID != submitter.ID {
+		return shim.Error(fmt.Sprintf("Only recipient %s can create an installment plan for bill %s", bill.RecipientID, bill.ID))
+	}
+	if !bill.isOpen() {
+		return shim.Error(fmt.Sprintf("Bill %s is already %s", bill.ID, bill.Status))
+	}
+	if bill.DisputeID != "" {
+		return shim.Error(fmt.Sprintf("Bill %s is under dispute %s", bill.ID, bill.DisputeID))
+	}
+	if _, found, err := getInstallmentPlan(stub, bill.ID); err != nil || found {
+		return shim.Error("Bill already has an installment plan: " + bill.ID)
+	}
+	count, err := strconv.Atoi(args[1])
+	if err != nil || count < 2 {
+		return shim.Error("Invalid count, expecting an integer value of at least 2")
+	}
+	frequency := args[2]
+	if frequency != FrequencyDaily && frequency != FrequencyWeekly && frequency != FrequencyMonthly {
+		return shim.Error(fmt.Sprintf("Frequency must be one of '%s', '%s' or '%s'. But got: %v", FrequencyDaily, FrequencyWeekly, FrequencyMonthly, frequency))
+	}
+	firstDue, err := time.Parse(dateLayout, args[3])
+	if err != nil {
+		return shim.Error("Invalid first due date, expecting YYYY-MM-DD")
+	}
+	interestRate, err := strconv.Atoi(args[4])
+	if err != nil || interestRate < 0 {
+		return shim.Error("Invalid interest rate, expecting a non-negative integer value in basis points")
+	}
+	outstanding, err := bill.outstanding()
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if outstanding < count {
+		return shim.Error(fmt.Sprintf("Cannot split %d outstanding into %d installments", outstanding, count))
+	}
+
+	now, err := txTime(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	plan := InstallmentPlan{BillID: bill.ID, Frequency: frequency, InterestRate: interestRate, Status: PlanActive, CreatedBy: submitter.ID, CreatedAt: now.Format(time.RFC3339)}
+	plan.Interest = (outstanding*interestRate + 5000) / 10000
+	plan.Total = outstanding + plan.Interest
+	for i := 0; i < count; i++ {
+		item := Installment{Number: i + 1, DueDate: occurrence(firstDue, frequency, i).Format(dateLayout), Amount: plan.Total / count}
+		if i == count-1 {
+			item.Amount += plan.Total % count
+		}
+		plan.Installments = append(plan.Installments, item)
+	}
+
+	bill.BillDueDate = plan.Installments[count-1].DueDate
+	if plan.Interest > 0 {
+		note := Adjustment{ID: bill.ID + "-INTEREST", Type: DebitNote, Amount: plan.Interest, ReasonCode: "INSTALLMENT_INTEREST", Memo: fmt.Sprintf("%d installments at %d basis points", count, interestRate), CreatedBy: submitter.ID}
+		if _, err := adjustBill(stub, bill, note, now); err != nil {
+			return shim.Error(err.Error())
+		}
+	} else if err := putBill(stub, bill); err != nil {
+		return shim.Error(err.Error())
+	}
+	plan.refresh(now)
+	if err := putInstallmentPlan(stub, plan); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	planAsBytes, _ := json.Marshal(plan)
+	return shim.Success(planAsBytes)
+}
+
+// ==== queryInstallmentPlan =========================================
+// queryInstallmentPlan returns a bill's installment schedule and how far it has been paid.
+// 0
+// "id"
+// ===========================================================================================
+func (t *SimpleChaincode) queryInstallmentPlan(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	plan, found, err := getInstallmentPlan(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if !found {
+		return shim.Error("No installment plan for bill " + args[0])
+	}
+	now, err := txTime(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	plan.refresh(now)
+
+	respAsBytes, _ := json.Marshal(struct {
+		Plan     InstallmentPlan     `json:"plan"`
+		Progress InstallmentProgress `json:"progress"`
+	}{plan, plan.progress()})
+	return shim.Success(respAsBytes)
+}
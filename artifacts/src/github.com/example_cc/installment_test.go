@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestInstallmentPlanSpreadsPayments(t *testing.T) {
+	s := newTestStub(t)
+	s.biller("acme")
+	s.register("alice", "Alice", "Able", KYCTier1)
+	s.fund("alice", 1000)
+	s.issueBill("b1", "acme", "alice", 300, "2017-11-30")
+	s.mustFail("Only recipient acme can create", "alice", "createInstallmentPlan", "b1", "3", FrequencyMonthly, "2017-11-01", "100")
+	s.mustInvoke("acme", "createInstallmentPlan", "b1", "3", FrequencyMonthly, "2017-11-01", "100")
+	s.mustFail("Bill already has an installment plan", "acme", "createInstallmentPlan", "b1", "2", FrequencyMonthly, "2017-11-01", "0")
+
+	// 1% interest is raised on the bill, which becomes due with the last installment
+	if bill := s.bill("b1"); bill.Outstanding != 303 || bill.BillDueDate != "2018-01-01" {
+		t.Fatalf("b1 has %d outstanding due %s, expected 303 due 2018-01-01", bill.Outstanding, bill.BillDueDate)
+	}
+
+	s.mustPay("p1", "alice", 150, `[{"billid":"b1","amount":150}]`)
+	s.now = s.now.AddDate(0, 1, 4)
+	var resp struct {
+		Plan     InstallmentPlan     `json:"plan"`
+		Progress InstallmentProgress `json:"progress"`
+	}
+	if err := json.Unmarshal(s.mustInvoke("acme", "queryInstallmentPlan", "b1"), &resp); err != nil {
+		t.Fatal(err)
+	}
+	want := InstallmentProgress{Paid: 150, Remaining: 153, InstallmentsPaid: 1, OverdueCount: 1, OverdueAmount: 52, NextDueDate: "2017-12-01", NextDueAmount: 52}
+	if resp.Progress != want {
+		t.Fatalf("progress is %+v, expected %+v", resp.Progress, want)
+	}
+	if items := resp.Plan.Installments; items[0].Paid != 101 || items[1].Paid != 49 || !items[1].Overdue || items[2].Paid != 0 {
+		t.Fatalf("installments are %+v, expected the payment to cover the first and part of the second", items)
+	}
+}
+
+func TestInstallmentPlanFollowsNotes(t *testing.T) {
+	s := newTestStub(t)
+	s.biller("acme")
+	s.register("alice", "Alice", "Able", KYCTier1)
+	s.fund("alice", 1000)
+	s.issueBill("b1", "acme", "alice", 300, "2017-11-30")
+	s.mustInvoke("acme", "createInstallmentPlan", "b1", "3", FrequencyMonthly, "2017-12-01", "0")
+	planKey := s.compositeKey(installmentPlanIndexName, "b1")
+
+	// A debit raised after the plan falls due with the last installment
+	s.mustInvoke("acme", "createDebitNote", "n1", "b1", "30", "UNDERBILLED", "")
+	var plan InstallmentPlan
+	s.get(planKey, &plan)
+	if plan.Total != 330 || plan.Installments[2].Amount != 130 {
+		t.Fatalf("plan total %d with last installment %d, expected 330 and 130", plan.Total, plan.Installments[2].Amount)
+	}
+
+	// A credit comes off the unpaid installments from the last one back
+	s.mustPay("p1", "alice", 100, `[{"billid":"b1","amount":100}]`)
+	s.mustInvoke("acme", "createCreditNote", "n2", "b1", "150", "GOODWILL", "")
+	s.get(planKey, &plan)
+	if plan.Total != 180 || plan.Installments[1].Amount != 80 || plan.Installments[2].Amount != 0 {
+		t.Fatalf("plan total %d with installments %+v, expected 180 with 100, 80 and 0", plan.Total, plan.Installments)
+	}
+
+	// A credit that settles the bill completes the plan
+	s.mustInvoke("acme", "createCreditNote", "n3", "b1", "80", "GOODWILL", "")
+	s.get(planKey, &plan)
+	if bill := s.bill("b1"); bill.Status != BillPaid || plan.Status != PlanCompleted {
+		t.Fatalf("bill is %s and plan %s, expected %s and %s", bill.Status, plan.Status, BillPaid, PlanCompleted)
+	}
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+var tierLimitsIndexName = "kyctier~tier"  //composite key holding the limits of a KYC tier
+var kycUsageIndexName = "kycusage~userid" //composite key holding a user's running day and month totals
+
+// LimitExceeded starts the message of every error raised by a KYC tier limit
+const LimitExceeded = "LIMIT_EXCEEDED"
+
+// KYC tiers. Users without a tier, and accounts whose holder has no profile, are in TIER0.
+const (
+	KYCTier0 = "TIER0" //unverified
+	KYCTier1 = "TIER1"
+	KYCTier2 = "TIER2"
+	KYCTier3 = "TIER3"
+)
+
+var kycTiers = []string{KYCTier0, KYCTier1, KYCTier2, KYCTier3}
+
+// TierLimits caps what a user of a tier may pay out, per transaction, per
+// day and per calendar month. A limit of 0 means no limit.
+type TierLimits struct {
+	Tier           string `json:"tier"`
+	PerTransaction int    `json:"pertransaction"`
+	Daily          int    `json:"daily"`
+	Monthly        int    `json:"monthly"`
+	UpdatedBy      string `json:"updatedby"`
+	UpdatedAt      string `json:"updated_at"`
+}
+
+// Limits that apply until an admin sets a tier's limits with setTierLimits
+var defaultTierLimits = map[string]TierLimits{
+	KYCTier0: {Tier: KYCTier0, PerTransaction: 100, Daily: 500, Monthly: 2000},
+	KYCTier1: {Tier: KYCTier1, PerTransaction: 1000, Daily: 5000, Monthly: 20000},
+	KYCTier2: {Tier: KYCTier2, PerTransaction: 10000, Daily: 50000, Monthly: 200000},
+	KYCTier3: {Tier: KYCTier3},
+}
+
+// KYCUsage is what a user paid out on Day and in Month. Usage is kept per
+// enrollment ID: accounts are named after their holder's enrollment ID, which
+// is also the ID of the holder's profile, so a debit of an account and a
+// payment recorded for its holder count against the same totals.
+type KYCUsage struct {
+	Day        string `json:"day"`
+	DayTotal   int    `json:"daytotal"`
+	Month      string `json:"month"`
+	MonthTotal int    `json:"monthtotal"`
+}
+
+func getTierLimits(stub shim.ChaincodeStubInterface, tier string) (TierLimits, error) {
+	key, err := stub.CreateCompositeKey(tierLimitsIndexName, []string{tier})
+	if err != nil {
+		return TierLimits{}, err
+	}
+	limitsAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return TierLimits{}, fmt.Errorf("Failed to get limits of tier %s", tier)
+	}
+	if limitsAsBytes == nil {
+		return defaultTierLimits[tier], nil
+	}
+	var limits TierLimits
+	err = json.Unmarshal(limitsAsBytes, &limits)
+	return limits, err
+}
+
+// userTier is the KYC tier of a user, TIER0 when it has no profile or its profile has no tier
+func userTier(stub shim.ChaincodeStubInterface, userID string) string {
+	profile, err := getUserProfile(stub, userID)
+	if err != nil || profile.KYCTier == "" {
+		return KYCTier0
+	}
+	return profile.KYCTier
+}
+
+func getKYCUsage(stub shim.ChaincodeStubInterface, userID string, now time.Time) (KYCUsage, string, error) {
+	var usage KYCUsage
+	key, err := stub.CreateCompositeKey(kycUsageIndexName, []string{userID})
+	if err != nil {
+		return usage, key, err
+	}
+	usageAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return usage, key, fmt.Errorf("Failed to get usage of %s", userID)
+	}
+	if usageAsBytes != nil {
+		if err := json.Unmarshal(usageAsBytes, &usage); err != nil {
+			return usage, key, err
+		}
+	}
+	if day := now.Format(dateLayout); usage.Day != day {
+		usage.Day, usage.DayTotal = day, 0
+	}
+	if month := now.Format("2006-01"); usage.Month != month {
+		usage.Month, usage.MonthTotal = month, 0
+	}
+	return usage, key, nil
+}
+
+// checkLimits checks an amount paid out by a user against the limits of its
+// KYC tier without recording it, and returns the usage to add it to
+func checkLimits(stub shim.ChaincodeStubInterface, userID string, amount int, now time.Time) (KYCUsage, string, error) {
+	tier := userTier(stub, userID)
+	limits, err := getTierLimits(stub, tier)
+	if err != nil {
+		return KYCUsage{}, "", err
+	}
+	usage, key, err := getKYCUsage(stub, userID, now)
+	if err != nil {
+		return usage, key, err
+	}
+	if limits.PerTransaction > 0 && amount > limits.PerTransaction {
+		return usage, key, fmt.Errorf("%s: %d exceeds the %s per transaction limit %d of %s", LimitExceeded, amount, tier, limits.PerTransaction, userID)
+	}
+	if limits.Daily > 0 && usage.DayTotal+amount > limits.Daily {
+		return usage, key, fmt.Errorf("%s: %d would exceed the %s daily limit %d of %s, %d already used", LimitExceeded, amount, tier, limits.Daily, userID, usage.DayTotal)
+	}
+	if limits.Monthly > 0 && usage.MonthTotal+amount > limits.Monthly {
+		return usage, key, fmt.Errorf("%s: %d would exceed the %s monthly limit %d of %s, %d already used", LimitExceeded, amount, tier, limits.Monthly, userID, usage.MonthTotal)
+	}
+	return usage, key, nil
+}
+
+// enforceLimits checks an amount paid out by a user against the limits of
+// its KYC tier and, if it is allowed, adds it to the user's running totals
+func enforceLimits(stub shim.ChaincodeStubInterface, userID string, amount int, now time.Time) error {
+	usage, key, err := checkLimits(stub, userID, amount, now)
+	if err != nil {
+		return err
+	}
+	usage.DayTotal += amount
+	usage.MonthTotal += amount
+	usageAsBytes, _ := json.Marshal(usage)
+	return stub.PutState(key, usageAsBytes)
+}
+
+// ==== setKYCTier =========================================
+// setKYCTier moves a user to a KYC tier. Tiers above TIER0 need a verified
+// KYC status. Only a KYC provider may set tiers.
+// 0         1
+// "userid"  "TIER1"
+// ===========================================================================================
+func (t *SimpleChaincode) setKYCTier(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+	provider, err := requireRole(stub, RoleKYCProvider)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	tier := args[1]
+	if !containsString(kycTiers, tier) {
+		return shim.Error(fmt.Sprintf("Unknown tier %s, expecting one of %v", tier, kycTiers))
+	}
+	profile, err := getUserProfile(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if tier != KYCTier0 && profile.KYCStatus != KYCVerified {
+		return shim.Error(fmt.Sprintf("User %s is %s, only verified users can move above %s", profile.ID, profile.KYCStatus, KYCTier0))
+	}
+
+	now, err := txTime(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	profile.KYCTier = tier
+	profile.KYCUpdatedBy = provider.ID
+	profile.UpdatedAt = now.Format(time.RFC3339)
+	if err := putUserProfile(stub, profile); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	profileAsBytes, _ := json.Marshal(profile)
+	return shim.Success(profileAsBytes)
+}
+
+// ==== setTierLimits =========================================
+// setTierLimits sets the limits of a KYC tier; 0 means no limit. Admin only.
+// 0        1                 2        3
+// "TIER0"  "pertransaction"  "daily"  "monthly"
+// ===========================================================================================
+func (t *SimpleChaincode) setTierLimits(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 4 {
+		return shim.Error("Incorrect number of arguments. Expecting 4")
+	}
+	admin, err := requireRole(stub, RoleAdmin)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	tier := args[0]
+	if !containsString(kycTiers, tier) {
+		return shim.Error(fmt.Sprintf("Unknown tier %s, expecting one of %v", tier, kycTiers))
+	}
+	var values [3]int
+	for i, arg := range args[1:] {
+		value, err := strconv.Atoi(arg)
+		if err != nil || value < 0 {
+			return shim.Error("Invalid limit, expecting a non-negative integer value")
+		}
+		values[i] = value
+	}
+
+	now, err := txTime(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	limits := TierLimits{Tier: tier, PerTransaction: values[0], Daily: values[1], Monthly: values[2], UpdatedBy: admin.ID, UpdatedAt: now.Format(time.RFC3339)}
+	key, err := stub.CreateCompositeKey(tierLimitsIndexName, []string{tier})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	limitsAsBytes, _ := json.Marshal(limits)
+	if err := stub.PutState(key, limitsAsBytes); err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(limitsAsBytes)
+}
+
+// ==== queryLimits =========================================
+// queryLimits returns a user's tier, its limits and what the user has used of them today and this month.
+// 0
+// "userid"
+// ===========================================================================================
+func (t *SimpleChaincode) queryLimits(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	limits, err := getTierLimits(stub, userTier(stub, args[0]))
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	now, err := txTime(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	usage, _, err := getKYCUsage(stub, args[0], now)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	respAsBytes, _ := json.Marshal(struct {
+		UserID string     `json:"userid"`
+		Limits TierLimits `json:"limits"`
+		Usage  KYCUsage   `json:"usage"`
+	}{args[0], limits, usage})
+	return shim.Success(respAsBytes)
+}
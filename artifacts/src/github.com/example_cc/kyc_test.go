@@ -0,0 +1,75 @@
+package main
+
+import "testing"
+
+func TestMoveEnforcesTierLimits(t *testing.T) {
+	s := newTestStub(t)
+	s.register("alice", "Alice", "Able", "")
+	s.fund("alice", 2000)
+
+	// Unverified users are held to the TIER0 limits
+	s.mustFail(LimitExceeded, "alice", "move", "alice", "b", "101")
+	s.mustFail("only verified users can move above TIER0", "kyc", "setKYCTier", "alice", KYCTier1)
+	s.mustInvoke("kyc", "setKYCStatus", "alice", KYCVerified)
+	s.mustInvoke("kyc", "setKYCTier", "alice", KYCTier1)
+	s.mustInvoke("alice", "move", "alice", "b", "600")
+
+	s.mustFail("admin", "alice", "setTierLimits", KYCTier1, "0", "0", "0")
+	s.mustInvoke("admin", "setTierLimits", KYCTier1, "0", "700", "0")
+	s.mustFail(LimitExceeded, "alice", "move", "alice", "b", "200")
+	s.now = s.now.AddDate(0, 0, 1)
+	s.mustInvoke("alice", "move", "alice", "b", "200")
+	s.expectBalance("alice", 1200)
+
+	// A user who is no longer verified drops back to TIER0
+	s.mustInvoke("kyc", "setKYCStatus", "alice", KYCRejected)
+	s.mustFail(LimitExceeded, "alice", "move", "alice", "b", "101")
+}
+
+func TestMoveRejectsNonPositiveAmounts(t *testing.T) {
+	s := newTestStub(t)
+	s.mustFail("expecting a positive integer", "a", "move", "a", "b", "0")
+	s.mustFail("expecting a positive integer", "a", "move", "a", "b", "-50")
+	s.expectBalance("a", 1000)
+	s.expectBalance("b", 1000)
+}
+
+func TestAccountsWithoutProfileAreTier0(t *testing.T) {
+	s := newTestStub(t)
+	s.fund("c", 1000)
+	s.mustFail(LimitExceeded, "c", "move", "c", "b", "101")
+	s.mustInvoke("c", "move", "c", "b", "100")
+	s.expectBalance("b", 1100)
+}
+
+func TestEveryTransferEnforcesLimits(t *testing.T) {
+	s := newTestStub(t)
+	s.register("alice", "Alice", "Able", KYCTier0)
+	s.fund("alice", 1000)
+	expiry := "2017-11-20T00:00:00Z"
+
+	// Each of these moves 150 out of alice's account, over the TIER0 per transaction limit
+	s.mustInvoke("alice", "authorize", "h1", "alice", "b", "150", expiry)
+	s.mustFail(LimitExceeded, "b", "capture", "h1", "150")
+	s.mustInvoke("alice", "approve", "b", "500", expiry)
+	s.mustFail(LimitExceeded, "b", "transferFrom", "alice", "b", "150")
+	s.mustInvoke("alice", "createStandingOrder", "so1", "alice", "b", "150", FrequencyMonthly, "2017-11-01", "", "0")
+	s.mustInvoke("keeper", "processDue")
+	s.expectBalance("alice", 1000)
+
+	s.mustInvoke("b", "capture", "h1", "100")
+	s.expectBalance("alice", 900)
+}
+
+func TestMovesAndPaymentsShareUsage(t *testing.T) {
+	s := newTestStub(t)
+	s.mustInvoke("admin", "setTierLimits", KYCTier0, "0", "150", "0")
+	s.register("alice", "Alice", "Able", "")
+	s.fund("alice", 1000)
+
+	s.mustInvoke("alice", "move", "alice", "b", "100")
+	// A decimal target amount counts rounded up: 100 + 51 is over the daily limit
+	s.mustFail(LimitExceeded, "alice", "createPayment", "p1", "alice", "", "", "PROCESSED", "1", "0", "1", "50.5", "50.5", "USD", "USD", "", "2017-11-01T10:00:00Z", "2017-11-01T10:00:00Z")
+	s.mustInvoke("alice", "createPayment", "p1", "alice", "", "", "PROCESSED", "1", "0", "1", "49.5", "49.5", "USD", "USD", "", "2017-11-01T10:00:00Z", "2017-11-01T10:00:00Z")
+	s.mustFail(LimitExceeded, "alice", "move", "alice", "b", "1")
+}
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+var lateFeeIndexName = "latefee~recipientid" //composite key holding a recipient's late-fee rule
+
+// Late-fee rule types
+const (
+	LateFeeFlat       = "FLAT"       //Value charged once
+	LateFeePercentage = "PERCENTAGE" //Value in basis points of the outstanding amount, charged once
+	LateFeeDaily      = "DAILY"      //Value charged for every day past the grace period
+)
+
+// Aging buckets, by days past the due date
+var agingBuckets = []string{"0-30", "31-60", "61-90", "90+"}
+
+// LateFeeRule is how a recipient charges for bills paid late. Fees start
+// after GraceDays past the due date and, if MaxFee is set, stop at MaxFee.
+type LateFeeRule struct {
+	RecipientID string `json:"recipientid"`
+	Type        string `json:"type"`
+	Value       int    `json:"value"`
+	GraceDays   int    `json:"gracedays"`
+	MaxFee      int    `json:"maxfee"`
+	UpdatedAt   string `json:"updated_at"`
+}
+
+// OverdueOutcome reports what markOverdue did with one bill
+type OverdueOutcome struct {
+	BillID      string `json:"billid"`
+	RecipientID string `json:"recipientid"`
+	DaysPastDue int    `json:"dayspastdue"`
+	Fee         int    `json:"fee"`
+	Reason      string `json:"reason"`
+}
+
+// AgingBucket totals the outstanding bills in one aging bucket per currency
+type AgingBucket struct {
+	Bucket  string         `json:"bucket"`
+	Count   int            `json:"count"`
+	Totals  map[string]int `json:"totals"`
+	BillIDs []string       `json:"billids"`
+}
+
+// daysPastDue counts whole days from the bill's due date to the given day; it is negative before the due date
+func daysPastDue(bill Bill, now time.Time) (int, error) {
+	due, err := time.Parse(dateLayout, bill.BillDueDate)
+	if err != nil {
+		return 0, fmt.Errorf("Bill %s has an invalid due date: %s", bill.ID, bill.BillDueDate)
+	}
+	today, _ := time.Parse(dateLayout, now.Format(dateLayout))
+	return int(today.Sub(due).Hours() / 24), nil
+}
+
+func agingBucket(days int) string {
+	switch {
+	case days <= 30:
+		return agingBuckets[0]
+	case days <= 60:
+		return agingBuckets[1]
+	case days <= 90:
+		return agingBuckets[2]
+	}
+	return agingBuckets[3]
+}
+
+func getLateFeeRule(stub shim.ChaincodeStubInterface, recipientID string) (LateFeeRule, bool, error) {
+	var rule LateFeeRule
+	key, err := stub.CreateCompositeKey(lateFeeIndexName, []string{recipientID})
+	if err != nil {
+		return rule, false, err
+	}
+	ruleAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return rule, false, fmt.Errorf("Failed to get late-fee rule of %s", recipientID)
+	}
+	if ruleAsBytes == nil {
+		return rule, false, nil
+	}
+	err = json.Unmarshal(ruleAsBytes, &rule)
+	return rule, true, err
+}
+
+// lateFee works out the fee due on an overdue bill under a rule and moves
+// the bill's LateFeeThrough to today when a fee period has been charged
+func (rule LateFeeRule) lateFee(bill *Bill, days int, now time.Time) (int, error) {
+	if days <= rule.GraceDays {
+		return 0, nil
+	}
+	today := now.Format(dateLayout)
+	fee := 0
+	switch rule.Type {
+	case LateFeeDaily:
+		from := days - rule.GraceDays
+		if bill.LateFeeThrough != "" {
+			through, err := time.Parse(dateLayout, bill.LateFeeThrough)
+			if err != nil {
+				return 0, err
+			}
+			midnight, _ := time.Parse(dateLayout, today)
+			from = int(midnight.Sub(through).Hours() / 24)
+		}
+		fee = from * rule.Value
+	case LateFeePercentage:
+		if bill.LateFeeThrough == "" {
+			outstanding, err := bill.outstanding()
+			if err != nil {
+				return 0, err
+			}
+			fee = (outstanding*rule.Value + 5000) / 10000
+		}
+	default:
+		if bill.LateFeeThrough == "" {
+			fee = rule.Value
+		}
+	}
+	bill.LateFeeThrough = today
+	if rule.MaxFee > 0 && bill.LateFeeTotal+fee > rule.MaxFee {
+		fee = rule.MaxFee - bill.LateFeeTotal
+	}
+	if fee < 0 {
+		fee = 0
+	}
+	return fee, nil
+}
+
+// ==== setLateFeeRule =========================================
+// setLateFeeRule sets how the submitter, as a recipient, charges for late
+// bills. value is an amount for FLAT and DAILY fees and basis points of the
+// outstanding amount for PERCENTAGE fees. maxfee 0 means no cap.
+// 0                              1        2            3
+// "FLAT"|"PERCENTAGE"|"DAILY"    "value"  "gracedays"  "maxfee"
+// ===========================================================================================
+func (t *SimpleChaincode) setLateFeeRule(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 4 {
+		return shim.Error("Incorrect number of arguments. Expecting 4")
+	}
+
+	submitter, err := getParty(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	feeType := args[0]
+	if feeType != LateFeeFlat && feeType != LateFeePercentage && feeType != LateFeeDaily {
+		return shim.Error(fmt.Sprintf("Late fee type must be one of '%s', '%s' or '%s'. But got: %v", LateFeeFlat, LateFeePercentage, LateFeeDaily, feeType))
+	}
+	value, err := strconv.Atoi(args[1])
+	if err != nil || value <= 0 {
+		return shim.Error("Invalid value, expecting a positive integer value")
+	}
+	graceDays, err := strconv.Atoi(args[2])
+	if err != nil || graceDays < 0 {
+		return shim.Error("Invalid grace days, expecting a non-negative integer value")
+	}
+	maxFee, err := strconv.Atoi(args[3])
+	if err != nil || maxFee < 0 {
+		return shim.Error("Invalid maximum fee, expecting a non-negative integer value")
+	}
+
+	now, err := txTime(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	rule := LateFeeRule{RecipientID: submitter.ID, Type: feeType, Value: value, GraceDays: graceDays, MaxFee: maxFee, UpdatedAt: now.Format(time.RFC3339)}
+	key, err := stub.CreateCompositeKey(lateFeeIndexName, []string{rule.RecipientID})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	ruleAsBytes, _ := json.Marshal(rule)
+	if err := stub.PutState(key, ruleAsBytes); err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(ruleAsBytes)
+}
+
+// ==== queryLateFeeRule =========================================
+// 0
+// "recipientid"
+// ===========================================================================================
+func (t *SimpleChaincode) queryLateFeeRule(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	rule, found, err := getLateFeeRule(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if !found {
+		return shim.Error("No late-fee rule for " + args[0])
+	}
+
+	ruleAsBytes, _ := json.Marshal(rule)
+	return shim.Success(ruleAsBytes)
+}
+
+// ==== markOverdue =========================================
+// markOverdue flags every open bill whose due date is before the
+// transaction date as overdue and charges its recipient's late fee as a
+// debit note. Running it again the same day charges nothing more. Disputed
+// bills are flagged but not charged for the time they are under dispute.
+// The overdue state of installment plans is brought up to date as well.
+// ===========================================================================================
+func (t *SimpleChaincode) markOverdue(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	logger.Info("########### markOverdue ###########")
+	if len(args) != 0 {
+		return shim.Error("Incorrect number of arguments. Expecting 0")
+	}
+
+	now, err := txTime(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	indexAsBytes, err := stub.GetState(billIndexStr)
+	if err != nil {
+		return shim.Error("Failed to get bill index")
+	}
+	var bills AllBills
+	json.Unmarshal(indexAsBytes, &bills)
+
+	outcomes := []OverdueOutcome{}
+	for _, indexed := range bills.Bills {
+		if indexed.isOpen() {
+			if err := refreshInstallmentPlan(stub, indexed.ID, now); err != nil {
+				return shim.Error(err.Error())
+			}
+		}
+		if !indexed.isOpen() || indexed.BillDueDate == "" || indexed.BillDueDate >= now.Format(dateLayout) {
+			continue
+		}
+		bill, err := getBill(stub, indexed.ID)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		days, err := daysPastDue(bill, now)
+		if err != nil {
+			outcomes = append(outcomes, OverdueOutcome{BillID: bill.ID, RecipientID: bill.RecipientID, Reason: err.Error()})
+			continue
+		}
+		outcome := OverdueOutcome{BillID: bill.ID, RecipientID: bill.RecipientID, DaysPastDue: days}
+		bill.Overdue = true
+
+		rule, found, err := getLateFeeRule(stub, bill.RecipientID)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		fee := 0
+		switch {
+		case !found:
+			outcome.Reason = "no late-fee rule"
+		case bill.DisputeID != "":
+			outcome.Reason = "under dispute " + bill.DisputeID
+			if rule.Type == LateFeeDaily && days > rule.GraceDays {
+				bill.LateFeeThrough = now.Format(dateLayout)
+			}
+		default:
+			if fee, err = rule.lateFee(&bill, days, now); err != nil {
+				return shim.Error(err.Error())
+			}
+		}
+
+		if fee > 0 {
+			bill.LateFeeTotal += fee
+			note := Adjustment{ID: bill.ID + "-LATE-" + now.Format(dateLayout), Type: DebitNote, Amount: fee, ReasonCode: "LATE_FEE", Memo: fmt.Sprintf("%s late fee, %d days past due", rule.Type, days), CreatedBy: bill.RecipientID}
+			if _, err := adjustBill(stub, bill, note, now); err != nil {
+				return shim.Error(err.Error())
+			}
+		} else if err := putBill(stub, bill); err != nil {
+			return shim.Error(err.Error())
+		}
+		outcome.Fee = fee
+		outcomes = append(outcomes, outcome)
+	}
+	logger.Infof("markOverdue found %d overdue bills\n", len(outcomes))
+
+	outcomesAsBytes, _ := json.Marshal(outcomes)
+	return shim.Success(outcomesAsBytes)
+}
+
+// ==== queryAging =========================================
+// queryAging groups a recipient's outstanding bills by days past their due
+// date as of the transaction date. Bills not yet due count in 0-30.
+// 0
+// "recipientid"
+// ===========================================================================================
+func (t *SimpleChaincode) queryAging(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	now, err := txTime(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	indexAsBytes, err := stub.GetState(billIndexStr)
+	if err != nil {
+		return shim.Error("Failed to get bill index")
+	}
+	var bills AllBills
+	json.Unmarshal(indexAsBytes, &bills)
+
+	buckets := make([]AgingBucket, len(agingBuckets))
+	for i, name := range agingBuckets {
+		buckets[i] = AgingBucket{Bucket: name, Totals: map[string]int{}, BillIDs: []string{}}
+	}
+	for _, bill := range bills.Bills {
+		if bill.RecipientID != args[0] || !bill.isOpen() {
+			continue
+		}
+		outstanding, err := bill.outstanding()
+		if err != nil {
+			continue
+		}
+		days, err := daysPastDue(bill, now)
+		if err != nil {
+			continue
+		}
+		for i := range buckets {
+			if buckets[i].Bucket == agingBucket(days) {
+				buckets[i].Count++
+				buckets[i].Totals[bill.Currency] += outstanding
+				buckets[i].BillIDs = append(buckets[i].BillIDs, bill.ID)
+			}
+		}
+	}
+
+	bucketsAsBytes, _ := json.Marshal(buckets)
+	return shim.Success(bucketsAsBytes)
+}
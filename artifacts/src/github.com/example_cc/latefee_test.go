@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestQueryAgingBuckets(t *testing.T) {
+	s := newTestStub(t)
+	s.biller("acme")
+	s.register("alice", "Alice", "Able", KYCTier1)
+	s.issueBill("b1", "acme", "alice", 100, "2017-11-30")
+	s.issueBill("b2", "acme", "alice", 200, "2017-11-01")
+	s.issueBill("b3", "acme", "alice", 300, "2017-10-20")
+	s.issueBill("b4", "acme", "alice", 400, "2017-07-01")
+
+	var buckets []AgingBucket
+	if err := json.Unmarshal(s.mustInvoke("acme", "queryAging", "acme"), &buckets); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]string{"0-30": {"b1", "b2", "b3"}, "31-60": {}, "61-90": {}, "90+": {"b4"}}
+	if len(buckets) != len(want) {
+		t.Fatalf("got %d buckets, expected %d", len(buckets), len(want))
+	}
+	for _, bucket := range buckets {
+		ids := want[bucket.Bucket]
+		if len(bucket.BillIDs) != len(ids) {
+			t.Fatalf("bucket %s holds %v, expected %v", bucket.Bucket, bucket.BillIDs, ids)
+		}
+		for i := range ids {
+			if bucket.BillIDs[i] != ids[i] {
+				t.Fatalf("bucket %s holds %v, expected %v", bucket.Bucket, bucket.BillIDs, ids)
+			}
+		}
+	}
+}
+
+// overdue runs markOverdue and returns the fee charged on each bill
+func overdue(s *testStub) map[string]int {
+	var outcomes []OverdueOutcome
+	if err := json.Unmarshal(s.mustInvoke("keeper", "markOverdue"), &outcomes); err != nil {
+		s.t.Fatal(err)
+	}
+	fees := map[string]int{}
+	for _, outcome := range outcomes {
+		fees[outcome.BillID] = outcome.Fee
+	}
+	return fees
+}
+
+func TestMarkOverdueChargesDailyFees(t *testing.T) {
+	s := newTestStub(t)
+	s.biller("acme")
+	s.register("alice", "Alice", "Able", KYCTier1)
+	s.mustFail("Late fee type must be one of", "acme", "setLateFeeRule", "WEEKLY", "2", "5", "30")
+	s.mustInvoke("acme", "setLateFeeRule", LateFeeDaily, "2", "5", "30")
+	s.issueBill("b1", "acme", "alice", 100, "2017-10-20")
+	s.issueBill("b2", "acme", "alice", 100, "2017-10-20")
+	s.issueBill("b3", "acme", "alice", 100, "2017-11-30")
+	s.mustInvoke("alice", "openDispute", "d2", "b2", "QUALITY", strings.Repeat("ab", 32), "")
+
+	// b1 is 12 days past due, 7 of them past the grace period
+	if fees := overdue(s); len(fees) != 2 || fees["b1"] != 14 || fees["b2"] != 0 {
+		t.Fatalf("markOverdue charged %v, expected 14 on b1 and nothing on the disputed b2", fees)
+	}
+	bill := s.bill("b1")
+	if !bill.Overdue || bill.LateFeeTotal != 14 || bill.Outstanding != 114 {
+		t.Fatalf("b1 is overdue %v with %d in fees and %d outstanding, expected 14 in fees and 114 outstanding", bill.Overdue, bill.LateFeeTotal, bill.Outstanding)
+	}
+	if !s.bill("b2").Overdue {
+		t.Fatal("the disputed b2 was not flagged overdue")
+	}
+	if fees := overdue(s); fees["b1"] != 0 {
+		t.Fatalf("a second run the same day charged %d on b1", fees["b1"])
+	}
+
+	s.now = s.now.AddDate(0, 0, 1)
+	if fees := overdue(s); fees["b1"] != 2 {
+		t.Fatalf("a day later markOverdue charged %d on b1, expected 2", fees["b1"])
+	}
+	s.now = s.now.AddDate(0, 0, 30)
+	if fees := overdue(s); fees["b1"] != 14 {
+		t.Fatalf("a month later markOverdue charged %d on b1, expected 14 up to the maximum fee", fees["b1"])
+	}
+}
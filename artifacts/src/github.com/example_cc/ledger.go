@@ -0,0 +1,177 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+var journalPrefix = "JOURNAL" //prefix for the key/value that stores a journal entry, keyed by transaction ID
+
+// TransferLeg is a single A->B movement of units between two balance keys
+type TransferLeg struct {
+	From   string `json:"from"`
+	To     string `json:"to"`
+	Amount int    `json:"amount"`
+}
+
+// JournalEntry records every leg applied by one transaction
+type JournalEntry struct {
+	TxID      string        `json:"txid"`
+	Type      string        `json:"type"`
+	Legs      []TransferLeg `json:"legs"`
+	Timestamp string        `json:"tr_time"`
+}
+
+// txTime returns the proposal timestamp, which is the same on every endorsing peer
+func txTime(stub shim.ChaincodeStubInterface) (time.Time, error) {
+	ts, err := stub.GetTxTimestamp()
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(ts.Seconds, int64(ts.Nanos)).UTC(), nil
+}
+
+// getBalance reads the integer holding stored under an entity key, as written by Init and move
+func getBalance(stub shim.ChaincodeStubInterface, name string) (int, error) {
+	valbytes, err := stub.GetState(name)
+	if err != nil {
+		return 0, fmt.Errorf("Failed to get state for %s", name)
+	}
+	if valbytes == nil {
+		return 0, fmt.Errorf("Entity not found: %s", name)
+	}
+	val, err := strconv.Atoi(string(valbytes))
+	if err != nil {
+		return 0, fmt.Errorf("Invalid holding stored for %s", name)
+	}
+	return val, nil
+}
+
+func putBalance(stub shim.ChaincodeStubInterface, name string, val int) error {
+	return stub.PutState(name, []byte(strconv.Itoa(val)))
+}
+
+// writeJournal stores a single journal entry for the current transaction
+func writeJournal(stub shim.ChaincodeStubInterface, entryType string, legs []TransferLeg) (JournalEntry, error) {
+	now, err := txTime(stub)
+	if err != nil {
+		return JournalEntry{}, err
+	}
+	entry := JournalEntry{TxID: stub.GetTxID(), Type: entryType, Legs: legs, Timestamp: now.Format(time.RFC3339)}
+	entryAsBytes, _ := json.Marshal(entry)
+	if err := stub.PutState(journalPrefix+entry.TxID, entryAsBytes); err != nil {
+		return JournalEntry{}, err
+	}
+	return entry, nil
+}
+
+// debit takes units out of an account, refusing to dip into funds reserved by holds
+func debit(stub shim.ChaincodeStubInterface, account string, amount int) error {
+	val, available, err := availableBalance(stub, account)
+	if err != nil {
+		return err
+	}
+	if amount > available {
+		return fmt.Errorf("Insufficient available funds in %s: available %d, requested %d", account, available, amount)
+	}
+	return putBalance(stub, account, val-amount)
+}
+
+// credit adds units to an existing account
+func credit(stub shim.ChaincodeStubInterface, account string, amount int) error {
+	val, err := getBalance(stub, account)
+	if err != nil {
+		return err
+	}
+	return putBalance(stub, account, val+amount)
+}
+
+// transfer moves units between two existing accounts for the named
+// function. Sanctions screening, the KYC tier limits of the source and the
+// velocity rules apply; a transfer that would be held for review fails with
+// VELOCITY_HOLD. Every check runs before anything is written, so sweeps such
+// as processDue and autoPayDue can skip a failed transfer without leaving
+// usage, flags or alerts behind.
+func transfer(stub shim.ChaincodeStubInterface, function, from, to string, amount int) error {
+	if from == to {
+		return fmt.Errorf("Source and destination must differ")
+	}
+	if _, err := getBalance(stub, to); err != nil {
+		return err
+	}
+	if _, available, err := availableBalance(stub, from); err != nil {
+		return err
+	} else if amount > available {
+		return fmt.Errorf("Insufficient available funds in %s: available %d, requested %d", from, available, amount)
+	}
+	now, err := txTime(stub)
+	if err != nil {
+		return err
+	}
+	if _, _, err := checkLimits(stub, from, amount, now); err != nil {
+		return err
+	}
+	flags, err := screen(stub, function, from, to)
+	if err != nil {
+		return err
+	}
+	if alert, err := assessVelocity(stub, function, from, to, amount, now); err != nil {
+		return err
+	} else if alert != nil && alert.Action == VelocityHold {
+		return fmt.Errorf("%s: %s of %d from %s matched rules %v", VelocityHeld, function, amount, from, alert.RuleIDs)
+	}
+
+	if err := recordFlags(stub, flags); err != nil {
+		return err
+	}
+	if err := enforceLimits(stub, from, amount, now); err != nil {
+		return err
+	}
+	if err := enforceVelocity(stub, function, from, to, amount, now); err != nil {
+		return err
+	}
+	if err := debit(stub, from, amount); err != nil {
+		return err
+	}
+	return credit(stub, to, amount)
+}
+
+// txStub gives the chaincode read-your-writes within one transaction. The
+// peer's GetState only sees committed state, so a function that updates the
+// same key twice (e.g. two standing orders paid from one account) would
+// otherwise overwrite its first update with a value computed from stale state.
+type txStub struct {
+	shim.ChaincodeStubInterface
+	writes map[string][]byte
+}
+
+func newTxStub(stub shim.ChaincodeStubInterface) *txStub {
+	return &txStub{ChaincodeStubInterface: stub, writes: map[string][]byte{}}
+}
+
+func (s *txStub) GetState(key string) ([]byte, error) {
+	if value, ok := s.writes[key]; ok {
+		return value, nil
+	}
+	return s.ChaincodeStubInterface.GetState(key)
+}
+
+func (s *txStub) PutState(key string, value []byte) error {
+	if err := s.ChaincodeStubInterface.PutState(key, value); err != nil {
+		return err
+	}
+	s.writes[key] = value
+	return nil
+}
+
+func (s *txStub) DelState(key string) error {
+	if err := s.ChaincodeStubInterface.DelState(key); err != nil {
+		return err
+	}
+	s.writes[key] = nil
+	return nil
+}
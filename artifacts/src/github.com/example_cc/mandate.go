@@ -0,0 +1,290 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+var mandateIndexName = "mandate~userid~recipientid"                 //composite key holding the mandate a user granted a recipient
+var mandateCollectionIndexName = "collection~userid~recipientid~id" //composite key holding each bill collected under a mandate
+
+// Mandate statuses
+const (
+	MandateActive  = "ACTIVE"
+	MandateRevoked = "REVOKED"
+)
+
+// Mandate lets a recipient collect the user's bills, up to PerBillLimit per
+// bill and PeriodLimit per DAILY, WEEKLY or MONTHLY period
+type Mandate struct {
+	UserID            string `json:"userid"`
+	RecipientID       string `json:"recipientid"`
+	PerBillLimit      int    `json:"perbilllimit"`
+	PeriodLimit       int    `json:"periodlimit"`
+	Period            string `json:"period"`
+	CurrentPeriod     string `json:"currentperiod"`
+	CollectedInPeriod int    `json:"collectedinperiod"`
+	Status            string `json:"status"`
+	CreatedAt         string `json:"created_at"`
+	RevokedAt         string `json:"revoked_at"`
+}
+
+// MandateCollection records one bill collected under a mandate
+type MandateCollection struct {
+	BillID    string `json:"billid"`
+	Amount    int    `json:"amount"`
+	Period    string `json:"period"`
+	TxID      string `json:"txid"`
+	Timestamp string `json:"tr_time"`
+}
+
+// periodKey names the DAILY, WEEKLY or MONTHLY period a time falls in
+func periodKey(now time.Time, period string) string {
+	switch period {
+	case FrequencyDaily:
+		return now.Format(dateLayout)
+	case FrequencyWeekly:
+		year, week := now.ISOWeek()
+		return fmt.Sprintf("%04d-W%02d", year, week)
+	}
+	return now.Format("2006-01")
+}
+
+func getMandate(stub shim.ChaincodeStubInterface, userID, recipientID string) (Mandate, error) {
+	var mandate Mandate
+	key, err := stub.CreateCompositeKey(mandateIndexName, []string{userID, recipientID})
+	if err != nil {
+		return mandate, err
+	}
+	mandateAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return mandate, fmt.Errorf("Failed to get mandate of %s for %s", userID, recipientID)
+	}
+	if mandateAsBytes == nil {
+		return mandate, fmt.Errorf("No mandate from %s to %s", userID, recipientID)
+	}
+	err = json.Unmarshal(mandateAsBytes, &mandate)
+	return mandate, err
+}
+
+func putMandate(stub shim.ChaincodeStubInterface, mandate Mandate) error {
+	key, err := stub.CreateCompositeKey(mandateIndexName, []string{mandate.UserID, mandate.RecipientID})
+	if err != nil {
+		return err
+	}
+	mandateAsBytes, _ := json.Marshal(mandate)
+	return stub.PutState(key, mandateAsBytes)
+}
+
+// ==== createMandate =========================================
+// createMandate lets a recipient collect the submitter's bills.
+// 0              1               2              3
+// "recipientid"  "perbilllimit"  "periodlimit"  "MONTHLY"
+// ===========================================================================================
+func (t *SimpleChaincode) createMandate(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 4 {
+		return shim.Error("Incorrect number of arguments. Expecting 4")
+	}
+
+	submitter, err := getParty(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	recipientID := args[0]
+	perBillLimit, err := strconv.Atoi(args[1])
+	if err != nil || perBillLimit <= 0 {
+		return shim.Error("Invalid per bill limit, expecting a positive integer value")
+	}
+	periodLimit, err := strconv.Atoi(args[2])
+	if err != nil || periodLimit < perBillLimit {
+		return shim.Error("Invalid period limit, expecting an integer value no lower than the per bill limit")
+	}
+	period := args[3]
+	if period != FrequencyDaily && period != FrequencyWeekly && period != FrequencyMonthly {
+		return shim.Error(fmt.Sprintf("Period must be one of '%s', '%s' or '%s'. But got: %v", FrequencyDaily, FrequencyWeekly, FrequencyMonthly, period))
+	}
+	if recipientID == submitter.ID {
+		return shim.Error("A user cannot grant a mandate to itself")
+	}
+
+	if existing, err := getMandate(stub, submitter.ID, recipientID); err == nil && existing.Status == MandateActive {
+		return shim.Error(fmt.Sprintf("An active mandate from %s to %s already exists, revoke it first", submitter.ID, recipientID))
+	}
+
+	now, err := txTime(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	mandate := Mandate{UserID: submitter.ID, RecipientID: recipientID, PerBillLimit: perBillLimit, PeriodLimit: periodLimit, Period: period, CurrentPeriod: periodKey(now, period), Status: MandateActive, CreatedAt: now.Format(time.RFC3339)}
+	if err := putMandate(stub, mandate); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	mandateAsBytes, _ := json.Marshal(mandate)
+	return shim.Success(mandateAsBytes)
+}
+
+// ==== revokeMandate =========================================
+// revokeMandate stops a recipient from collecting any more of the submitter's bills.
+// 0
+// "recipientid"
+// ===========================================================================================
+func (t *SimpleChaincode) revokeMandate(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	submitter, err := getParty(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	mandate, err := getMandate(stub, submitter.ID, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if mandate.Status != MandateActive {
+		return shim.Error(fmt.Sprintf("Mandate from %s to %s is already %s", mandate.UserID, mandate.RecipientID, mandate.Status))
+	}
+
+	now, err := txTime(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	mandate.Status = MandateRevoked
+	mandate.RevokedAt = now.Format(time.RFC3339)
+	if err := putMandate(stub, mandate); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	mandateAsBytes, _ := json.Marshal(mandate)
+	return shim.Success(mandateAsBytes)
+}
+
+// ==== queryMandate =========================================
+// queryMandate returns a mandate together with every bill collected under it.
+// 0         1
+// "userid"  "recipientid"
+// ===========================================================================================
+func (t *SimpleChaincode) queryMandate(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+
+	mandate, err := getMandate(stub, args[0], args[1])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(mandateCollectionIndexName, []string{args[0], args[1]})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	collections := []MandateCollection{}
+	for resultsIterator.HasNext() {
+		responseRange, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		var collection MandateCollection
+		json.Unmarshal(responseRange.Value, &collection)
+		collections = append(collections, collection)
+	}
+
+	respAsBytes, _ := json.Marshal(struct {
+		Mandate     Mandate             `json:"mandate"`
+		Collections []MandateCollection `json:"collections"`
+	}{mandate, collections})
+	return shim.Success(respAsBytes)
+}
+
+// ==== collectBill =========================================
+// collectBill is run by a bill's recipient once the bill is due. It debits
+// the user under their mandate and marks the bill paid.
+// 0
+// "id"
+// ===========================================================================================
+func (t *SimpleChaincode) collectBill(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	submitter, err := getParty(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	bill, err := getBill(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if bill.RecipientID != submitter.ID {
+		return shim.Error(fmt.Sprintf("Only recipient %s can collect bill %s", bill.RecipientID, bill.ID))
+	}
+	if bill.DisputeID != "" {
+		return shim.Error(fmt.Sprintf("Bill %s is under dispute %s", bill.ID, bill.DisputeID))
+	}
+	now, err := txTime(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	amount, err := bill.amountToSettle(now)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if now.Format(dateLayout) < bill.BillDueDate {
+		return shim.Error(fmt.Sprintf("Bill %s is not due until %s", bill.ID, bill.BillDueDate))
+	}
+
+	mandate, err := getMandate(stub, bill.UserID, bill.RecipientID)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if mandate.Status != MandateActive {
+		return shim.Error(fmt.Sprintf("Mandate from %s to %s is %s", mandate.UserID, mandate.RecipientID, mandate.Status))
+	}
+	if amount > mandate.PerBillLimit {
+		return shim.Error(fmt.Sprintf("Bill amount %d exceeds the mandate's per bill limit %d", amount, mandate.PerBillLimit))
+	}
+	period := periodKey(now, mandate.Period)
+	if period != mandate.CurrentPeriod {
+		mandate.CurrentPeriod = period
+		mandate.CollectedInPeriod = 0
+	}
+	if mandate.CollectedInPeriod+amount > mandate.PeriodLimit {
+		return shim.Error(fmt.Sprintf("Collecting %d would exceed the mandate's period limit %d, %d already collected in %s", amount, mandate.PeriodLimit, mandate.CollectedInPeriod, period))
+	}
+
+	bill, err = settleBill(stub, bill, bill.UserID, now)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	mandate.CollectedInPeriod += amount
+	if err := putMandate(stub, mandate); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	collectionKey, err := stub.CreateCompositeKey(mandateCollectionIndexName, []string{mandate.UserID, mandate.RecipientID, bill.ID})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	collectionAsBytes, _ := json.Marshal(MandateCollection{BillID: bill.ID, Amount: amount, Period: period, TxID: stub.GetTxID(), Timestamp: now.Format(time.RFC3339)})
+	if err := stub.PutState(collectionKey, collectionAsBytes); err != nil {
+		return shim.Error(err.Error())
+	}
+	payout, err := payoutAccount(stub, bill.RecipientID)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if _, err := writeJournal(stub, "collectBill", []TransferLeg{{From: bill.UserID, To: payout, Amount: amount}}); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	billAsBytes, _ := json.Marshal(bill)
+	return shim.Success(billAsBytes)
+}
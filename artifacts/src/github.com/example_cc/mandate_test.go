@@ -0,0 +1,55 @@
+package main
+
+import "testing"
+
+func TestCollectBillUnderMandate(t *testing.T) {
+	s := newTestStub(t)
+	s.biller("acme")
+	s.register("alice", "Alice", "Able", KYCTier1)
+	s.fund("alice", 1000)
+	s.mustInvoke("alice", "createMandate", "acme", "300", "500", FrequencyMonthly)
+	s.issueBill("B1", "acme", "alice", 250, "2017-11-01")
+	s.issueBill("B2", "acme", "alice", 400, "2017-11-01")
+	s.issueBill("B3", "acme", "alice", 300, "2017-11-01")
+	s.issueBill("B4", "acme", "alice", 100, "2017-11-30")
+
+	s.mustFail("Only recipient acme can collect", "alice", "collectBill", "B1")
+	s.mustInvoke("acme", "collectBill", "B1")
+	s.expectBalance("alice", 750)
+	s.expectBalance("acme", 250)
+	if bill := s.bill("B1"); bill.Status != BillPaid {
+		t.Fatalf("B1 is %s, expected %s", bill.Status, BillPaid)
+	}
+
+	s.mustFail("exceeds the mandate's per bill limit 300", "acme", "collectBill", "B2")
+	s.mustFail("would exceed the mandate's period limit 500, 250 already collected in 2017-11", "acme", "collectBill", "B3")
+	s.mustFail("Bill B4 is not due until 2017-11-30", "acme", "collectBill", "B4")
+
+	// The period limit starts over in December
+	s.now = s.now.AddDate(0, 1, 0)
+	s.mustInvoke("acme", "collectBill", "B3")
+	s.expectBalance("alice", 450)
+}
+
+func TestRevokedMandateStopsCollection(t *testing.T) {
+	s := newTestStub(t)
+	s.biller("acme")
+	s.register("alice", "Alice", "Able", KYCTier1)
+	s.fund("alice", 1000)
+
+	s.mustFail("cannot grant a mandate to itself", "alice", "createMandate", "alice", "300", "500", FrequencyMonthly)
+	s.mustFail("Invalid period limit", "alice", "createMandate", "acme", "300", "200", FrequencyMonthly)
+	s.mustInvoke("alice", "createMandate", "acme", "300", "500", FrequencyMonthly)
+	s.mustFail("already exists, revoke it first", "alice", "createMandate", "acme", "300", "500", FrequencyMonthly)
+	s.issueBill("B1", "acme", "alice", 100, "2017-11-01")
+
+	s.mustInvoke("alice", "revokeMandate", "acme")
+	s.mustFail("Mandate from alice to acme is REVOKED", "acme", "collectBill", "B1")
+	s.mustFail("is already REVOKED", "alice", "revokeMandate", "acme")
+	s.expectBalance("alice", 1000)
+
+	// A new mandate can be granted once the old one is revoked
+	s.mustInvoke("alice", "createMandate", "acme", "300", "500", FrequencyMonthly)
+	s.mustInvoke("acme", "collectBill", "B1")
+	s.expectBalance("alice", 900)
+}
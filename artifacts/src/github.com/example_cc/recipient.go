@@ -0,0 +1,199 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"unicode/utf8"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+var recipientIndexName = "recipientid~id" //composite index of the bills issued by a recipient
+
+// maxPageSize bounds a page of queryRecipientBills
+const maxPageSize = 100
+
+// RecipientBillsPage is one page of a recipient's bills. Bookmark is passed
+// back to fetch the next page and is empty once no bills are left. With
+// filters the page after a bookmark may still come back empty.
+type RecipientBillsPage struct {
+	Bills    []Bill `json:"bills"`
+	Bookmark string `json:"bookmark"`
+}
+
+// CurrencyOutstanding totals a recipient's open bills in one currency
+type CurrencyOutstanding struct {
+	Currency    string `json:"currency"`
+	Count       int    `json:"count"`
+	Outstanding int    `json:"outstanding"`
+}
+
+// billIDsForRecipient returns the IDs of the bills indexed under a recipient, in index order
+func billIDsForRecipient(stub shim.ChaincodeStubInterface, recipientID string) ([]string, error) {
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(recipientIndexName, []string{recipientID})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	var ids []string
+	for resultsIterator.HasNext() {
+		responseRange, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		_, compositeKeyParts, err := stub.SplitCompositeKey(responseRange.Key)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, compositeKeyParts[1])
+	}
+	return ids, nil
+}
+
+// ==== queryRecipientBills =========================================
+// queryRecipientBills pages through the bills a recipient issued, ordered by
+// bill ID. status and the due date bounds are optional filters, empty to
+// match any; the due date bounds are included. bookmark is empty for the
+// first page. A page reads the recipient index from the bookmark on and
+// stops as soon as it is full.
+// 0              1         2             3             4           5
+// "recipientid"  "ISSUED"  "2017-11-01"  "2017-11-30"  "pagesize"  "bookmark"
+// ===========================================================================================
+func (t *SimpleChaincode) queryRecipientBills(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 6 {
+		return shim.Error("Incorrect number of arguments. Expecting 6")
+	}
+
+	recipientID, status, dueFrom, dueTo, bookmark := args[0], args[1], args[2], args[3], args[5]
+	pageSize, err := strconv.Atoi(args[4])
+	if err != nil || pageSize <= 0 || pageSize > maxPageSize {
+		return shim.Error("Invalid page size, expecting an integer value from 1 to " + strconv.Itoa(maxPageSize))
+	}
+
+	prefix, err := stub.CreateCompositeKey(recipientIndexName, []string{recipientID})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	startKey := prefix
+	if bookmark != "" {
+		if startKey, err = stub.CreateCompositeKey(recipientIndexName, []string{recipientID, bookmark}); err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+	resultsIterator, err := stub.GetStateByRange(startKey, prefix+string(utf8.MaxRune))
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	page := RecipientBillsPage{Bills: []Bill{}}
+	for resultsIterator.HasNext() {
+		if len(page.Bills) == pageSize {
+			page.Bookmark = page.Bills[pageSize-1].ID
+			break
+		}
+		responseRange, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		_, compositeKeyParts, err := stub.SplitCompositeKey(responseRange.Key)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		id := compositeKeyParts[1]
+		if id == bookmark {
+			continue
+		}
+		bill, err := getBill(stub, id)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		billStatus := bill.Status
+		if billStatus == "" {
+			billStatus = BillIssued
+		}
+		if (status != "" && billStatus != status) || (dueFrom != "" && bill.BillDueDate < dueFrom) || (dueTo != "" && bill.BillDueDate > dueTo) {
+			continue
+		}
+		page.Bills = append(page.Bills, bill)
+	}
+
+	pageAsBytes, _ := json.Marshal(page)
+	return shim.Success(pageAsBytes)
+}
+
+// ==== queryRecipientOutstanding =========================================
+// queryRecipientOutstanding totals what is outstanding on a recipient's open bills per currency.
+// 0
+// "recipientid"
+// ===========================================================================================
+func (t *SimpleChaincode) queryRecipientOutstanding(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	ids, err := billIDsForRecipient(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	totals := []CurrencyOutstanding{}
+	for _, id := range ids {
+		bill, err := getBill(stub, id)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if !bill.isOpen() {
+			continue
+		}
+		outstanding, err := bill.outstanding()
+		if err != nil {
+			continue
+		}
+		found := false
+		for i := range totals {
+			if totals[i].Currency == bill.Currency {
+				totals[i].Count++
+				totals[i].Outstanding += outstanding
+				found = true
+			}
+		}
+		if !found {
+			totals = append(totals, CurrencyOutstanding{Currency: bill.Currency, Count: 1, Outstanding: outstanding})
+		}
+	}
+
+	totalsAsBytes, _ := json.Marshal(totals)
+	return shim.Success(totalsAsBytes)
+}
+
+// ==== reindexRecipients =========================================
+// reindexRecipients adds the bills created before the recipient index existed to it. Admin only.
+// ===========================================================================================
+func (t *SimpleChaincode) reindexRecipients(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 0 {
+		return shim.Error("Incorrect number of arguments. Expecting 0")
+	}
+	if _, err := requireRole(stub, RoleAdmin); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	indexAsBytes, err := stub.GetState(billIndexStr)
+	if err != nil {
+		return shim.Error("Failed to get bill index")
+	}
+	var bills AllBills
+	json.Unmarshal(indexAsBytes, &bills)
+
+	for _, bill := range bills.Bills {
+		indexKey, err := stub.CreateCompositeKey(recipientIndexName, []string{bill.RecipientID, bill.ID})
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if err := stub.PutState(indexKey, []byte{0x00}); err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+	return shim.Success([]byte(strconv.Itoa(len(bills.Bills))))
+}
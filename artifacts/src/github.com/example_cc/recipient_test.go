@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func recipientPage(s *testStub, status, pageSize, bookmark string) RecipientBillsPage {
+	var page RecipientBillsPage
+	if err := json.Unmarshal(s.mustInvoke("acme", "queryRecipientBills", "acme", status, "", "", pageSize, bookmark), &page); err != nil {
+		s.t.Fatal(err)
+	}
+	return page
+}
+
+func pageIDs(page RecipientBillsPage) []string {
+	ids := []string{}
+	for _, bill := range page.Bills {
+		ids = append(ids, bill.ID)
+	}
+	return ids
+}
+
+func TestQueryRecipientBillsPages(t *testing.T) {
+	s := newTestStub(t)
+	s.biller("acme")
+	s.biller("globex")
+	s.register("alice", "Alice", "Able", KYCTier1)
+	for _, id := range []string{"b1", "b2", "b3", "b4", "b5"} {
+		s.issueBill(id, "acme", "alice", 100, "2017-11-30")
+	}
+	s.issueBill("b0", "globex", "alice", 100, "2017-11-30")
+
+	var ids [][]string
+	bookmark := ""
+	for {
+		page := recipientPage(s, "", "2", bookmark)
+		ids = append(ids, pageIDs(page))
+		if bookmark = page.Bookmark; bookmark == "" {
+			break
+		}
+	}
+	if got, _ := json.Marshal(ids); string(got) != `[["b1","b2"],["b3","b4"],["b5"]]` {
+		t.Fatalf("pages are %s", got)
+	}
+}
+
+func TestQueryRecipientBillsLastFullPageHasNoBookmark(t *testing.T) {
+	s := newTestStub(t)
+	s.biller("acme")
+	s.register("alice", "Alice", "Able", KYCTier1)
+	for _, id := range []string{"b1", "b2", "b3", "b4"} {
+		s.issueBill(id, "acme", "alice", 100, "2017-11-30")
+	}
+
+	page := recipientPage(s, "", "2", "b2")
+	if got, _ := json.Marshal(pageIDs(page)); string(got) != `["b3","b4"]` || page.Bookmark != "" {
+		t.Fatalf("last page is %s with bookmark %q, expected b3 and b4 without one", got, page.Bookmark)
+	}
+}
+
+func TestQueryRecipientBillsFiltersByStatus(t *testing.T) {
+	s := newTestStub(t)
+	s.biller("acme")
+	s.register("alice", "Alice", "Able", KYCTier1)
+	s.fund("alice", 1000)
+	for _, id := range []string{"b1", "b2", "b3"} {
+		s.issueBill(id, "acme", "alice", 100, "2017-11-30")
+	}
+	s.mustPay("p1", "alice", 100, `[{"billid":"b2","amount":100}]`)
+
+	page := recipientPage(s, BillIssued, "5", "")
+	if got, _ := json.Marshal(pageIDs(page)); string(got) != `["b1","b3"]` || page.Bookmark != "" {
+		t.Fatalf("issued bills are %s with bookmark %q, expected b1 and b3", got, page.Bookmark)
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+var roleIndexName = "role~mspid~id" //composite key marking an identity as holding a role
+
+// Roles that gate administrative functions
+const (
+	RoleAdmin       = "admin"
+	RoleKYCProvider = "kycprovider" //sets the KYC status and tier of users
+	RoleCompliance  = "compliance"  //maintains the sanctions list and reviews screening flags
+)
+
+func roleKey(stub shim.ChaincodeStubInterface, role string, who Submitter) (string, error) {
+	return stub.CreateCompositeKey(roleIndexName, []string{role, who.MSPID, who.ID})
+}
+
+func hasRole(stub shim.ChaincodeStubInterface, who Submitter, role string) (bool, error) {
+	key, err := roleKey(stub, role, who)
+	if err != nil {
+		return false, err
+	}
+	value, err := stub.GetState(key)
+	if err != nil {
+		return false, err
+	}
+	return value != nil, nil
+}
+
+func putRole(stub shim.ChaincodeStubInterface, who Submitter, role string) error {
+	key, err := roleKey(stub, role, who)
+	if err != nil {
+		return err
+	}
+	return stub.PutState(key, []byte{0x00})
+}
+
+// requireRole returns the submitter if it holds the role, or an error naming the missing role
+func requireRole(stub shim.ChaincodeStubInterface, role string) (Submitter, error) {
+	submitter, err := getSubmitter(stub)
+	if err != nil {
+		return submitter, err
+	}
+	ok, err := hasRole(stub, submitter, role)
+	if err != nil {
+		return submitter, err
+	}
+	if !ok {
+		return submitter, fmt.Errorf("%s of %s does not hold the %s role", submitter.ID, submitter.MSPID, role)
+	}
+	return submitter, nil
+}
+
+// ==== grantRole =========================================
+// grantRole gives an identity a role. Only admins may grant roles; the
+// identity that instantiates the chaincode is the first admin.
+// 0       1        2
+// "role"  "mspid"  "id"
+// ===========================================================================================
+func (t *SimpleChaincode) grantRole(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 3")
+	}
+	if _, err := requireRole(stub, RoleAdmin); err != nil {
+		return shim.Error(err.Error())
+	}
+	if args[0] == "" || args[1] == "" || args[2] == "" {
+		return shim.Error("Role, MSP ID and ID are required")
+	}
+
+	if err := putRole(stub, Submitter{MSPID: args[1], ID: args[2]}, args[0]); err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(nil)
+}
+
+// ==== revokeRole =========================================
+// 0       1        2
+// "role"  "mspid"  "id"
+// ===========================================================================================
+func (t *SimpleChaincode) revokeRole(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 3")
+	}
+	submitter, err := requireRole(stub, RoleAdmin)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	target := Submitter{MSPID: args[1], ID: args[2]}
+	if args[0] == RoleAdmin && target == submitter {
+		return shim.Error("An admin cannot revoke its own admin role")
+	}
+
+	key, err := roleKey(stub, args[0], target)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := stub.DelState(key); err != nil {
+		return shim.Error("Failed to delete state")
+	}
+	return shim.Success(nil)
+}
+
+// ==== queryRoles =========================================
+// queryRoles lists every identity holding a role.
+// 0
+// "role"
+// ===========================================================================================
+func (t *SimpleChaincode) queryRoles(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(roleIndexName, []string{args[0]})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	holders := []Submitter{}
+	for resultsIterator.HasNext() {
+		responseRange, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		_, compositeKeyParts, err := stub.SplitCompositeKey(responseRange.Key)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		holders = append(holders, Submitter{MSPID: compositeKeyParts[1], ID: compositeKeyParts[2]})
+	}
+
+	holdersAsBytes, _ := json.Marshal(holders)
+	return shim.Success(holdersAsBytes)
+}
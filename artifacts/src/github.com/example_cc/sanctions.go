@@ -0,0 +1,321 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+var sanctionsIndexName = "sanction~id"                           //composite key holding an entry of the sanctions list
+var screeningFlagIndexName = "screeningflag~txid~userid~entryid" //composite key holding a transaction party flagged by screening
+
+// SanctionsBlocked starts the message of the error raised when a party is on the sanctions list
+const SanctionsBlocked = "SANCTIONS_BLOCKED"
+
+// What a match against a sanctions entry does to the transaction
+const (
+	SanctionBlock = "BLOCK"
+	SanctionFlag  = "FLAG"
+)
+
+// How a party matched a sanctions entry
+const (
+	MatchExact = "EXACT"
+	MatchFuzzy = "FUZZY"
+)
+
+// SanctionsEntry is a listed person. Exact matches of a BLOCK entry, after
+// normalizing the names, stop the transaction; fuzzy matches, and matches of
+// FLAG entries, flag it for review.
+type SanctionsEntry struct {
+	ID        string `json:"id"`
+	FirstName string `json:"firstname"`
+	LastName  string `json:"lastname"`
+	Action    string `json:"action"`
+	Source    string `json:"source"`
+	AddedBy   string `json:"addedby"`
+	AddedAt   string `json:"added_at"`
+}
+
+// ScreeningFlag records a transaction party that matched the sanctions list
+type ScreeningFlag struct {
+	TxID      string `json:"txid"`
+	Function  string `json:"function"`
+	UserID    string `json:"userid"`
+	EntryID   string `json:"entryid"`
+	MatchType string `json:"matchtype"`
+	Distance  int    `json:"distance"` //edits between the normalized names
+	Timestamp string `json:"tr_time"`
+}
+
+// normalizeName lowercases a name and keeps only its letters, so that
+// spacing, punctuation and case do not defeat a match
+func normalizeName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' {
+			return r
+		}
+		if r >= 'A' && r <= 'Z' {
+			return r + 'a' - 'A'
+		}
+		if r > 127 {
+			return r
+		}
+		return -1
+	}, name)
+}
+
+// editDistance is the Levenshtein distance between two strings
+func editDistance(a, b string) int {
+	ar, br := []rune(a), []rune(b)
+	prev := make([]int, len(br)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ar); i++ {
+		cur := make([]int, len(br)+1)
+		cur[0] = i
+		for j := 1; j <= len(br); j++ {
+			cost := 1
+			if ar[i-1] == br[j-1] {
+				cost = 0
+			}
+			cur[j] = prev[j-1] + cost
+			if prev[j]+1 < cur[j] {
+				cur[j] = prev[j] + 1
+			}
+			if cur[j-1]+1 < cur[j] {
+				cur[j] = cur[j-1] + 1
+			}
+		}
+		prev = cur
+	}
+	return prev[len(br)]
+}
+
+// matchName compares a party's names with an entry. The names are
+// normalized and compared in either order; they match exactly when they are
+// equal and fuzzily when they are within a few edits.
+func (e SanctionsEntry) matchName(firstName, lastName string) (string, int, bool) {
+	first, last := normalizeName(firstName), normalizeName(lastName)
+	entryFirst, entryLast := normalizeName(e.FirstName), normalizeName(e.LastName)
+	if first == "" || last == "" {
+		return "", 0, false
+	}
+	distance := editDistance(first, entryFirst) + editDistance(last, entryLast)
+	if swapped := editDistance(first, entryLast) + editDistance(last, entryFirst); swapped < distance {
+		distance = swapped
+	}
+	return e.classify(distance)
+}
+
+// matchAccount compares an account name, for a party without a profile,
+// with the entry's full name in either order
+func (e SanctionsEntry) matchAccount(account string) (string, int, bool) {
+	name := normalizeName(account)
+	if name == "" {
+		return "", 0, false
+	}
+	entryFirst, entryLast := normalizeName(e.FirstName), normalizeName(e.LastName)
+	distance := editDistance(name, entryFirst+entryLast)
+	if swapped := editDistance(name, entryLast+entryFirst); swapped < distance {
+		distance = swapped
+	}
+	return e.classify(distance)
+}
+
+// classify turns the edits between a party's and the entry's normalized names into a match
+func (e SanctionsEntry) classify(distance int) (string, int, bool) {
+	if distance == 0 {
+		return MatchExact, 0, true
+	}
+	allowed := 1
+	if len(normalizeName(e.FirstName))+len(normalizeName(e.LastName)) > 10 {
+		allowed = 2
+	}
+	return MatchFuzzy, distance, distance <= allowed
+}
+
+func sanctionsEntries(stub shim.ChaincodeStubInterface) ([]SanctionsEntry, error) {
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(sanctionsIndexName, []string{})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	var entries []SanctionsEntry
+	for resultsIterator.HasNext() {
+		responseRange, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		var entry SanctionsEntry
+		if err := json.Unmarshal(responseRange.Value, &entry); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// screen checks a transaction's parties against the sanctions list without
+// writing anything. An exact match of a BLOCK entry fails with
+// SANCTIONS_BLOCKED; any other match is returned as a flag. Registered users
+// are screened by the names on their profile, other parties by their account name.
+func screen(stub shim.ChaincodeStubInterface, function string, userIDs ...string) ([]ScreeningFlag, error) {
+	entries, err := sanctionsEntries(stub)
+	if err != nil || len(entries) == 0 {
+		return nil, err
+	}
+	now, err := txTime(stub)
+	if err != nil {
+		return nil, err
+	}
+	var flags []ScreeningFlag
+	for _, userID := range userIDs {
+		profile, err := getUserProfile(stub, userID)
+		registered := err == nil
+		for _, entry := range entries {
+			matchType, distance, ok := entry.matchAccount(userID)
+			if registered {
+				matchType, distance, ok = entry.matchName(profile.FirstName, profile.LastName)
+			}
+			if !ok {
+				continue
+			}
+			if matchType == MatchExact && entry.Action == SanctionBlock {
+				return nil, fmt.Errorf("%s: %s matches sanctions entry %s", SanctionsBlocked, userID, entry.ID)
+			}
+			flags = append(flags, ScreeningFlag{TxID: stub.GetTxID(), Function: function, UserID: userID, EntryID: entry.ID, MatchType: matchType, Distance: distance, Timestamp: now.Format(time.RFC3339)})
+		}
+	}
+	return flags, nil
+}
+
+// recordFlags stores screening flags for review
+func recordFlags(stub shim.ChaincodeStubInterface, flags []ScreeningFlag) error {
+	for _, flag := range flags {
+		key, err := stub.CreateCompositeKey(screeningFlagIndexName, []string{flag.TxID, flag.UserID, flag.EntryID})
+		if err != nil {
+			return err
+		}
+		flagAsBytes, _ := json.Marshal(flag)
+		if err := stub.PutState(key, flagAsBytes); err != nil {
+			return err
+		}
+		logger.Infof("%s flagged %s against sanctions entry %s (%s)\n", flag.Function, flag.UserID, flag.EntryID, flag.MatchType)
+	}
+	return nil
+}
+
+// screenParties screens a transaction's parties and records any flags
+func screenParties(stub shim.ChaincodeStubInterface, function string, userIDs ...string) error {
+	flags, err := screen(stub, function, userIDs...)
+	if err != nil {
+		return err
+	}
+	return recordFlags(stub, flags)
+}
+
+// ==== addSanctionsEntry =========================================
+// addSanctionsEntry adds or replaces an entry of the sanctions list. Compliance only.
+// 0     1            2           3                 4
+// "id"  "firstname"  "lastname"  "BLOCK"|"FLAG"    "source"
+// ===========================================================================================
+func (t *SimpleChaincode) addSanctionsEntry(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 5 {
+		return shim.Error("Incorrect number of arguments. Expecting 5")
+	}
+	officer, err := requireRole(stub, RoleCompliance)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if args[0] == "" || normalizeName(args[1]) == "" || normalizeName(args[2]) == "" {
+		return shim.Error("ID, first name and last name are required")
+	}
+	action := args[3]
+	if action != SanctionBlock && action != SanctionFlag {
+		return shim.Error(fmt.Sprintf("Action must be either '%s' or '%s'. But got: %v", SanctionBlock, SanctionFlag, action))
+	}
+
+	now, err := txTime(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	entry := SanctionsEntry{ID: args[0], FirstName: args[1], LastName: args[2], Action: action, Source: args[4], AddedBy: officer.ID, AddedAt: now.Format(time.RFC3339)}
+	key, err := stub.CreateCompositeKey(sanctionsIndexName, []string{entry.ID})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	entryAsBytes, _ := json.Marshal(entry)
+	if err := stub.PutState(key, entryAsBytes); err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(entryAsBytes)
+}
+
+// ==== removeSanctionsEntry =========================================
+// removeSanctionsEntry takes an entry off the sanctions list. Compliance only.
+// 0
+// "id"
+// ===========================================================================================
+func (t *SimpleChaincode) removeSanctionsEntry(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+	if _, err := requireRole(stub, RoleCompliance); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	key, err := stub.CreateCompositeKey(sanctionsIndexName, []string{args[0]})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	entryAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if entryAsBytes == nil {
+		return shim.Error("Sanctions entry not found: " + args[0])
+	}
+	if err := stub.DelState(key); err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(nil)
+}
+
+// ==== queryScreeningFlags =========================================
+// queryScreeningFlags lists every transaction party flagged by sanctions screening. Compliance only.
+// ===========================================================================================
+func (t *SimpleChaincode) queryScreeningFlags(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 0 {
+		return shim.Error("Incorrect number of arguments. Expecting 0")
+	}
+	if _, err := requireRole(stub, RoleCompliance); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(screeningFlagIndexName, []string{})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	flags := []ScreeningFlag{}
+	for resultsIterator.HasNext() {
+		responseRange, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		var flag ScreeningFlag
+		json.Unmarshal(responseRange.Value, &flag)
+		flags = append(flags, flag)
+	}
+
+	flagsAsBytes, _ := json.Marshal(flags)
+	return shim.Success(flagsAsBytes)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"testing"
+)
+
+func TestScreeningBlocksAndFlagsParties(t *testing.T) {
+	s := newTestStub(t)
+	s.grant(RoleCompliance, "officer")
+	s.register("mallory", "Mal", "Lory", "")
+	s.fund("mallory", 0)
+	s.mustFail(RoleCompliance, "a", "addSanctionsEntry", "SDN-1", "Mal", "Lory", SanctionBlock, "OFAC")
+	s.mustInvoke("officer", "addSanctionsEntry", "SDN-1", "Mal", "Lory", SanctionBlock, "OFAC")
+	s.mustInvoke("officer", "addSanctionsEntry", "SDN-2", "Ben", "Bakr", SanctionBlock, "OFAC")
+
+	// An exact match of a BLOCK entry stops the move; a fuzzy one is flagged for review
+	s.mustFail(SanctionsBlocked, "a", "move", "a", "mallory", "10")
+	s.mustInvoke("a", "move", "a", "b", "10")
+	s.expectBalance("b", 1010)
+	s.mustFail(RoleCompliance, "a", "queryScreeningFlags")
+	var flags []ScreeningFlag
+	if err := json.Unmarshal(s.mustInvoke("officer", "queryScreeningFlags"), &flags); err != nil {
+		t.Fatal(err)
+	}
+	if len(flags) != 1 || flags[0].UserID != "b" || flags[0].EntryID != "SDN-2" || flags[0].MatchType != MatchFuzzy || flags[0].Distance != 1 {
+		t.Fatalf("screening flagged %+v, expected a fuzzy match of b against SDN-2", flags)
+	}
+
+	s.mustInvoke("officer", "removeSanctionsEntry", "SDN-1")
+	s.mustInvoke("a", "move", "a", "mallory", "10")
+	s.expectBalance("mallory", 10)
+}
+
+func TestScreeningBlocksNormalizedExactMatch(t *testing.T) {
+	s := newTestStub(t)
+	s.grant(RoleCompliance, "officer")
+	s.mustInvoke("officer", "addSanctionsEntry", "SDN-1", "Jean-Luc", "Picard", SanctionBlock, "OFAC")
+	s.register("jl", "Jean Luc", "PICARD", "")
+	s.fund("jl", 0)
+
+	s.mustFail(SanctionsBlocked, "a", "move", "a", "jl", "10")
+	s.expectBalance("jl", 0)
+}
+
+func TestScreeningChecksAccountsWithoutProfile(t *testing.T) {
+	s := newTestStub(t)
+	s.grant(RoleCompliance, "officer")
+	s.mustInvoke("officer", "addSanctionsEntry", "SDN-1", "Mallory", "Evil", SanctionBlock, "OFAC")
+	s.fund("mallory.evil", 0)
+
+	s.mustFail(SanctionsBlocked, "a", "move", "a", "mallory.evil", "10")
+	s.expectBalance("mallory.evil", 0)
+}
+
+func TestCreatePaymentScreensBillRecipient(t *testing.T) {
+	s := newTestStub(t)
+	s.grant(RoleCompliance, "officer")
+	s.biller("acme")
+	s.register("alice", "Alice", "Able", KYCTier1)
+	s.issueBill("b1", "acme", "alice", 100, "2017-11-30")
+	s.mustInvoke("officer", "addSanctionsEntry", "SDN-1", "Ac", "Me", SanctionBlock, "OFAC")
+
+	s.mustFail(SanctionsBlocked, "alice", "createPayment", "p1", "alice", "", "", "PROCESSED", "1", "0", "1", "100", "100", "USD", "USD", "", "2017-11-01T10:00:00Z", "2017-11-01T10:00:00Z", `[{"billid":"b1","amount":100}]`)
+	s.mustFail(SanctionsBlocked, "alice", "createPayment", "p1", "alice", "", "", "PROCESSED", "1", "0", "1", "100", "100", "USD", "USD", "", "2017-11-01T10:00:00Z", "2017-11-01T10:00:00Z")
+	if bill := s.bill("b1"); bill.PaidAmount != 0 {
+		t.Fatalf("bill b1 has %d paid, expected nothing", bill.PaidAmount)
+	}
+}
+
+func TestClaimAndCaptureScreenParties(t *testing.T) {
+	s := newTestStub(t)
+	s.grant(RoleCompliance, "officer")
+	s.register("mallory", "Mal", "Lory", "")
+	s.fund("mallory", 0)
+	secret := []byte("open sesame")
+	digest := sha256.Sum256(secret)
+	s.mustInvoke("a", "lockHTLC", "x1", "a", "mallory", "100", hex.EncodeToString(digest[:]), "2017-11-20T00:00:00Z")
+	s.mustInvoke("a", "authorize", "h1", "a", "mallory", "100", "2017-11-20T00:00:00Z")
+
+	// Listed after the funds were locked or held
+	s.mustInvoke("officer", "addSanctionsEntry", "SDN-1", "Mal", "Lory", SanctionBlock, "OFAC")
+	s.mustFail(SanctionsBlocked, "mallory", "claimHTLC", "x1", hex.EncodeToString(secret))
+	s.mustFail(SanctionsBlocked, "mallory", "capture", "h1", "100")
+	s.expectBalance("mallory", 0)
+}
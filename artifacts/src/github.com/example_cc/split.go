@@ -0,0 +1,228 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// BillSplit is the status of a parent bill while its child obligations are being paid
+const BillSplit = "SPLIT"
+
+// BillShare is one payer's part of a split bill, given either as a Rate in
+// basis points of the outstanding amount or as a fixed Amount
+type BillShare struct {
+	UserID  string `json:"userid"`
+	Rate    int    `json:"rate"`
+	Amount  int    `json:"amount"`
+	ChildID string `json:"childid"`
+}
+
+// splitAmounts works out each share's amount of the outstanding total. Rate
+// shares must add up to 10000 basis points, with the rounding remainder
+// going to the first share; fixed shares must add up to the total.
+func splitAmounts(shares []BillShare, total int) error {
+	if len(shares) < 2 {
+		return fmt.Errorf("A split needs at least 2 shares")
+	}
+	byRate := shares[0].Rate > 0
+	sumRates, sumAmounts := 0, 0
+	seen := map[string]bool{}
+	for i := range shares {
+		share := &shares[i]
+		if share.UserID == "" || seen[share.UserID] {
+			return fmt.Errorf("Share %d: a distinct userid is required", i)
+		}
+		seen[share.UserID] = true
+		if byRate != (share.Rate > 0) || (byRate && share.Amount != 0) || (!byRate && share.Amount <= 0) {
+			return fmt.Errorf("Share %d: shares are given either all by rate or all by a positive amount", i)
+		}
+		if byRate {
+			share.Amount = total * share.Rate / 10000
+			sumRates += share.Rate
+		}
+		sumAmounts += share.Amount
+	}
+	if byRate {
+		if sumRates != 10000 {
+			return fmt.Errorf("Share rates add up to %d basis points, expecting 10000", sumRates)
+		}
+		shares[0].Amount += total - sumAmounts
+		sumAmounts = total
+	}
+	if sumAmounts != total {
+		return fmt.Errorf("Share amounts add up to %d, expecting the outstanding %d", sumAmounts, total)
+	}
+	for i, share := range shares {
+		if share.Amount <= 0 {
+			return fmt.Errorf("Share %d of %s comes to nothing", i, share.UserID)
+		}
+	}
+	return nil
+}
+
+// refreshSplitParent brings a split bill's paid and outstanding amounts up to
+// date with its children. A cancelled or credited share is closed without
+// being paid and no longer counts: the parent is marked paid once every
+// other share is paid, and cancelled when no share is left.
+func refreshSplitParent(stub shim.ChaincodeStubInterface, parentID string) error {
+	parent, err := getBill(stub, parentID)
+	if err != nil {
+		return err
+	}
+	paid, outstanding, allPaid, allClosed := 0, 0, true, true
+	for _, share := range parent.Shares {
+		child, err := getBill(stub, share.ChildID)
+		if err != nil {
+			return err
+		}
+		paid += child.PaidAmount
+		if child.isOpen() {
+			childOutstanding, _ := child.outstanding()
+			outstanding += childOutstanding
+		}
+		if child.Status == BillCancelled || child.Status == BillCredited {
+			continue
+		}
+		allClosed = false
+		if child.Status != BillPaid {
+			allPaid = false
+		}
+	}
+	parent.PaidAmount = paid
+	parent.Outstanding = outstanding
+	if allPaid && parent.Status == BillSplit {
+		now, err := txTime(stub)
+		if err != nil {
+			return err
+		}
+		if allClosed {
+			parent.Status = BillCancelled
+		} else {
+			parent.Status = BillPaid
+			parent.PaidAt = now.Format(time.RFC3339)
+		}
+	}
+	return putBill(stub, parent)
+}
+
+// ==== splitBill =========================================
+// splitBill divides an unpaid bill among several payers. Each share becomes
+// a child bill with ID "<id>-<userid>", indexed under its own user; the
+// parent is no longer payable itself and moves to PAID once every child is
+// paid. Only the bill's recipient may split it, as the share holders' auto-pay
+// settings and mandates pay their shares without asking them.
+// 0       1
+// "id"    '[{"userid":"u1","rate":6000},{"userid":"u2","rate":4000}]'
+// ===========================================================================================
+func (t *SimpleChaincode) splitBill(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+
+	submitter, err := getParty(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	bill, err := getBill(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if bill.RecipientID != submitter.ID {
+		return shim.Error(fmt.Sprintf("Only recipient %s can split bill %s", bill.RecipientID, bill.ID))
+	}
+	if !bill.isOpen() || bill.PaidAmount > 0 {
+		return shim.Error(fmt.Sprintf("Bill %s can no longer be split, it is %s", bill.ID, bill.Status))
+	}
+	if bill.ParentID != "" {
+		return shim.Error(fmt.Sprintf("Bill %s is already a share of bill %s", bill.ID, bill.ParentID))
+	}
+	if bill.DisputeID != "" {
+		return shim.Error(fmt.Sprintf("Bill %s is under dispute %s", bill.ID, bill.DisputeID))
+	}
+	if _, found, err := getInstallmentPlan(stub, bill.ID); err != nil || found {
+		return shim.Error("Bill has an installment plan and cannot be split: " + bill.ID)
+	}
+	var shares []BillShare
+	if err := json.Unmarshal([]byte(args[1]), &shares); err != nil {
+		return shim.Error("Invalid shares, expecting a JSON list of {userid, rate} or {userid, amount}")
+	}
+	for _, share := range shares {
+		if _, err := getUserProfile(stub, share.UserID); err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+	outstanding, err := bill.outstanding()
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := splitAmounts(shares, outstanding); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	for i := range shares {
+		share := &shares[i]
+		share.ChildID = bill.ID + "-" + share.UserID
+		if existing, err := stub.GetState(billPrefix + share.ChildID); err != nil || existing != nil {
+			return shim.Error("Bill already exists: " + share.ChildID)
+		}
+		child := Bill{ID: share.ChildID, BillID: bill.BillID, RecipientID: bill.RecipientID, UserID: share.UserID, BillDate: bill.BillDate, BillDueDate: bill.BillDueDate, CreatedAt: bill.CreatedAt, Description: fmt.Sprintf("Share of bill %s: %s", bill.ID, bill.Description), Amount: strconv.Itoa(share.Amount), Currency: bill.Currency, Timestamp: bill.Timestamp, Status: BillIssued, Version: 1, Terms: bill.Terms, ParentID: bill.ID}
+		child.EffectiveAmount = share.Amount
+		child.Outstanding = share.Amount
+		if err := addBill(stub, child); err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	bill.Status = BillSplit
+	bill.Shares = shares
+	if err := putBill(stub, bill); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	billAsBytes, _ := json.Marshal(bill)
+	return shim.Success(billAsBytes)
+}
+
+// ==== querySplitBill =========================================
+// querySplitBill returns a split bill together with its child obligations.
+// 0
+// "id"
+// ===========================================================================================
+func (t *SimpleChaincode) querySplitBill(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	bill, err := getBill(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if bill.ParentID != "" {
+		if bill, err = getBill(stub, bill.ParentID); err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+	if len(bill.Shares) == 0 {
+		return shim.Error("Bill is not split: " + bill.ID)
+	}
+
+	children := []Bill{}
+	for _, share := range bill.Shares {
+		child, err := getBill(stub, share.ChildID)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		children = append(children, child)
+	}
+
+	respAsBytes, _ := json.Marshal(struct {
+		Bill     Bill   `json:"bill"`
+		Children []Bill `json:"children"`
+	}{bill, children})
+	return shim.Success(respAsBytes)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func splitSetup(t *testing.T) *testStub {
+	s := newTestStub(t)
+	s.biller("acme")
+	s.register("alice", "Alice", "Able", KYCTier1)
+	s.register("bob", "Bob", "Baker", KYCTier1)
+	s.fund("alice", 1000)
+	s.fund("bob", 1000)
+	s.issueBill("b1", "acme", "alice", 200, "2017-11-30")
+	return s
+}
+
+func TestSplitBillIntoShares(t *testing.T) {
+	s := splitSetup(t)
+	s.mustFail("Share rates add up to 9000", "acme", "splitBill", "b1", `[{"userid":"alice","rate":5000},{"userid":"bob","rate":4000}]`)
+	s.mustFail("either all by rate or all by a positive amount", "acme", "splitBill", "b1", `[{"userid":"alice","rate":5000},{"userid":"bob","amount":100}]`)
+
+	// The rounding remainder goes to the first share
+	s.mustInvoke("acme", "splitBill", "b1", `[{"userid":"alice","rate":3333},{"userid":"bob","rate":6667}]`)
+	if alice, bob := s.bill("b1-alice"), s.bill("b1-bob"); alice.Outstanding != 67 || bob.Outstanding != 133 || bob.ParentID != "b1" {
+		t.Fatalf("shares are %d and %d, expected 67 and 133", alice.Outstanding, bob.Outstanding)
+	}
+	s.mustFail("Bill b1 can no longer be split", "acme", "splitBill", "b1", `[{"userid":"alice","rate":5000},{"userid":"bob","rate":5000}]`)
+
+	s.mustPay("p1", "alice", 67, `[{"billid":"b1-alice","amount":67}]`)
+	s.mustPay("p2", "bob", 133, `[{"billid":"b1-bob","amount":133}]`)
+	if bill := s.bill("b1"); bill.Status != BillPaid || bill.PaidAmount != 200 || bill.Outstanding != 0 {
+		t.Fatalf("parent is %s with %d paid and %d outstanding, expected %s with 200 paid", bill.Status, bill.PaidAmount, bill.Outstanding, BillPaid)
+	}
+}
+
+func TestOnlyRecipientSplitsBills(t *testing.T) {
+	s := splitSetup(t)
+
+	// The user could otherwise make bob's auto-pay and mandates pay its bill
+	s.mustFail("Only recipient acme can split", "alice", "splitBill", "b1", `[{"userid":"alice","rate":5000},{"userid":"bob","rate":5000}]`)
+	s.mustInvoke("acme", "splitBill", "b1", `[{"userid":"alice","rate":6000},{"userid":"bob","rate":4000}]`)
+	if alice, bob := s.bill("b1-alice"), s.bill("b1-bob"); alice.Outstanding != 120 || bob.Outstanding != 80 {
+		t.Fatalf("shares are %d and %d, expected 120 and 80", alice.Outstanding, bob.Outstanding)
+	}
+	if bill := s.bill("b1"); bill.Status != BillSplit {
+		t.Fatalf("parent is %s, expected %s", bill.Status, BillSplit)
+	}
+}
+
+func TestSplitParentIgnoresCancelledShares(t *testing.T) {
+	s := splitSetup(t)
+	s.mustInvoke("acme", "splitBill", "b1", `[{"userid":"alice","rate":5000},{"userid":"bob","rate":5000}]`)
+
+	evidence := strings.Repeat("ab", 32)
+	s.mustInvoke("bob", "openDispute", "d1", "b1-bob", "DUPLICATE", evidence, "")
+	s.mustInvoke("acme", "resolveDispute", "d1", DisputeCancel, "", "AGREED", evidence, "")
+	if bill := s.bill("b1-bob"); bill.Status != BillCancelled {
+		t.Fatalf("share of bob is %s, expected %s", bill.Status, BillCancelled)
+	}
+	if bill := s.bill("b1"); bill.Status != BillSplit {
+		t.Fatalf("parent is %s with a share still open, expected %s", bill.Status, BillSplit)
+	}
+
+	s.mustPay("p1", "alice", 100, `[{"billid":"b1-alice","amount":100}]`)
+	if bill := s.bill("b1"); bill.Status != BillPaid || bill.PaidAmount != 100 {
+		t.Fatalf("parent is %s with %d paid, expected %s with 100", bill.Status, bill.PaidAmount, BillPaid)
+	}
+}
+
+func TestSplitParentCancelledWithEveryShare(t *testing.T) {
+	s := splitSetup(t)
+	s.mustInvoke("acme", "splitBill", "b1", `[{"userid":"alice","rate":5000},{"userid":"bob","rate":5000}]`)
+
+	evidence := strings.Repeat("ab", 32)
+	for _, user := range []string{"alice", "bob"} {
+		s.mustInvoke(user, "openDispute", "d-"+user, "b1-"+user, "DUPLICATE", evidence, "")
+		s.mustInvoke("acme", "resolveDispute", "d-"+user, DisputeCancel, "", "AGREED", evidence, "")
+	}
+	if bill := s.bill("b1"); bill.Status != BillCancelled {
+		t.Fatalf("parent is %s with every share cancelled, expected %s", bill.Status, BillCancelled)
+	}
+}
@@ -0,0 +1,359 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+var standingOrderPrefix = "STANDINGORDER"         //prefix for the key/value that stores a standing order
+var standingOrderIndexName = "standingorder~id"   //composite index of every standing order, walked by processDue
+var standingOrderRunIndexName = "orderid~rundate" //composite key holding the outcome of each run
+
+// Standing order frequencies
+const (
+	FrequencyDaily   = "DAILY"
+	FrequencyWeekly  = "WEEKLY"
+	FrequencyMonthly = "MONTHLY"
+)
+
+// Standing order and run statuses
+const (
+	OrderActive    = "ACTIVE"
+	OrderCompleted = "COMPLETED"
+	OrderCancelled = "CANCELLED"
+	RunSucceeded   = "SUCCEEDED"
+	RunFailed      = "FAILED"
+)
+
+var dateLayout = "2006-01-02"
+
+// maxCatchUpRuns bounds the missed runs of one order that a single processDue
+// catches up; any further runs are left for the next sweep
+const maxCatchUpRuns = 31
+
+// StandingOrder repeatedly moves a fixed amount from Source to Destination.
+// Runs fall on StartDate and every period after it; the order ends after
+// EndDate or MaxRuns runs, whichever comes first (empty / 0 means no limit).
+type StandingOrder struct {
+	ID          string `json:"id"`
+	Source      string `json:"source"`
+	Destination string `json:"destination"`
+	Amount      int    `json:"amount"`
+	Frequency   string `json:"frequency"`
+	StartDate   string `json:"startdate"`
+	EndDate     string `json:"enddate"`
+	MaxRuns     int    `json:"maxruns"`
+	RunCount    int    `json:"runcount"`
+	NextRun     string `json:"nextrun"`
+	Status      string `json:"status"`
+	CreatedAt   string `json:"created_at"`
+}
+
+// StandingOrderRun records the outcome of one scheduled run
+type StandingOrderRun struct {
+	OrderID   string `json:"orderid"`
+	RunDate   string `json:"rundate"`
+	Amount    int    `json:"amount"`
+	Status    string `json:"status"`
+	Error     string `json:"error"`
+	TxID      string `json:"txid"`
+	Timestamp string `json:"tr_time"`
+}
+
+// occurrence returns the n-th run date (0-based) of a schedule. Monthly
+// schedules keep the start day, clamped to the last day of shorter months.
+func occurrence(start time.Time, frequency string, n int) time.Time {
+	switch frequency {
+	case FrequencyDaily:
+		return start.AddDate(0, 0, n)
+	case FrequencyWeekly:
+		return start.AddDate(0, 0, 7*n)
+	}
+	firstOfMonth := time.Date(start.Year(), start.Month()+time.Month(n), 1, 0, 0, 0, 0, time.UTC)
+	lastDay := firstOfMonth.AddDate(0, 1, -1).Day()
+	day := start.Day()
+	if day > lastDay {
+		day = lastDay
+	}
+	return firstOfMonth.AddDate(0, 0, day-1)
+}
+
+// advance moves the order on to its next run, completing it when the end condition is reached
+func (o *StandingOrder) advance() {
+	o.RunCount++
+	start, _ := time.Parse(dateLayout, o.StartDate)
+	next := occurrence(start, o.Frequency, o.RunCount)
+	o.NextRun = next.Format(dateLayout)
+	if o.MaxRuns > 0 && o.RunCount >= o.MaxRuns {
+		o.Status = OrderCompleted
+	}
+	if o.EndDate != "" {
+		end, _ := time.Parse(dateLayout, o.EndDate)
+		if next.After(end) {
+			o.Status = OrderCompleted
+		}
+	}
+}
+
+func getStandingOrder(stub shim.ChaincodeStubInterface, id string) (StandingOrder, error) {
+	var order StandingOrder
+	orderAsBytes, err := stub.GetState(standingOrderPrefix + id)
+	if err != nil {
+		return order, fmt.Errorf("Failed to get standing order %s", id)
+	}
+	if orderAsBytes == nil {
+		return order, fmt.Errorf("Standing order not found: %s", id)
+	}
+	err = json.Unmarshal(orderAsBytes, &order)
+	return order, err
+}
+
+func putStandingOrder(stub shim.ChaincodeStubInterface, order StandingOrder) error {
+	orderAsBytes, _ := json.Marshal(order)
+	return stub.PutState(standingOrderPrefix+order.ID, orderAsBytes)
+}
+
+// ==== createStandingOrder =========================================
+// createStandingOrder schedules payments out of the submitter's own account,
+// starting no earlier than the transaction date.
+// 0          1         2              3         4          5             6             7
+// "orderid"  "source"  "destination"  "amount"  "MONTHLY"  "2017-12-01"  "2018-11-30"  "0"
+// enddate may be empty and maxruns may be 0 for an open-ended order
+// ===========================================================================================
+func (t *SimpleChaincode) createStandingOrder(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 8 {
+		return shim.Error("Incorrect number of arguments. Expecting 8")
+	}
+
+	id, source, destination := args[0], args[1], args[2]
+	amount, err := strconv.Atoi(args[3])
+	if err != nil || amount <= 0 {
+		return shim.Error("Invalid standing order amount, expecting a positive integer value")
+	}
+	frequency := args[4]
+	if frequency != FrequencyDaily && frequency != FrequencyWeekly && frequency != FrequencyMonthly {
+		return shim.Error(fmt.Sprintf("Frequency must be one of '%s', '%s' or '%s'. But got: %v", FrequencyDaily, FrequencyWeekly, FrequencyMonthly, frequency))
+	}
+	start, err := time.Parse(dateLayout, args[5])
+	if err != nil {
+		return shim.Error("Invalid start date, expecting YYYY-MM-DD")
+	}
+	if args[6] != "" {
+		end, err := time.Parse(dateLayout, args[6])
+		if err != nil {
+			return shim.Error("Invalid end date, expecting YYYY-MM-DD")
+		}
+		if end.Before(start) {
+			return shim.Error("End date must not be before the start date")
+		}
+	}
+	maxRuns, err := strconv.Atoi(args[7])
+	if err != nil || maxRuns < 0 {
+		return shim.Error("Invalid maximum number of runs, expecting a non-negative integer value")
+	}
+	if source == destination {
+		return shim.Error("Source and destination must differ")
+	}
+	submitter, err := getParty(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if submitter.ID != source {
+		return shim.Error(fmt.Sprintf("Only %s can set up standing orders from its account", source))
+	}
+
+	existing, err := stub.GetState(standingOrderPrefix + id)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if existing != nil {
+		return shim.Error("Standing order already exists: " + id)
+	}
+	if _, err := getBalance(stub, source); err != nil {
+		return shim.Error(err.Error())
+	}
+	if _, err := getBalance(stub, destination); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	now, err := txTime(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if args[5] < now.Format(dateLayout) {
+		return shim.Error("Start date must not be before the transaction date")
+	}
+	order := StandingOrder{ID: id, Source: source, Destination: destination, Amount: amount, Frequency: frequency, StartDate: args[5], EndDate: args[6], MaxRuns: maxRuns, NextRun: args[5], Status: OrderActive, CreatedAt: now.Format(time.RFC3339)}
+	if err := putStandingOrder(stub, order); err != nil {
+		return shim.Error(err.Error())
+	}
+	orderIndexKey, err := stub.CreateCompositeKey(standingOrderIndexName, []string{id})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	stub.PutState(orderIndexKey, []byte{0x00})
+
+	orderAsBytes, _ := json.Marshal(order)
+	return shim.Success(orderAsBytes)
+}
+
+// ==== cancelStandingOrder =========================================
+// Only the owner of the source account may cancel its standing orders.
+// 0
+// "orderid"
+// ===========================================================================================
+func (t *SimpleChaincode) cancelStandingOrder(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	order, err := getStandingOrder(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if order.Status != OrderActive {
+		return shim.Error(fmt.Sprintf("Standing order %s is already %s", order.ID, order.Status))
+	}
+	submitter, err := getParty(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if submitter.ID != order.Source {
+		return shim.Error(fmt.Sprintf("Only %s can cancel standing order %s", order.Source, order.ID))
+	}
+	order.Status = OrderCancelled
+	if err := putStandingOrder(stub, order); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	orderAsBytes, _ := json.Marshal(order)
+	return shim.Success(orderAsBytes)
+}
+
+// ==== queryStandingOrder =========================================
+// queryStandingOrder returns the order together with the outcome of every run so far.
+// 0
+// "orderid"
+// ===========================================================================================
+func (t *SimpleChaincode) queryStandingOrder(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	order, err := getStandingOrder(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(standingOrderRunIndexName, []string{order.ID})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	runs := []StandingOrderRun{}
+	for resultsIterator.HasNext() {
+		responseRange, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		var run StandingOrderRun
+		json.Unmarshal(responseRange.Value, &run)
+		runs = append(runs, run)
+	}
+
+	respAsBytes, _ := json.Marshal(struct {
+		Order StandingOrder      `json:"order"`
+		Runs  []StandingOrderRun `json:"runs"`
+	}{order, runs})
+	return shim.Success(respAsBytes)
+}
+
+// ==== processDue =========================================
+// processDue runs every active standing order whose next run date is on or
+// before the transaction date. Orders are visited in ID order and missed
+// runs are caught up one by one, at most maxCatchUpRuns per order, so every
+// endorser computes the same result. A run that cannot be funded is recorded
+// as FAILED and the order moves on.
+// ===========================================================================================
+func (t *SimpleChaincode) processDue(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	logger.Info("########### processDue ###########")
+	if len(args) != 0 {
+		return shim.Error("Incorrect number of arguments. Expecting 0")
+	}
+
+	now, err := txTime(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	today := now.Format(dateLayout)
+
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(standingOrderIndexName, []string{})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	var orderIDs []string
+	for resultsIterator.HasNext() {
+		responseRange, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		_, compositeKeyParts, err := stub.SplitCompositeKey(responseRange.Key)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		orderIDs = append(orderIDs, compositeKeyParts[0])
+	}
+
+	runs := []StandingOrderRun{}
+	var legs []TransferLeg
+	for _, id := range orderIDs {
+		order, err := getStandingOrder(stub, id)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if order.Status != OrderActive || order.NextRun > today {
+			continue
+		}
+		for caughtUp := 0; order.Status == OrderActive && order.NextRun <= today && caughtUp < maxCatchUpRuns; caughtUp++ {
+			run := StandingOrderRun{OrderID: order.ID, RunDate: order.NextRun, Amount: order.Amount, Status: RunSucceeded, TxID: stub.GetTxID(), Timestamp: now.Format(time.RFC3339)}
+			if err := transfer(stub, "processDue", order.Source, order.Destination, order.Amount); err != nil {
+				run.Status = RunFailed
+				run.Error = err.Error()
+			} else {
+				legs = append(legs, TransferLeg{From: order.Source, To: order.Destination, Amount: order.Amount})
+			}
+
+			runKey, err := stub.CreateCompositeKey(standingOrderRunIndexName, []string{order.ID, run.RunDate})
+			if err != nil {
+				return shim.Error(err.Error())
+			}
+			runAsBytes, _ := json.Marshal(run)
+			if err := stub.PutState(runKey, runAsBytes); err != nil {
+				return shim.Error(err.Error())
+			}
+			runs = append(runs, run)
+			order.advance()
+		}
+		if err := putStandingOrder(stub, order); err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	if len(legs) > 0 {
+		if _, err := writeJournal(stub, "processDue", legs); err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+	logger.Infof("processDue ran %d standing order runs\n", len(runs))
+
+	runsAsBytes, _ := json.Marshal(runs)
+	return shim.Success(runsAsBytes)
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMonthlyRunsKeepTheStartDay(t *testing.T) {
+	start := time.Date(2018, 1, 31, 0, 0, 0, 0, time.UTC)
+	for n, want := range []string{"2018-01-31", "2018-02-28", "2018-03-31", "2018-04-30"} {
+		if got := occurrence(start, FrequencyMonthly, n).Format(dateLayout); got != want {
+			t.Errorf("run %d falls on %s, expected %s", n, got, want)
+		}
+	}
+}
+
+func TestProcessDueRunsDueOrders(t *testing.T) {
+	s := newTestStub(t)
+	s.register("c", "Cy", "Cole", "")
+	s.fund("c", 50)
+	s.mustInvoke("a", "createStandingOrder", "so1", "a", "b", "100", FrequencyMonthly, "2017-11-01", "", "2")
+	s.mustInvoke("c", "createStandingOrder", "so2", "c", "b", "100", FrequencyMonthly, "2017-11-01", "", "0")
+	s.mustInvoke("a", "createStandingOrder", "so3", "a", "b", "100", FrequencyMonthly, "2017-11-15", "", "0")
+
+	var runs []StandingOrderRun
+	if err := json.Unmarshal(s.mustInvoke("keeper", "processDue"), &runs); err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != 2 || runs[0].Status != RunSucceeded || runs[1].Status != RunFailed {
+		t.Fatalf("processDue ran %+v, expected so1 to succeed and so2 to fail", runs)
+	}
+	s.expectBalance("a", 900)
+	s.expectBalance("b", 1100)
+	s.expectBalance("c", 50)
+
+	// A month on, so1 makes its last run and so3 its first
+	s.now = s.now.AddDate(0, 1, 0)
+	s.fund("c", 100)
+	if err := json.Unmarshal(s.mustInvoke("keeper", "processDue"), &runs); err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != 3 {
+		t.Fatalf("processDue ran %+v, expected three runs", runs)
+	}
+	s.expectBalance("a", 700)
+	s.expectBalance("b", 1400)
+	var order StandingOrder
+	s.get(standingOrderPrefix+"so1", &order)
+	if order.Status != OrderCompleted || order.RunCount != 2 {
+		t.Fatalf("so1 is %s after %d runs, expected %s after 2", order.Status, order.RunCount, OrderCompleted)
+	}
+
+	s.mustInvoke("a", "cancelStandingOrder", "so3")
+	s.now = s.now.AddDate(0, 1, 0)
+	s.mustInvoke("keeper", "processDue")
+	s.expectBalance("a", 700)
+}
+
+func TestStandingOrderRequiresOwner(t *testing.T) {
+	s := newTestStub(t)
+	s.register("mallory", "Mal", "Lory", "")
+	s.fund("mallory", 0)
+
+	s.mustFail("Only a can set up standing orders", "mallory", "createStandingOrder", "so1", "a", "mallory", "100", FrequencyDaily, "2017-11-01", "", "0")
+	s.mustFail("Start date must not be before", "a", "createStandingOrder", "so1", "a", "b", "100", FrequencyDaily, "2017-10-31", "", "0")
+	s.mustInvoke("a", "createStandingOrder", "so1", "a", "b", "100", FrequencyMonthly, "2017-11-01", "", "0")
+	s.mustFail("Only a can cancel", "mallory", "cancelStandingOrder", "so1")
+	s.mustInvoke("a", "cancelStandingOrder", "so1")
+}
+
+func TestProcessDueReadsOwnWrites(t *testing.T) {
+	s := newTestStub(t)
+	s.fund("c", 0)
+	s.mustInvoke("a", "createStandingOrder", "so1", "a", "b", "100", FrequencyMonthly, "2017-11-01", "", "0")
+	s.mustInvoke("a", "createStandingOrder", "so2", "a", "c", "200", FrequencyMonthly, "2017-11-01", "", "0")
+
+	// Both orders debit a in one transaction; the second must see the first
+	s.mustInvoke("keeper", "processDue")
+	s.expectBalance("a", 700)
+	s.expectBalance("b", 1100)
+	s.expectBalance("c", 200)
+}
+
+func TestProcessDueCatchUpIsBounded(t *testing.T) {
+	s := newTestStub(t)
+	s.mustInvoke("a", "createStandingOrder", "so1", "a", "b", "10", FrequencyDaily, "2017-11-01", "", "0")
+
+	// 41 runs are due, from 2017-11-01 through 2017-12-11
+	s.now = s.now.AddDate(0, 0, 40)
+	var runs []StandingOrderRun
+	if err := json.Unmarshal(s.mustInvoke("keeper", "processDue"), &runs); err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != maxCatchUpRuns {
+		t.Fatalf("first sweep ran %d runs, expected %d", len(runs), maxCatchUpRuns)
+	}
+	if err := json.Unmarshal(s.mustInvoke("keeper", "processDue"), &runs); err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != 41-maxCatchUpRuns {
+		t.Fatalf("second sweep ran %d runs, expected %d", len(runs), 41-maxCatchUpRuns)
+	}
+	s.expectBalance("a", 590)
+}
+
+func TestProcessDueAppliesLimits(t *testing.T) {
+	s := newTestStub(t)
+	s.register("alice", "Alice", "Able", KYCTier0)
+	s.fund("alice", 1000)
+	s.mustInvoke("alice", "createStandingOrder", "so1", "alice", "b", "150", FrequencyMonthly, "2017-11-01", "", "0")
+	s.mustInvoke("alice", "createStandingOrder", "so2", "alice", "b", "100", FrequencyMonthly, "2017-11-01", "", "0")
+
+	// so1 is over the TIER0 per transaction limit and fails; so2 is paid
+	var runs []StandingOrderRun
+	if err := json.Unmarshal(s.mustInvoke("keeper", "processDue"), &runs); err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != 2 || runs[0].Status != RunFailed || !strings.Contains(runs[0].Error, LimitExceeded) || runs[1].Status != RunSucceeded {
+		t.Fatalf("processDue ran %v, expected so1 to fail on its limit and so2 to succeed", runs)
+	}
+	s.expectBalance("alice", 900)
+}
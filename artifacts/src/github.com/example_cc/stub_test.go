@@ -0,0 +1,266 @@
+package main
+
+import (
+	"container/list"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	mspprotos "github.com/hyperledger/fabric/protos/msp"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+const testMSPID = "Org1MSP"
+
+// testStub runs the chaincode on a shim.MockStub. The mock has no creator
+// or transaction time, and keeps the writes of failed transactions, so
+// testStub supplies the first two and rolls back the writes of any
+// invocation that returns an error, as a peer would.
+type testStub struct {
+	*shim.MockStub
+	t       *testing.T
+	cc      *SimpleChaincode
+	creator []byte
+	args    []string
+	now     time.Time
+	events  map[string][]byte
+	txs     int
+}
+
+var testIdentities = map[string][]byte{}
+
+// identity serializes an identity of the test MSP whose certificate names the enrollment ID
+func identity(t *testing.T, id string) []byte {
+	return mspIdentity(t, testMSPID, id)
+}
+
+// mspIdentity serializes an identity of the given MSP whose certificate names the enrollment ID
+func mspIdentity(t *testing.T, msp, id string) []byte {
+	if creator, ok := testIdentities[msp+"/"+id]; ok {
+		return creator
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := x509.Certificate{SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: id}, NotBefore: time.Now().Add(-time.Hour), NotAfter: time.Now().Add(time.Hour)}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	creator, err := proto.Marshal(&mspprotos.SerializedIdentity{Mspid: msp, IdBytes: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})})
+	if err != nil {
+		t.Fatal(err)
+	}
+	testIdentities[msp+"/"+id] = creator
+	return creator
+}
+
+// newTestStub instantiates the chaincode as "admin" with accounts a and b,
+// whose holders are registered users verified at TIER1
+func newTestStub(t *testing.T) *testStub {
+	cc := new(SimpleChaincode)
+	s := &testStub{MockStub: shim.NewMockStub("example_cc", cc), t: t, cc: cc, now: time.Date(2017, 11, 1, 10, 0, 0, 0, time.UTC)}
+	s.creator = identity(t, "admin")
+	s.args = []string{"init", "a", "1000", "b", "1000"}
+	s.MockTransactionStart("init")
+	if resp := cc.Init(s); resp.Status != shim.OK {
+		t.Fatalf("Init failed: %s", resp.Message)
+	}
+	s.MockTransactionEnd("init")
+	s.register("a", "Ann", "Able", KYCTier1)
+	s.register("b", "Ben", "Baker", KYCTier1)
+	return s
+}
+
+func (s *testStub) GetCreator() ([]byte, error) { return s.creator, nil }
+
+func (s *testStub) GetTxTimestamp() (*timestamp.Timestamp, error) {
+	return &timestamp.Timestamp{Seconds: s.now.Unix(), Nanos: int32(s.now.Nanosecond())}, nil
+}
+
+func (s *testStub) GetArgs() [][]byte {
+	args := make([][]byte, len(s.args))
+	for i, arg := range s.args {
+		args[i] = []byte(arg)
+	}
+	return args
+}
+
+func (s *testStub) GetStringArgs() []string { return s.args }
+
+func (s *testStub) GetFunctionAndParameters() (string, []string) { return s.args[0], s.args[1:] }
+
+func (s *testStub) SetEvent(name string, payload []byte) error {
+	s.events[name] = payload
+	return nil
+}
+
+// invoke submits one transaction as the given enrollment ID of the test MSP
+func (s *testStub) invoke(id, function string, args ...string) pb.Response {
+	return s.invokeFrom(testMSPID, id, function, args...)
+}
+
+// invokeFrom submits one transaction as the given enrollment ID of an MSP
+func (s *testStub) invokeFrom(msp, id, function string, args ...string) pb.Response {
+	s.txs++
+	txID := fmt.Sprintf("tx%04d", s.txs)
+	s.creator = mspIdentity(s.t, msp, id)
+	s.args = append([]string{function}, args...)
+	s.events = map[string][]byte{}
+
+	saved := map[string][]byte{}
+	for key, value := range s.State {
+		saved[key] = value
+	}
+	s.MockTransactionStart(txID)
+	resp := s.cc.Invoke(s)
+	s.MockTransactionEnd(txID)
+	if resp.Status != shim.OK {
+		s.State = saved
+		keys := make([]string, 0, len(saved))
+		for key := range saved {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		s.Keys = list.New()
+		for _, key := range keys {
+			s.Keys.PushBack(key)
+		}
+	}
+	return resp
+}
+
+// mustInvoke submits a transaction that must succeed and returns its payload
+func (s *testStub) mustInvoke(id, function string, args ...string) []byte {
+	resp := s.invoke(id, function, args...)
+	if resp.Status != shim.OK {
+		s.t.Fatalf("%s %v by %s failed: %s", function, args, id, resp.Message)
+	}
+	return resp.Payload
+}
+
+// mustFail submits a transaction that must fail with a message containing want
+func (s *testStub) mustFail(want, id, function string, args ...string) {
+	s.mustFailFrom(want, testMSPID, id, function, args...)
+}
+
+// mustFailFrom is mustFail for an enrollment ID of another MSP
+func (s *testStub) mustFailFrom(want, msp, id, function string, args ...string) {
+	resp := s.invokeFrom(msp, id, function, args...)
+	if resp.Status == shim.OK {
+		s.t.Fatalf("%s %v by %s succeeded, expected an error containing %q", function, args, id, want)
+	}
+	if !strings.Contains(resp.Message, want) {
+		s.t.Fatalf("%s %v by %s failed with %q, expected %q", function, args, id, resp.Message, want)
+	}
+}
+
+// fund creates or overwrites an account holding
+func (s *testStub) fund(account string, amount int) {
+	s.MockTransactionStart("fund")
+	if err := s.PutState(account, []byte(strconv.Itoa(amount))); err != nil {
+		s.t.Fatal(err)
+	}
+	s.MockTransactionEnd("fund")
+}
+
+func (s *testStub) balance(account string) int {
+	val, err := strconv.Atoi(string(s.State[account]))
+	if err != nil {
+		s.t.Fatalf("no holding for %s", account)
+	}
+	return val
+}
+
+func (s *testStub) expectBalance(account string, want int) {
+	if got := s.balance(account); got != want {
+		s.t.Fatalf("balance of %s is %d, expected %d", account, got, want)
+	}
+}
+
+// grant gives an identity of the test MSP a role
+func (s *testStub) grant(role, id string) {
+	s.mustInvoke("admin", "grantRole", role, testMSPID, id)
+}
+
+// register registers a user profile for id, verified at the given tier
+func (s *testStub) register(id, first, last, tier string) {
+	s.mustInvoke(id, "registerUser", first, last, id+"@example.com", "555-0100")
+	if tier == "" {
+		return
+	}
+	if ok, _ := hasRole(s, Submitter{MSPID: testMSPID, ID: "kyc"}, RoleKYCProvider); !ok {
+		s.grant(RoleKYCProvider, "kyc")
+	}
+	s.mustInvoke("kyc", "setKYCStatus", id, KYCVerified)
+	s.mustInvoke("kyc", "setKYCTier", id, tier)
+}
+
+// get unmarshals the JSON state stored under a key
+func (s *testStub) get(key string, v interface{}) {
+	value := s.State[key]
+	if value == nil {
+		s.t.Fatalf("nothing stored under %q", key)
+	}
+	if err := json.Unmarshal(value, v); err != nil {
+		s.t.Fatal(err)
+	}
+}
+
+// biller registers and verifies a USD biller that is paid into its own account
+func (s *testStub) biller(id string) {
+	if _, ok := s.State[id]; !ok {
+		s.fund(id, 0)
+	}
+	s.mustInvoke(id, "registerBiller", id+" Ltd", id, `["USD"]`)
+	s.mustInvoke("admin", "verifyBiller", id, "documents checked")
+}
+
+// issueBill has a biller issue a USD bill to a user
+func (s *testStub) issueBill(id, biller, user string, amount int, due string) {
+	s.mustInvoke(biller, "createBill", id, "INV-"+id, biller, user, "", "", "2017-10-01", due, "2017-10-01T00:00:00Z", "services", strconv.Itoa(amount), "USD", "")
+}
+
+func (s *testStub) bill(id string) Bill {
+	var bill Bill
+	s.get(billPrefix+id, &bill)
+	return bill
+}
+
+func (s *testStub) compositeKey(objectType string, attributes ...string) string {
+	key, err := s.CreateCompositeKey(objectType, attributes)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	return key
+}
+
+// pay records a USD payment by a user, with optional JSON allocations
+func (s *testStub) pay(id, user string, amount int, allocations string) pb.Response {
+	args := []string{id, user, "", "", "PROCESSED", "1", "0", "1", strconv.Itoa(amount), strconv.Itoa(amount), "USD", "USD", "", "2017-11-01T10:00:00Z", "2017-11-01T10:00:00Z"}
+	if allocations != "" {
+		args = append(args, allocations)
+	}
+	return s.invoke(user, "createPayment", args...)
+}
+
+func (s *testStub) mustPay(id, user string, amount int, allocations string) {
+	if resp := s.pay(id, user, amount, allocations); resp.Status != shim.OK {
+		s.t.Fatalf("payment %s by %s failed: %s", id, user, resp.Message)
+	}
+}
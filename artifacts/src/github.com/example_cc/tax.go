@@ -0,0 +1,234 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+var taxRateIndexName = "taxrate~jurisdiction~taxcode" //composite key holding the rate of a tax code in a jurisdiction
+
+// TaxRate is a VAT / GST rate in basis points (1/100 of a percent), e.g. 2000 for 20%
+type TaxRate struct {
+	Jurisdiction string `json:"jurisdiction"`
+	TaxCode      string `json:"taxcode"`
+	Rate         int    `json:"rate"`
+	UpdatedBy    string `json:"updatedby"`
+	UpdatedAt    string `json:"updated_at"`
+}
+
+// TaxLine totals the tax charged on a bill for one tax code
+type TaxLine struct {
+	TaxCode string `json:"taxcode"`
+	Rate    int    `json:"rate"`
+	Taxable int    `json:"taxable"`
+	Tax     int    `json:"tax"`
+}
+
+// taxOn applies a basis point rate to an amount, rounding half up
+func taxOn(amount, rate int) int {
+	return (amount*rate + 5000) / 10000
+}
+
+func getTaxRate(stub shim.ChaincodeStubInterface, jurisdiction, taxCode string) (TaxRate, error) {
+	var rate TaxRate
+	key, err := stub.CreateCompositeKey(taxRateIndexName, []string{jurisdiction, taxCode})
+	if err != nil {
+		return rate, err
+	}
+	rateAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return rate, fmt.Errorf("Failed to get tax rate %s in %s", taxCode, jurisdiction)
+	}
+	if rateAsBytes == nil {
+		return rate, fmt.Errorf("No tax rate for %s in %s", taxCode, jurisdiction)
+	}
+	err = json.Unmarshal(rateAsBytes, &rate)
+	return rate, err
+}
+
+// applyTax charges every taxed line item at its jurisdiction's rate and
+// records the per tax code breakdown on the bill. Bills without a
+// jurisdiction are not taxed.
+func (b *Bill) applyTax(stub shim.ChaincodeStubInterface) error {
+	b.TaxBreakdown = nil
+	b.TaxTotal = 0
+	if b.Jurisdiction == "" {
+		return nil
+	}
+
+	byCode := map[string]*TaxLine{}
+	var codes []string
+	for i := range b.LineItems {
+		item := &b.LineItems[i]
+		item.Tax = 0
+		if item.TaxCode == "" {
+			continue
+		}
+		rate, err := getTaxRate(stub, b.Jurisdiction, item.TaxCode)
+		if err != nil {
+			return fmt.Errorf("Line item %d: %s", i, err)
+		}
+		item.Tax = taxOn(item.Total, rate.Rate)
+		line, ok := byCode[item.TaxCode]
+		if !ok {
+			line = &TaxLine{TaxCode: item.TaxCode, Rate: rate.Rate}
+			byCode[item.TaxCode] = line
+			codes = append(codes, item.TaxCode)
+		}
+		line.Taxable += item.Total
+		line.Tax += item.Tax
+		b.TaxTotal += item.Tax
+	}
+
+	sort.Strings(codes)
+	for _, code := range codes {
+		b.TaxBreakdown = append(b.TaxBreakdown, *byCode[code])
+	}
+	return nil
+}
+
+// ==== setTaxRate =========================================
+// setTaxRate adds or replaces the rate of a tax code in a jurisdiction. Admins only.
+// 0               1          2
+// "jurisdiction"  "taxcode"  "2000"   (basis points, 2000 = 20%)
+// ===========================================================================================
+func (t *SimpleChaincode) setTaxRate(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 3")
+	}
+
+	submitter, err := requireRole(stub, RoleAdmin)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if args[0] == "" || args[1] == "" {
+		return shim.Error("Jurisdiction and tax code are required")
+	}
+	rate, err := strconv.Atoi(args[2])
+	if err != nil || rate < 0 || rate > 10000 {
+		return shim.Error("Invalid rate, expecting basis points between 0 and 10000")
+	}
+
+	now, err := txTime(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	taxRate := TaxRate{Jurisdiction: args[0], TaxCode: args[1], Rate: rate, UpdatedBy: submitter.ID, UpdatedAt: now.Format(time.RFC3339)}
+	key, err := stub.CreateCompositeKey(taxRateIndexName, []string{args[0], args[1]})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	rateAsBytes, _ := json.Marshal(taxRate)
+	if err := stub.PutState(key, rateAsBytes); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(rateAsBytes)
+}
+
+// ==== queryTaxRates =========================================
+// queryTaxRates lists every tax rate of a jurisdiction.
+// 0
+// "jurisdiction"
+// ===========================================================================================
+func (t *SimpleChaincode) queryTaxRates(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(taxRateIndexName, []string{args[0]})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	rates := []TaxRate{}
+	for resultsIterator.HasNext() {
+		responseRange, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		var rate TaxRate
+		json.Unmarshal(responseRange.Value, &rate)
+		rates = append(rates, rate)
+	}
+
+	ratesAsBytes, _ := json.Marshal(rates)
+	return shim.Success(ratesAsBytes)
+}
+
+// ==== queryTaxCollected =========================================
+// queryTaxCollected totals the tax on a recipient's bills paid within a
+// period, per jurisdiction, tax code and currency. Tax is counted once a bill
+// is fully paid, on the date it was paid; partly paid bills count nothing
+// until their last payment.
+// 0              1             2
+// "recipientid"  "2017-10-01"  "2017-12-31"
+// ===========================================================================================
+func (t *SimpleChaincode) queryTaxCollected(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 3")
+	}
+
+	recipientID := args[0]
+	if _, err := time.Parse(dateLayout, args[1]); err != nil {
+		return shim.Error("Invalid from date, expecting YYYY-MM-DD")
+	}
+	if _, err := time.Parse(dateLayout, args[2]); err != nil {
+		return shim.Error("Invalid to date, expecting YYYY-MM-DD")
+	}
+
+	ids, err := billIDsForRecipient(stub, recipientID)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	type taxTotal struct {
+		Jurisdiction string `json:"jurisdiction"`
+		TaxCode      string `json:"taxcode"`
+		Currency     string `json:"currency"`
+		Taxable      int    `json:"taxable"`
+		Tax          int    `json:"tax"`
+	}
+	totals := map[string]*taxTotal{}
+	var keys []string
+	for _, id := range ids {
+		bill, err := getBill(stub, id)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if bill.Status != BillPaid || len(bill.PaidAt) < len(dateLayout) {
+			continue
+		}
+		paidOn := bill.PaidAt[:len(dateLayout)]
+		if paidOn < args[1] || paidOn > args[2] {
+			continue
+		}
+		for _, line := range bill.TaxBreakdown {
+			key := bill.Jurisdiction + "|" + line.TaxCode + "|" + bill.Currency
+			total, ok := totals[key]
+			if !ok {
+				total = &taxTotal{Jurisdiction: bill.Jurisdiction, TaxCode: line.TaxCode, Currency: bill.Currency}
+				totals[key] = total
+				keys = append(keys, key)
+			}
+			total.Taxable += line.Taxable
+			total.Tax += line.Tax
+		}
+	}
+
+	sort.Strings(keys)
+	result := []taxTotal{}
+	for _, key := range keys {
+		result = append(result, *totals[key])
+	}
+
+	resultAsBytes, _ := json.Marshal(result)
+	return shim.Success(resultAsBytes)
+}
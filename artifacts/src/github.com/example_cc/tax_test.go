@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// issueItemized has acme issue an itemized USD bill taxed in the UK
+func issueItemized(s *testStub, id, user, lineItems string) {
+	s.mustInvoke("acme", "createBill", id, "INV-"+id, "acme", user, "", "", "2017-10-01", "2017-11-30", "2017-10-01T00:00:00Z", "goods", "", "USD", "", lineItems, "UK")
+}
+
+func TestLineItemsAreTaxed(t *testing.T) {
+	s := newTestStub(t)
+	s.biller("acme")
+	s.register("alice", "Alice", "Able", KYCTier1)
+	s.mustFail("does not hold the", "acme", "setTaxRate", "UK", "STD", "2000")
+	s.mustInvoke("admin", "setTaxRate", "UK", "STD", "2000")
+	s.mustInvoke("admin", "setTaxRate", "UK", "RED", "500")
+
+	issueItemized(s, "i1", "alice", `[{"description":"widget","quantity":3,"unitprice":33,"taxcode":"STD"},{"description":"book","quantity":1,"unitprice":50,"taxcode":"RED"},{"description":"delivery","quantity":1,"unitprice":10}]`)
+	bill := s.bill("i1")
+	// 20% of 99 rounds to 20, 5% of 50 to 3
+	if bill.Subtotal != 159 || bill.TaxTotal != 23 || bill.Amount != "182" {
+		t.Fatalf("i1 is %s, %d before %d tax, expected 182, 159 before 23 tax", bill.Amount, bill.Subtotal, bill.TaxTotal)
+	}
+	if len(bill.TaxBreakdown) != 2 || bill.TaxBreakdown[0] != (TaxLine{TaxCode: "RED", Rate: 500, Taxable: 50, Tax: 3}) || bill.TaxBreakdown[1] != (TaxLine{TaxCode: "STD", Rate: 2000, Taxable: 99, Tax: 20}) {
+		t.Fatalf("i1 tax breakdown is %+v", bill.TaxBreakdown)
+	}
+
+	s.mustFail("Line item 0: No tax rate for ZERO in UK", "acme", "createBill", "i2", "INV-i2", "acme", "alice", "", "", "2017-10-01", "2017-11-30", "2017-10-01T00:00:00Z", "goods", "", "USD", "", `[{"description":"widget","quantity":1,"unitprice":10,"taxcode":"ZERO"}]`, "UK")
+}
+
+func TestTaxCollectedTotalsPaidBills(t *testing.T) {
+	s := newTestStub(t)
+	s.biller("acme")
+	s.register("alice", "Alice", "Able", KYCTier1)
+	s.fund("alice", 1000)
+	s.mustInvoke("admin", "setTaxRate", "UK", "STD", "2000")
+	items := `[{"description":"widget","quantity":2,"unitprice":50,"taxcode":"STD"}]`
+	issueItemized(s, "i1", "alice", items)
+	issueItemized(s, "i2", "alice", items)
+
+	s.mustInvoke("alice", "createMandate", "acme", "500", "500", FrequencyMonthly)
+	s.now = s.now.AddDate(0, 0, 29)
+	s.mustInvoke("acme", "collectBill", "i1")
+
+	var totals []struct {
+		Jurisdiction string `json:"jurisdiction"`
+		TaxCode      string `json:"taxcode"`
+		Currency     string `json:"currency"`
+		Taxable      int    `json:"taxable"`
+		Tax          int    `json:"tax"`
+	}
+	if err := json.Unmarshal(s.mustInvoke("acme", "queryTaxCollected", "acme", "2017-11-01", "2017-11-30"), &totals); err != nil {
+		t.Fatal(err)
+	}
+	if len(totals) != 1 || totals[0].Jurisdiction != "UK" || totals[0].Currency != "USD" || totals[0].Taxable != 100 || totals[0].Tax != 20 {
+		t.Fatalf("tax collected is %+v, expected 20 on 100 of STD", totals)
+	}
+	if err := json.Unmarshal(s.mustInvoke("acme", "queryTaxCollected", "acme", "2017-12-01", "2017-12-31"), &totals); err != nil {
+		t.Fatal(err)
+	}
+	if len(totals) != 0 {
+		t.Fatalf("tax collected in December is %+v, expected none", totals)
+	}
+}
+
+func TestTaxCollectedCountsFullyPaidBills(t *testing.T) {
+	s := newTestStub(t)
+	s.biller("acme")
+	s.register("alice", "Alice", "Able", KYCTier1)
+	s.mustInvoke("admin", "setTaxRate", "UK", "STD", "2000")
+	items := `[{"description":"widget","quantity":2,"unitprice":50,"taxcode":"STD"},{"description":"delivery","quantity":1,"unitprice":10}]`
+	issueItemized(s, "i1", "alice", items)
+	issueItemized(s, "i2", "alice", items)
+	if bill := s.bill("i1"); bill.Amount != "130" || bill.TaxTotal != 20 {
+		t.Fatalf("i1 is %s with %d tax, expected 130 with 20", bill.Amount, bill.TaxTotal)
+	}
+
+	s.mustPay("p1", "alice", 130, `[{"billid":"i1","amount":130}]`)
+	s.mustPay("p2", "alice", 60, `[{"billid":"i2","amount":60}]`)
+
+	var totals []struct {
+		Jurisdiction string `json:"jurisdiction"`
+		TaxCode      string `json:"taxcode"`
+		Taxable      int    `json:"taxable"`
+		Tax          int    `json:"tax"`
+	}
+	if err := json.Unmarshal(s.mustInvoke("acme", "queryTaxCollected", "acme", "2017-11-01", "2017-11-30"), &totals); err != nil {
+		t.Fatal(err)
+	}
+	// The partly paid i2 counts nothing yet
+	if len(totals) != 1 || totals[0].TaxCode != "STD" || totals[0].Taxable != 100 || totals[0].Tax != 20 {
+		t.Fatalf("tax collected is %+v, expected 20 on 100 of STD", totals)
+	}
+}
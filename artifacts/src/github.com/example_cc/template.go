@@ -0,0 +1,315 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+var templatePrefix = "TEMPLATE"                               //prefix for the key/value that stores a bill template
+var templateIndexName = "billtemplate~recipientid~templateid" //composite index of a recipient's bill templates
+
+// Bill template statuses
+const (
+	TemplateActive    = "ACTIVE"
+	TemplateCancelled = "CANCELLED"
+)
+
+// Outcomes reported per bill by generateBills
+const (
+	GeneratedCreated = "CREATED"
+	GeneratedExists  = "EXISTS"
+	GeneratedFailed  = "FAILED" //the bill could not be issued; the template's later bills are not tried
+)
+
+// BillTemplate holds what a recipient bills a user on every occurrence of a
+// recurrence rule. Bills are dated on each occurrence from StartDate up to
+// EndDate, if set, and are due DueDays later. Either Amount or LineItems is given.
+type BillTemplate struct {
+	ID           string     `json:"id"`
+	RecipientID  string     `json:"recipientid"`
+	UserID       string     `json:"userid"`
+	Description  string     `json:"description"`
+	Amount       string     `json:"amount"`
+	Currency     string     `json:"currency"`
+	LineItems    []LineItem `json:"lineitems"`
+	Jurisdiction string     `json:"jurisdiction"`
+	Terms        string     `json:"terms"`
+	Frequency    string     `json:"frequency"`
+	StartDate    string     `json:"startdate"`
+	EndDate      string     `json:"enddate"`
+	DueDays      int        `json:"duedays"`
+	Status       string     `json:"status"`
+	CreatedAt    string     `json:"created_at"`
+}
+
+// GeneratedBill reports what generateBills did for one occurrence of a template
+type GeneratedBill struct {
+	TemplateID string `json:"templateid"`
+	BillID     string `json:"billid"`
+	BillDate   string `json:"billdate"`
+	Status     string `json:"status"`
+	Error      string `json:"error"`
+}
+
+func getBillTemplate(stub shim.ChaincodeStubInterface, id string) (BillTemplate, error) {
+	var template BillTemplate
+	templateAsBytes, err := stub.GetState(templatePrefix + id)
+	if err != nil {
+		return template, fmt.Errorf("Failed to get bill template %s", id)
+	}
+	if templateAsBytes == nil {
+		return template, fmt.Errorf("Bill template not found: %s", id)
+	}
+	err = json.Unmarshal(templateAsBytes, &template)
+	return template, err
+}
+
+func putBillTemplate(stub shim.ChaincodeStubInterface, template BillTemplate) error {
+	templateAsBytes, _ := json.Marshal(template)
+	return stub.PutState(templatePrefix+template.ID, templateAsBytes)
+}
+
+// billArgs builds the createBill arguments of the bill a template issues on a given date
+func (tpl BillTemplate) billArgs(billID string, billDate time.Time, now time.Time) []string {
+	dueDate := ""
+	if tpl.DueDays > 0 || tpl.Terms == "" {
+		dueDate = billDate.AddDate(0, 0, tpl.DueDays).Format(dateLayout)
+	}
+	lineItems := ""
+	if len(tpl.LineItems) > 0 {
+		lineItemsAsBytes, _ := json.Marshal(tpl.LineItems)
+		lineItems = string(lineItemsAsBytes)
+	}
+	return []string{billID, billID, tpl.RecipientID, tpl.UserID, "", "", billDate.Format(dateLayout), dueDate, now.Format(time.RFC3339), tpl.Description, tpl.Amount, tpl.Currency, "", lineItems, tpl.Jurisdiction, tpl.Terms}
+}
+
+// ==== createBillTemplate =========================================
+// createBillTemplate stores a recurring bill issued by the submitter. The
+// template is a JSON object with the BillTemplate fields; frequency is
+// DAILY, WEEKLY or MONTHLY.
+// 0
+// '{"id":"t1","userid":"u1","amount":"100","currency":"USD","frequency":"MONTHLY","startdate":"2017-11-01","duedays":14}'
+// ===========================================================================================
+func (t *SimpleChaincode) createBillTemplate(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	submitter, err := getParty(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	var template BillTemplate
+	if err := json.Unmarshal([]byte(args[0]), &template); err != nil {
+		return shim.Error("Invalid bill template, expecting a JSON object")
+	}
+	if template.ID == "" || template.UserID == "" {
+		return shim.Error("Bill template needs an id and a userid")
+	}
+	if existing, err := stub.GetState(templatePrefix + template.ID); err != nil || existing != nil {
+		return shim.Error("Bill template already exists: " + template.ID)
+	}
+	if template.Frequency != FrequencyDaily && template.Frequency != FrequencyWeekly && template.Frequency != FrequencyMonthly {
+		return shim.Error(fmt.Sprintf("Frequency must be one of '%s', '%s' or '%s'. But got: %v", FrequencyDaily, FrequencyWeekly, FrequencyMonthly, template.Frequency))
+	}
+	if _, err := time.Parse(dateLayout, template.StartDate); err != nil {
+		return shim.Error("Invalid start date, expecting YYYY-MM-DD")
+	}
+	if template.EndDate != "" {
+		if _, err := time.Parse(dateLayout, template.EndDate); err != nil || template.EndDate < template.StartDate {
+			return shim.Error("Invalid end date, expecting YYYY-MM-DD no earlier than the start date")
+		}
+	}
+	if template.DueDays < 0 {
+		return shim.Error("Invalid due days, expecting a non-negative integer value")
+	}
+	if len(template.LineItems) == 0 {
+		if amount, err := strconv.Atoi(template.Amount); err != nil || amount <= 0 {
+			return shim.Error("Bill template needs line items or a positive integer amount")
+		}
+	}
+	if template.Terms != "" {
+		if _, err := parsePaymentTerms(template.Terms); err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	now, err := txTime(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	template.RecipientID = submitter.ID
+	template.Status = TemplateActive
+	template.CreatedAt = now.Format(time.RFC3339)
+	if err := putBillTemplate(stub, template); err != nil {
+		return shim.Error(err.Error())
+	}
+	indexKey, err := stub.CreateCompositeKey(templateIndexName, []string{template.RecipientID, template.ID})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := stub.PutState(indexKey, []byte{0x00}); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	templateAsBytes, _ := json.Marshal(template)
+	return shim.Success(templateAsBytes)
+}
+
+// ==== cancelBillTemplate =========================================
+// cancelBillTemplate stops a template from generating any more bills. Bills already generated are kept.
+// 0
+// "templateid"
+// ===========================================================================================
+func (t *SimpleChaincode) cancelBillTemplate(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	submitter, err := getParty(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	template, err := getBillTemplate(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if template.RecipientID != submitter.ID {
+		return shim.Error(fmt.Sprintf("Only recipient %s can cancel bill template %s", template.RecipientID, template.ID))
+	}
+	if template.Status != TemplateActive {
+		return shim.Error(fmt.Sprintf("Bill template %s is already %s", template.ID, template.Status))
+	}
+
+	template.Status = TemplateCancelled
+	if err := putBillTemplate(stub, template); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	templateAsBytes, _ := json.Marshal(template)
+	return shim.Success(templateAsBytes)
+}
+
+// ==== queryBillTemplate =========================================
+// 0
+// "templateid"
+// ===========================================================================================
+func (t *SimpleChaincode) queryBillTemplate(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	template, err := getBillTemplate(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	templateAsBytes, _ := json.Marshal(template)
+	return shim.Success(templateAsBytes)
+}
+
+// ==== generateBills =========================================
+// generateBills issues the bills of the submitter's active templates dated
+// within a billing period, both dates included. The period ends no later than
+// the transaction date. Bill IDs are the template ID and the bill date, so a
+// period that was already generated is skipped. A template whose bill cannot
+// be issued, e.g. for an unknown user, is reported as FAILED and the other
+// templates go ahead.
+// 0             1
+// "2017-11-01"  "2017-11-30"
+// ===========================================================================================
+func (t *SimpleChaincode) generateBills(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	logger.Info("########### generateBills ###########")
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+
+	submitter, err := getParty(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	from, err := time.Parse(dateLayout, args[0])
+	if err != nil {
+		return shim.Error("Invalid period start, expecting YYYY-MM-DD")
+	}
+	to, err := time.Parse(dateLayout, args[1])
+	if err != nil || to.Before(from) {
+		return shim.Error("Invalid period end, expecting YYYY-MM-DD no earlier than the period start")
+	}
+	now, err := txTime(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	// Bills are not issued ahead of time, and a long period must not issue thousands at once
+	if today, _ := time.Parse(dateLayout, now.Format(dateLayout)); to.After(today) {
+		to = today
+	}
+
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(templateIndexName, []string{submitter.ID})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	var templateIDs []string
+	for resultsIterator.HasNext() {
+		responseRange, err := resultsIterator.Next()
+		if err != nil {
+			resultsIterator.Close()
+			return shim.Error(err.Error())
+		}
+		_, compositeKeyParts, err := stub.SplitCompositeKey(responseRange.Key)
+		if err != nil {
+			resultsIterator.Close()
+			return shim.Error(err.Error())
+		}
+		templateIDs = append(templateIDs, compositeKeyParts[1])
+	}
+	resultsIterator.Close()
+
+	generated := []GeneratedBill{}
+	for _, templateID := range templateIDs {
+		template, err := getBillTemplate(stub, templateID)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if template.Status != TemplateActive {
+			continue
+		}
+		start, _ := time.Parse(dateLayout, template.StartDate)
+		for n := 0; ; n++ {
+			billDate := occurrence(start, template.Frequency, n)
+			if billDate.After(to) || (template.EndDate != "" && billDate.Format(dateLayout) > template.EndDate) {
+				break
+			}
+			if billDate.Before(from) {
+				continue
+			}
+			billID := template.ID + "-" + billDate.Format(dateLayout)
+			result := GeneratedBill{TemplateID: template.ID, BillID: billID, BillDate: billDate.Format(dateLayout), Status: GeneratedExists}
+			existing, err := stub.GetState(billPrefix + billID)
+			if err != nil {
+				return shim.Error(err.Error())
+			}
+			if existing == nil {
+				// createBill checks everything before it writes, so a failed bill leaves nothing behind
+				resp := t.createBill(stub, template.billArgs(billID, billDate, now))
+				if resp.Status != shim.OK {
+					result.Status = GeneratedFailed
+					result.Error = resp.Message
+					generated = append(generated, result)
+					break
+				}
+				result.Status = GeneratedCreated
+			}
+			generated = append(generated, result)
+		}
+	}
+	logger.Infof("generateBills considered %d bills\n", len(generated))
+
+	generatedAsBytes, _ := json.Marshal(generated)
+	return shim.Success(generatedAsBytes)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func generate(s *testStub, biller, from, to string) []GeneratedBill {
+	var generated []GeneratedBill
+	if err := json.Unmarshal(s.mustInvoke(biller, "generateBills", from, to), &generated); err != nil {
+		s.t.Fatal(err)
+	}
+	return generated
+}
+
+func countStatus(generated []GeneratedBill, status string) int {
+	n := 0
+	for _, result := range generated {
+		if result.Status == status {
+			n++
+		}
+	}
+	return n
+}
+
+func TestGenerateBillsTwiceCreatesNoDuplicates(t *testing.T) {
+	s := newTestStub(t)
+	s.biller("acme")
+	s.register("alice", "Alice", "Able", KYCTier1)
+	s.mustInvoke("acme", "createBillTemplate", `{"id":"t1","userid":"alice","amount":"100","currency":"USD","frequency":"MONTHLY","startdate":"2017-09-01","duedays":14}`)
+
+	// The period is cut at the transaction date, 2017-11-01
+	generated := generate(s, "acme", "2017-09-01", "2017-12-31")
+	if len(generated) != 3 || countStatus(generated, GeneratedCreated) != 3 {
+		t.Fatalf("first run returned %v, expected 3 bills created", generated)
+	}
+	if bill := s.bill("t1-2017-11-01"); bill.BillDueDate != "2017-11-15" || bill.Timestamp != "2017-11-01T10:00:00Z" {
+		t.Fatalf("bill of 2017-11-01 is due %s and stamped %s", bill.BillDueDate, bill.Timestamp)
+	}
+
+	generated = generate(s, "acme", "2017-09-01", "2017-12-31")
+	if len(generated) != 3 || countStatus(generated, GeneratedExists) != 3 {
+		t.Fatalf("second run returned %v, expected 3 bills that exist", generated)
+	}
+	var index AllBills
+	s.get(billIndexStr, &index)
+	if len(index.Bills) != 3 {
+		t.Fatalf("bill index holds %d bills, expected 3", len(index.Bills))
+	}
+}
+
+func TestGenerateBillsStopsAtTransactionDate(t *testing.T) {
+	s := newTestStub(t)
+	s.biller("acme")
+	s.register("alice", "Alice", "Able", KYCTier1)
+	s.mustInvoke("acme", "createBillTemplate", `{"id":"t1","userid":"alice","amount":"5","currency":"USD","frequency":"DAILY","startdate":"2017-10-01"}`)
+
+	// 2017-10-01 through 2017-11-01, not ten years of daily bills
+	if generated := generate(s, "acme", "2017-10-01", "2027-10-01"); countStatus(generated, GeneratedCreated) != 32 {
+		t.Fatalf("generateBills created %d bills, expected 32", countStatus(generated, GeneratedCreated))
+	}
+}
+
+func TestGenerateBillsSkipsFailingTemplates(t *testing.T) {
+	s := newTestStub(t)
+	s.biller("acme")
+	s.register("alice", "Alice", "Able", KYCTier1)
+	s.mustInvoke("acme", "createBillTemplate", `{"id":"t0","userid":"ghost","amount":"100","currency":"USD","frequency":"MONTHLY","startdate":"2017-10-01","duedays":14}`)
+	s.mustInvoke("acme", "createBillTemplate", `{"id":"t1","userid":"alice","amount":"100","currency":"USD","frequency":"MONTHLY","startdate":"2017-10-01","duedays":14}`)
+
+	generated := generate(s, "acme", "2017-10-01", "2017-11-30")
+	if len(generated) != 3 || generated[0].Status != GeneratedFailed || generated[0].Error == "" || countStatus(generated, GeneratedCreated) != 2 {
+		t.Fatalf("generateBills returned %v, expected t0 to fail once and t1 to create 2 bills", generated)
+	}
+	s.bill("t1-2017-11-01")
+}
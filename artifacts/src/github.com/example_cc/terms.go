@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PaymentTerms are early-payment terms such as "2/10 net 30": DiscountRate
+// basis points off when paid within DiscountDays of the bill date, the full
+// amount due within NetDays
+type PaymentTerms struct {
+	DiscountRate int `json:"discountrate"`
+	DiscountDays int `json:"discountdays"`
+	NetDays      int `json:"netdays"`
+}
+
+// parsePaymentTerms reads terms written as "2/10 net 30", "1.5/15 net 45" or just "net 30"
+func parsePaymentTerms(s string) (PaymentTerms, error) {
+	var terms PaymentTerms
+	invalid := fmt.Errorf("Invalid payment terms %q, expecting e.g. \"2/10 net 30\"", s)
+	fields := strings.Fields(strings.ToLower(s))
+	if len(fields) == 3 {
+		parts := strings.Split(fields[0], "/")
+		if len(parts) != 2 {
+			return terms, invalid
+		}
+		percent, err := strconv.ParseFloat(parts[0], 64)
+		if err != nil || percent <= 0 || percent >= 100 {
+			return terms, invalid
+		}
+		terms.DiscountRate = int(percent*100 + 0.5)
+		if terms.DiscountDays, err = strconv.Atoi(parts[1]); err != nil || terms.DiscountDays <= 0 {
+			return terms, invalid
+		}
+		fields = fields[1:]
+	}
+	if len(fields) != 2 || fields[0] != "net" {
+		return terms, invalid
+	}
+	netDays, err := strconv.Atoi(fields[1])
+	if err != nil || netDays < terms.DiscountDays {
+		return terms, invalid
+	}
+	terms.NetDays = netDays
+	return terms, nil
+}
+
+// earlyDiscount is the discount the bill's terms allow if it is paid in full
+// at the given time; zero outside the window or once a discount was taken
+func (b Bill) earlyDiscount(now time.Time) int {
+	if b.Terms.DiscountRate == 0 || b.DiscountTaken > 0 {
+		return 0
+	}
+	billDate, err := time.Parse(dateLayout, b.BillDate)
+	if err != nil || now.Format(dateLayout) > billDate.AddDate(0, 0, b.Terms.DiscountDays).Format(dateLayout) {
+		return 0
+	}
+	effective, err := b.effectiveAmount()
+	if err != nil {
+		return 0
+	}
+	outstanding, _ := b.outstanding()
+	discount := (effective*b.Terms.DiscountRate + 5000) / 10000
+	if discount >= outstanding {
+		return 0
+	}
+	return discount
+}
+
+// amountToSettle is what pays the bill in full at the given time, after any early-payment discount
+func (b Bill) amountToSettle(now time.Time) (int, error) {
+	outstanding, err := b.outstanding()
+	if err != nil {
+		return 0, err
+	}
+	return outstanding - b.earlyDiscount(now), nil
+}
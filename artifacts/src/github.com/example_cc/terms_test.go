@@ -0,0 +1,65 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParsePaymentTerms(t *testing.T) {
+	for s, want := range map[string]PaymentTerms{
+		"2/10 net 30":   {DiscountRate: 200, DiscountDays: 10, NetDays: 30},
+		"1.5/15 Net 45": {DiscountRate: 150, DiscountDays: 15, NetDays: 45},
+		"net 30":        {NetDays: 30},
+	} {
+		if got, err := parsePaymentTerms(s); err != nil || got != want {
+			t.Errorf("parsePaymentTerms(%q) = %+v, %v, expected %+v", s, got, err, want)
+		}
+	}
+	for _, s := range []string{"", "net", "2 net 30", "2/40 net 30", "0/10 net 30", "2/10 gross 30", "net thirty"} {
+		if _, err := parsePaymentTerms(s); err == nil {
+			t.Errorf("parsePaymentTerms(%q) succeeded, expected an error", s)
+		}
+	}
+}
+
+// issueWithTerms has acme issue a USD bill with payment terms and no due date
+func issueWithTerms(s *testStub, id, user, billDate, amount, terms string) {
+	s.mustInvoke("acme", "createBill", id, "INV-"+id, "acme", user, "", "", billDate, "", billDate+"T00:00:00Z", "services", amount, "USD", "", "", "", terms)
+}
+
+func TestEarlyPaymentDiscount(t *testing.T) {
+	s := newTestStub(t)
+	s.biller("acme")
+	s.register("alice", "Alice", "Able", KYCTier1)
+	s.fund("alice", 5000)
+	issueWithTerms(s, "B1", "alice", "2017-10-25", "1000", "2/10 net 30")
+	issueWithTerms(s, "B2", "alice", "2017-10-01", "1000", "2/10 net 30")
+
+	if bill := s.bill("B1"); bill.BillDueDate != "2017-11-24" {
+		t.Fatalf("B1 is due %s, expected 30 days after its bill date", bill.BillDueDate)
+	}
+
+	// Paid within 10 days of its bill date, 980 settles B1
+	s.mustPay("p1", "alice", 980, `[{"billid":"B1","amount":980}]`)
+	if bill := s.bill("B1"); bill.Status != BillPaid || bill.DiscountTaken != 20 || bill.Outstanding != 0 {
+		t.Fatalf("B1 is %s with %d discount and %d outstanding, expected %s with 20 discount", bill.Status, bill.DiscountTaken, bill.Outstanding, BillPaid)
+	}
+
+	// B2's window closed on 2017-10-11
+	s.mustPay("p2", "alice", 980, `[{"billid":"B2","amount":980}]`)
+	if bill := s.bill("B2"); bill.Status != BillPartial || bill.DiscountTaken != 0 || bill.Outstanding != 20 {
+		t.Fatalf("B2 is %s with %d discount and %d outstanding, expected %s with 20 outstanding", bill.Status, bill.DiscountTaken, bill.Outstanding, BillPartial)
+	}
+}
+
+func TestEarlyDiscountRoundsOnEffectiveAmount(t *testing.T) {
+	bill := Bill{ID: "B1", BillDate: "2017-10-25", Amount: "1000", AdjustmentTotal: -175, Terms: PaymentTerms{DiscountRate: 150, DiscountDays: 10, NetDays: 30}}
+	now := time.Date(2017, 11, 4, 10, 0, 0, 0, time.UTC)
+	// 1.5% of 825 is 12.375, rounded to 12
+	if got, err := bill.amountToSettle(now); err != nil || got != 813 {
+		t.Fatalf("amountToSettle = %d, %v, expected 813", got, err)
+	}
+	if got := bill.earlyDiscount(now.AddDate(0, 0, 1)); got != 0 {
+		t.Fatalf("discount after the window is %d, expected 0", got)
+	}
+}
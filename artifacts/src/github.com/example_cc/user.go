@@ -0,0 +1,210 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+var userPrefix = "USER" //prefix for the key/value that stores a user profile
+
+// KYC statuses
+const (
+	KYCPending  = "PENDING"
+	KYCVerified = "VERIFIED"
+	KYCRejected = "REJECTED"
+)
+
+// UserProfile is the registered profile of a user. ID is the user's
+// enrollment ID, which bills and payments reference as their UserID.
+type UserProfile struct {
+	ID           string `json:"id"`
+	MSPID        string `json:"mspid"`
+	FirstName    string `json:"firstname"`
+	LastName     string `json:"lastname"`
+	Email        string `json:"email"`
+	Phone        string `json:"phone"`
+	KYCStatus    string `json:"kycstatus"`
+	KYCTier      string `json:"kyctier"` //see the KYC tiers in kyc.go
+	KYCUpdatedBy string `json:"kycupdatedby"`
+	RegisteredAt string `json:"registered_at"`
+	UpdatedAt    string `json:"updated_at"`
+}
+
+func getUserProfile(stub shim.ChaincodeStubInterface, id string) (UserProfile, error) {
+	profile, found, err := findUserProfile(stub, id)
+	if err == nil && !found {
+		err = fmt.Errorf("Unknown user: %s", id)
+	}
+	return profile, err
+}
+
+// findUserProfile is getUserProfile for callers that treat an unknown user as a valid answer
+func findUserProfile(stub shim.ChaincodeStubInterface, id string) (UserProfile, bool, error) {
+	var profile UserProfile
+	profileAsBytes, err := stub.GetState(userPrefix + id)
+	if err != nil {
+		return profile, false, fmt.Errorf("Failed to get user %s", id)
+	}
+	if profileAsBytes == nil {
+		return profile, false, nil
+	}
+	err = json.Unmarshal(profileAsBytes, &profile)
+	return profile, true, err
+}
+
+func putUserProfile(stub shim.ChaincodeStubInterface, profile UserProfile) error {
+	profileAsBytes, _ := json.Marshal(profile)
+	return stub.PutState(userPrefix+profile.ID, profileAsBytes)
+}
+
+// withProfile wraps a stored record in a response that also carries the profile of its user
+func withProfile(stub shim.ChaincodeStubInterface, name string, recordAsBytes []byte, userID string) ([]byte, error) {
+	profile, err := getUserProfile(stub, userID)
+	if err != nil {
+		return nil, err
+	}
+	profileAsBytes, _ := json.Marshal(profile)
+	return json.Marshal(map[string]json.RawMessage{name: recordAsBytes, "user": profileAsBytes})
+}
+
+// ==== registerUser =========================================
+// registerUser registers the submitter's profile, pending KYC.
+// 0            1           2        3
+// "firstname"  "lastname"  "email"  "phone"
+// ===========================================================================================
+func (t *SimpleChaincode) registerUser(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 4 {
+		return shim.Error("Incorrect number of arguments. Expecting 4")
+	}
+
+	submitter, err := getSubmitter(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if _, err := getUserProfile(stub, submitter.ID); err == nil {
+		return shim.Error("User already registered: " + submitter.ID)
+	}
+	if args[0] == "" || args[1] == "" {
+		return shim.Error("First and last name are required")
+	}
+
+	now, err := txTime(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	profile := UserProfile{ID: submitter.ID, MSPID: submitter.MSPID, FirstName: args[0], LastName: args[1], Email: args[2], Phone: args[3], KYCStatus: KYCPending, RegisteredAt: now.Format(time.RFC3339), UpdatedAt: now.Format(time.RFC3339)}
+	if err := putUserProfile(stub, profile); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	profileAsBytes, _ := json.Marshal(profile)
+	return shim.Success(profileAsBytes)
+}
+
+// ==== updateUserProfile =========================================
+// updateUserProfile changes the submitter's profile. Bills and payments
+// reference the user ID, so they show the new details from then on. KYC
+// checks were made against the old name, so a change of name sends the user
+// back to PENDING and TIER0 until a KYC provider verifies it again.
+// 0            1           2        3
+// "firstname"  "lastname"  "email"  "phone"
+// ===========================================================================================
+func (t *SimpleChaincode) updateUserProfile(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 4 {
+		return shim.Error("Incorrect number of arguments. Expecting 4")
+	}
+
+	submitter, err := getParty(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	profile, err := getUserProfile(stub, submitter.ID)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if args[0] == "" || args[1] == "" {
+		return shim.Error("First and last name are required")
+	}
+
+	now, err := txTime(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if args[0] != profile.FirstName || args[1] != profile.LastName {
+		profile.KYCStatus = KYCPending
+		profile.KYCTier = KYCTier0
+		profile.KYCUpdatedBy = submitter.ID
+	}
+	profile.FirstName, profile.LastName, profile.Email, profile.Phone = args[0], args[1], args[2], args[3]
+	profile.UpdatedAt = now.Format(time.RFC3339)
+	if err := putUserProfile(stub, profile); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	profileAsBytes, _ := json.Marshal(profile)
+	return shim.Success(profileAsBytes)
+}
+
+// ==== setKYCStatus =========================================
+// setKYCStatus records the outcome of a user's KYC checks. Users who are no
+// longer verified drop back to TIER0. Only a KYC provider may set it.
+// 0         1
+// "userid"  "PENDING"|"VERIFIED"|"REJECTED"
+// ===========================================================================================
+func (t *SimpleChaincode) setKYCStatus(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+	provider, err := requireRole(stub, RoleKYCProvider)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	status := args[1]
+	if status != KYCPending && status != KYCVerified && status != KYCRejected {
+		return shim.Error(fmt.Sprintf("KYC status must be one of '%s', '%s' or '%s'. But got: %v", KYCPending, KYCVerified, KYCRejected, status))
+	}
+	profile, err := getUserProfile(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	now, err := txTime(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	profile.KYCStatus = status
+	if status != KYCVerified {
+		profile.KYCTier = KYCTier0
+	}
+	profile.KYCUpdatedBy = provider.ID
+	profile.UpdatedAt = now.Format(time.RFC3339)
+	if err := putUserProfile(stub, profile); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	profileAsBytes, _ := json.Marshal(profile)
+	return shim.Success(profileAsBytes)
+}
+
+// ==== queryUser =========================================
+// 0
+// "userid"
+// ===========================================================================================
+func (t *SimpleChaincode) queryUser(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	profile, err := getUserProfile(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	profileAsBytes, _ := json.Marshal(profile)
+	return shim.Success(profileAsBytes)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestBillsNameRegisteredUsers(t *testing.T) {
+	s := newTestStub(t)
+	s.biller("acme")
+	s.mustFail("Unknown user: alice", "acme", "createBill", "B1", "INV-B1", "acme", "alice", "Alice", "Able", "2017-10-01", "2017-11-30", "2017-10-01T00:00:00Z", "services", "100", "USD", "")
+	s.register("alice", "Alice", "Able", "")
+	s.mustFail("User already registered: alice", "alice", "registerUser", "Alice", "Able", "", "")
+	s.issueBill("B1", "acme", "alice", 100, "2017-11-30")
+
+	// The bill shows the profile as it is now, not as it was when the bill was issued
+	s.mustInvoke("alice", "updateUserProfile", "Alice", "Baker", "alice@example.org", "555-0199")
+	var resp struct {
+		Bill Bill        `json:"bill"`
+		User UserProfile `json:"user"`
+	}
+	if err := json.Unmarshal(s.mustInvoke("acme", "queryBill", billPrefix+"B1", "profile"), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Bill.ID != "B1" || resp.User.LastName != "Baker" || resp.User.KYCStatus != KYCPending {
+		t.Fatalf("bill %s names %+v, expected the updated profile of alice pending KYC", resp.Bill.ID, resp.User)
+	}
+}
+
+func TestOnlyKYCProvidersSetKYCStatus(t *testing.T) {
+	s := newTestStub(t)
+	s.register("alice", "Alice", "Able", "")
+
+	s.mustFail(RoleKYCProvider, "alice", "setKYCStatus", "alice", KYCVerified)
+	s.mustFail("KYC status must be one of", "kyc", "setKYCStatus", "alice", "APPROVED")
+	s.mustInvoke("kyc", "setKYCStatus", "alice", KYCVerified)
+	var profile UserProfile
+	s.get(userPrefix+"alice", &profile)
+	if profile.KYCStatus != KYCVerified || profile.KYCUpdatedBy != "kyc" {
+		t.Fatalf("alice is %s by %q, expected %s by kyc", profile.KYCStatus, profile.KYCUpdatedBy, KYCVerified)
+	}
+}
+
+func TestRenameResetsKYC(t *testing.T) {
+	s := newTestStub(t)
+	s.register("alice", "Alice", "Able", KYCTier1)
+
+	s.mustInvoke("alice", "updateUserProfile", "Alice", "Able", "alice@example.org", "555-0199")
+	var profile UserProfile
+	s.get(userPrefix+"alice", &profile)
+	if profile.KYCStatus != KYCVerified || profile.KYCTier != KYCTier1 {
+		t.Fatalf("contact change left alice %s at %s, expected %s at %s", profile.KYCStatus, profile.KYCTier, KYCVerified, KYCTier1)
+	}
+
+	s.mustInvoke("alice", "updateUserProfile", "Alicia", "Able", "alice@example.org", "555-0199")
+	s.get(userPrefix+"alice", &profile)
+	if profile.KYCStatus != KYCPending || profile.KYCTier != KYCTier0 {
+		t.Fatalf("rename left alice %s at %s, expected %s at %s", profile.KYCStatus, profile.KYCTier, KYCPending, KYCTier0)
+	}
+}
+
+func TestRenamedUserIsScreened(t *testing.T) {
+	s := newTestStub(t)
+	s.grant(RoleCompliance, "officer")
+	s.register("alice", "Alice", "Able", KYCTier1)
+	s.fund("alice", 1000)
+	s.mustInvoke("officer", "addSanctionsEntry", "SDN-1", "Mal", "Lory", SanctionBlock, "OFAC")
+
+	s.mustInvoke("alice", "updateUserProfile", "Mal", "Lory", "alice@example.org", "555-0199")
+	s.mustFail(SanctionsBlocked, "alice", "move", "alice", "b", "10")
+}
@@ -0,0 +1,485 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+var velocityRuleIndexName = "velocityrule~id"                   //composite key holding a velocity or anomaly rule
+var accountActivityIndexName = "activity~account"               //composite key holding an account's rolling counters
+var counterpartyIndexName = "counterparty~account~counterparty" //composite key marking a counterparty an account has paid
+var velocityAlertIndexName = "velocityalert~txid~seq"           //composite key holding an alert raised on a transaction
+var velocityAlertEvent = "VelocityAlert"                        //chaincode event emitted when a transaction matches a rule
+
+// VelocityHeld starts the message of the error raised when a transfer that cannot be held would be held for review
+const VelocityHeld = "VELOCITY_HOLD"
+
+// Rule types
+const (
+	RuleCount           = "COUNT"            //more than Count transfers within WindowMinutes
+	RuleAverageMultiple = "AVERAGE_MULTIPLE" //amount over Multiple times the account's average, once it has MinHistory transfers
+	RuleNewCounterparty = "NEW_COUNTERPARTY" //first transfer to a counterparty of at least MinAmount
+)
+
+// What a matching rule does to the transaction
+const (
+	VelocityHold = "HOLD" //funds are reserved, or the payment left unallocated, until reviewed
+	VelocityFlag = "FLAG" //the transaction goes through and the alert is kept for review
+)
+
+// reviewHoldDays is how long a held transfer reserves funds for review
+const reviewHoldDays = 7
+
+// reviewHoldPrefix starts the ID of every hold placed by a velocity rule
+const reviewHoldPrefix = "REVIEW"
+
+// defaultActivityWindow is how long transfer times are kept without COUNT rules
+const defaultActivityWindow = 24 * time.Hour
+
+// VelocityRule is a fraud rule evaluated on every transfer and on createPayment
+type VelocityRule struct {
+	ID            string `json:"id"`
+	Type          string `json:"type"`
+	Count         int    `json:"count"`
+	WindowMinutes int    `json:"windowminutes"`
+	Multiple      int    `json:"multiple"`
+	MinHistory    int    `json:"minhistory"`
+	MinAmount     int    `json:"minamount"`
+	Action        string `json:"action"`
+	UpdatedBy     string `json:"updatedby"`
+	UpdatedAt     string `json:"updated_at"`
+}
+
+// AccountActivity holds an account's rolling counters: the times of its
+// recent transfers and the count and total of all its transfers. Like KYC
+// usage it is kept per enrollment ID, so a user's moves from its account and
+// the payments recorded for it add up to the same counters.
+type AccountActivity struct {
+	Account string  `json:"account"`
+	Recent  []int64 `json:"recent"` //unix seconds
+	Count   int     `json:"count"`
+	Total   int     `json:"total"`
+}
+
+// VelocityAlert records a transaction that matched one or more rules
+type VelocityAlert struct {
+	TxID         string   `json:"txid"`
+	Function     string   `json:"function"`
+	Account      string   `json:"account"`
+	Counterparty string   `json:"counterparty"`
+	Amount       int      `json:"amount"`
+	RuleIDs      []string `json:"ruleids"`
+	Action       string   `json:"action"`
+	HoldID       string   `json:"holdid"`
+	PaymentID    string   `json:"paymentid"`
+	Timestamp    string   `json:"tr_time"`
+}
+
+func velocityRules(stub shim.ChaincodeStubInterface) ([]VelocityRule, error) {
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(velocityRuleIndexName, []string{})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	var rules []VelocityRule
+	for resultsIterator.HasNext() {
+		responseRange, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		var rule VelocityRule
+		if err := json.Unmarshal(responseRange.Value, &rule); err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// matches reports whether a transfer matches the rule, given the account's counters before it
+func (r VelocityRule) matches(activity AccountActivity, amount int, newCounterparty bool, now time.Time) bool {
+	switch r.Type {
+	case RuleCount:
+		since := now.Add(-time.Duration(r.WindowMinutes) * time.Minute).Unix()
+		count := 1
+		for _, at := range activity.Recent {
+			if at > since {
+				count++
+			}
+		}
+		return count > r.Count
+	case RuleAverageMultiple:
+		return activity.Count >= r.MinHistory && activity.Count > 0 && amount*activity.Count > r.Multiple*activity.Total
+	case RuleNewCounterparty:
+		return newCounterparty && amount >= r.MinAmount
+	}
+	return false
+}
+
+// getActivity reads an account's rolling counters
+func getActivity(stub shim.ChaincodeStubInterface, account string) (AccountActivity, string, error) {
+	activity := AccountActivity{Account: account}
+	activityKey, err := stub.CreateCompositeKey(accountActivityIndexName, []string{account})
+	if err != nil {
+		return activity, activityKey, err
+	}
+	activityAsBytes, err := stub.GetState(activityKey)
+	if err != nil {
+		return activity, activityKey, err
+	}
+	if activityAsBytes != nil {
+		err = json.Unmarshal(activityAsBytes, &activity)
+	}
+	return activity, activityKey, err
+}
+
+// isNewCounterparty tells whether an account has never paid a counterparty,
+// and returns the key that marks it as paid. counterparty may be empty.
+func isNewCounterparty(stub shim.ChaincodeStubInterface, account, counterparty string) (bool, string, error) {
+	if counterparty == "" {
+		return false, "", nil
+	}
+	counterpartyKey, err := stub.CreateCompositeKey(counterpartyIndexName, []string{account, counterparty})
+	if err != nil {
+		return false, counterpartyKey, err
+	}
+	seen, err := stub.GetState(counterpartyKey)
+	return seen == nil, counterpartyKey, err
+}
+
+// assessVelocity evaluates the rules against a transfer from an account
+// without recording it. It returns the alert raised, or nil when no rule
+// matched. counterparty may be empty.
+func assessVelocity(stub shim.ChaincodeStubInterface, function, account, counterparty string, amount int, now time.Time) (*VelocityAlert, error) {
+	rules, err := velocityRules(stub)
+	if err != nil {
+		return nil, err
+	}
+	activity, _, err := getActivity(stub, account)
+	if err != nil {
+		return nil, err
+	}
+	newCounterparty, _, err := isNewCounterparty(stub, account, counterparty)
+	if err != nil {
+		return nil, err
+	}
+
+	var alert *VelocityAlert
+	for _, rule := range rules {
+		if !rule.matches(activity, amount, newCounterparty, now) {
+			continue
+		}
+		if alert == nil {
+			alert = &VelocityAlert{TxID: stub.GetTxID(), Function: function, Account: account, Counterparty: counterparty, Amount: amount, Action: VelocityFlag, Timestamp: now.Format(time.RFC3339)}
+		}
+		alert.RuleIDs = append(alert.RuleIDs, rule.ID)
+		if rule.Action == VelocityHold {
+			alert.Action = VelocityHold
+		}
+	}
+	return alert, nil
+}
+
+// recordActivity adds a transfer to an account's counters
+func recordActivity(stub shim.ChaincodeStubInterface, account, counterparty string, amount int, now time.Time) error {
+	rules, err := velocityRules(stub)
+	if err != nil {
+		return err
+	}
+	activity, activityKey, err := getActivity(stub, account)
+	if err != nil {
+		return err
+	}
+	newCounterparty, counterpartyKey, err := isNewCounterparty(stub, account, counterparty)
+	if err != nil {
+		return err
+	}
+
+	window := defaultActivityWindow
+	for _, rule := range rules {
+		if rule.Type == RuleCount && time.Duration(rule.WindowMinutes)*time.Minute > window {
+			window = time.Duration(rule.WindowMinutes) * time.Minute
+		}
+	}
+	since := now.Add(-window).Unix()
+	recent := []int64{now.Unix()}
+	for _, at := range activity.Recent {
+		if at > since {
+			recent = append(recent, at)
+		}
+	}
+	activity.Recent = recent
+	activity.Count++
+	activity.Total += amount
+	activityAsBytes, _ := json.Marshal(activity)
+	if err := stub.PutState(activityKey, activityAsBytes); err != nil {
+		return err
+	}
+	if newCounterparty {
+		return stub.PutState(counterpartyKey, []byte{0x00})
+	}
+	return nil
+}
+
+// checkVelocity evaluates the rules against a transfer from an account,
+// then adds the transfer to the account's counters. It returns the alert
+// raised, or nil when no rule matched. counterparty may be empty.
+func checkVelocity(stub shim.ChaincodeStubInterface, function, account, counterparty string, amount int, now time.Time) (*VelocityAlert, error) {
+	alert, err := assessVelocity(stub, function, account, counterparty, amount, now)
+	if err != nil {
+		return nil, err
+	}
+	return alert, recordActivity(stub, account, counterparty, amount, now)
+}
+
+// enforceVelocity is checkVelocity for a transfer that cannot be held for
+// review: one that matches a HOLD rule fails with VELOCITY_HOLD, and any
+// other alert is raised
+func enforceVelocity(stub shim.ChaincodeStubInterface, function, account, counterparty string, amount int, now time.Time) error {
+	alert, err := checkVelocity(stub, function, account, counterparty, amount, now)
+	if err != nil || alert == nil {
+		return err
+	}
+	if alert.Action == VelocityHold {
+		return fmt.Errorf("%s: %s of %d from %s matched rules %v", VelocityHeld, function, amount, account, alert.RuleIDs)
+	}
+	return raiseAlerts(stub, []VelocityAlert{*alert})
+}
+
+// raiseAlerts stores the alerts raised by a transaction and emits every
+// alert the transaction has raised so far, as a JSON list, in a single
+// chaincode event. It may be called more than once in a transaction.
+func raiseAlerts(stub shim.ChaincodeStubInterface, alerts []VelocityAlert) error {
+	var raised []VelocityAlert
+	for seq := 0; ; seq++ {
+		key, err := stub.CreateCompositeKey(velocityAlertIndexName, []string{stub.GetTxID(), fmt.Sprintf("%03d", seq)})
+		if err != nil {
+			return err
+		}
+		alertAsBytes, err := stub.GetState(key)
+		if err != nil {
+			return err
+		}
+		if alertAsBytes == nil {
+			break
+		}
+		var alert VelocityAlert
+		if err := json.Unmarshal(alertAsBytes, &alert); err != nil {
+			return err
+		}
+		raised = append(raised, alert)
+	}
+	for _, alert := range alerts {
+		key, err := stub.CreateCompositeKey(velocityAlertIndexName, []string{stub.GetTxID(), fmt.Sprintf("%03d", len(raised))})
+		if err != nil {
+			return err
+		}
+		alertAsBytes, _ := json.Marshal(alert)
+		if err := stub.PutState(key, alertAsBytes); err != nil {
+			return err
+		}
+		logger.Infof("%s by %s matched rules %v, %s\n", alert.Function, alert.Account, alert.RuleIDs, alert.Action)
+		raised = append(raised, alert)
+	}
+	alertsAsBytes, _ := json.Marshal(raised)
+	return stub.SetEvent(velocityAlertEvent, alertsAsBytes)
+}
+
+// holdForReview reserves a held transfer's funds for the payee instead of
+// moving them. Compliance captures the hold to let the transfer through, or voids it.
+func holdForReview(stub shim.ChaincodeStubInterface, alert *VelocityAlert, now time.Time) error {
+	hold := Hold{ID: reviewHoldPrefix + alert.TxID, Account: alert.Account, Payee: alert.Counterparty, Amount: alert.Amount, Status: HoldAuthorized, ExpiresAt: now.AddDate(0, 0, reviewHoldDays).Format(time.RFC3339), CreatedAt: now.Format(time.RFC3339), UpdatedAt: now.Format(time.RFC3339)}
+	if err := putHold(stub, hold); err != nil {
+		return err
+	}
+	alert.HoldID = hold.ID
+	return indexHold(stub, hold)
+}
+
+// ==== setVelocityRule =========================================
+// setVelocityRule adds or replaces a velocity or anomaly rule. The rule is a
+// JSON object with the VelocityRule fields that apply to its type. Compliance only.
+// 0
+// '{"id":"burst","type":"COUNT","count":5,"windowminutes":10,"action":"HOLD"}'
+// ===========================================================================================
+func (t *SimpleChaincode) setVelocityRule(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+	officer, err := requireRole(stub, RoleCompliance)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	var rule VelocityRule
+	if err := json.Unmarshal([]byte(args[0]), &rule); err != nil {
+		return shim.Error("Invalid rule, expecting a JSON object")
+	}
+	if rule.ID == "" {
+		return shim.Error("Rule id is required")
+	}
+	switch rule.Type {
+	case RuleCount:
+		if rule.Count <= 0 || rule.WindowMinutes <= 0 {
+			return shim.Error("COUNT rules need a positive count and windowminutes")
+		}
+	case RuleAverageMultiple:
+		if rule.Multiple <= 1 || rule.MinHistory <= 0 {
+			return shim.Error("AVERAGE_MULTIPLE rules need a multiple above 1 and a positive minhistory")
+		}
+	case RuleNewCounterparty:
+		if rule.MinAmount <= 0 {
+			return shim.Error("NEW_COUNTERPARTY rules need a positive minamount")
+		}
+	default:
+		return shim.Error(fmt.Sprintf("Rule type must be one of '%s', '%s' or '%s'. But got: %v", RuleCount, RuleAverageMultiple, RuleNewCounterparty, rule.Type))
+	}
+	if rule.Action != VelocityHold && rule.Action != VelocityFlag {
+		return shim.Error(fmt.Sprintf("Action must be either '%s' or '%s'. But got: %v", VelocityHold, VelocityFlag, rule.Action))
+	}
+
+	now, err := txTime(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	rule.UpdatedBy = officer.ID
+	rule.UpdatedAt = now.Format(time.RFC3339)
+	key, err := stub.CreateCompositeKey(velocityRuleIndexName, []string{rule.ID})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	ruleAsBytes, _ := json.Marshal(rule)
+	if err := stub.PutState(key, ruleAsBytes); err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(ruleAsBytes)
+}
+
+// ==== removeVelocityRule =========================================
+// Compliance only.
+// 0
+// "id"
+// ===========================================================================================
+func (t *SimpleChaincode) removeVelocityRule(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+	if _, err := requireRole(stub, RoleCompliance); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	key, err := stub.CreateCompositeKey(velocityRuleIndexName, []string{args[0]})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	ruleAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if ruleAsBytes == nil {
+		return shim.Error("Rule not found: " + args[0])
+	}
+	if err := stub.DelState(key); err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(nil)
+}
+
+// ==== queryVelocityRules =========================================
+// ===========================================================================================
+func (t *SimpleChaincode) queryVelocityRules(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 0 {
+		return shim.Error("Incorrect number of arguments. Expecting 0")
+	}
+
+	rules, err := velocityRules(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if rules == nil {
+		rules = []VelocityRule{}
+	}
+
+	rulesAsBytes, _ := json.Marshal(rules)
+	return shim.Success(rulesAsBytes)
+}
+
+// ==== queryVelocityAlerts =========================================
+// queryVelocityAlerts lists every transaction that matched a velocity or anomaly rule. Compliance only.
+// ===========================================================================================
+func (t *SimpleChaincode) queryVelocityAlerts(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 0 {
+		return shim.Error("Incorrect number of arguments. Expecting 0")
+	}
+	if _, err := requireRole(stub, RoleCompliance); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(velocityAlertIndexName, []string{})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	alerts := []VelocityAlert{}
+	for resultsIterator.HasNext() {
+		responseRange, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		var alert VelocityAlert
+		json.Unmarshal(responseRange.Value, &alert)
+		alerts = append(alerts, alert)
+	}
+
+	alertsAsBytes, _ := json.Marshal(alerts)
+	return shim.Success(alertsAsBytes)
+}
+
+// ==== releaseHeldPayment =========================================
+// releaseHeldPayment applies a payment held by a velocity rule to the user's
+// bills, as requested when it was created or by the user's allocation rule. Compliance only.
+// 0
+// "paymentid"
+// ===========================================================================================
+func (t *SimpleChaincode) releaseHeldPayment(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+	if _, err := requireRole(stub, RoleCompliance); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	payAsBytes, err := stub.GetState("PAYMENT" + args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if payAsBytes == nil {
+		return shim.Error("Payment not found: " + args[0])
+	}
+	var pay Payment
+	if err := json.Unmarshal(payAsBytes, &pay); err != nil {
+		return shim.Error(err.Error())
+	}
+	if !pay.Held {
+		return shim.Error("Payment is not held: " + pay.ID)
+	}
+
+	pay.Held = false
+	if err := allocatePayment(stub, &pay, pay.Requested); err != nil {
+		return shim.Error(err.Error())
+	}
+	payAsBytes, _ = json.Marshal(pay)
+	if err := stub.PutState("PAYMENT"+pay.ID, payAsBytes); err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := stub.PutState(pay.ID, payAsBytes); err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(payAsBytes)
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestNewCounterpartyRuleHoldsMove(t *testing.T) {
+	s := newTestStub(t)
+	s.grant(RoleCompliance, "officer")
+	s.mustFail("NEW_COUNTERPARTY rules need a positive minamount", "officer", "setVelocityRule", `{"id":"newpayee","type":"NEW_COUNTERPARTY","action":"HOLD"}`)
+	s.mustFail(RoleCompliance, "a", "setVelocityRule", `{"id":"newpayee","type":"NEW_COUNTERPARTY","minamount":50,"action":"HOLD"}`)
+	s.mustInvoke("officer", "setVelocityRule", `{"id":"newpayee","type":"NEW_COUNTERPARTY","minamount":50,"action":"HOLD"}`)
+
+	// The first 60 to b is reserved for review instead of moved
+	var alert VelocityAlert
+	if err := json.Unmarshal(s.mustInvoke("a", "move", "a", "b", "60"), &alert); err != nil {
+		t.Fatal(err)
+	}
+	if alert.Action != VelocityHold || alert.HoldID == "" || len(alert.RuleIDs) != 1 || alert.RuleIDs[0] != "newpayee" {
+		t.Fatalf("move raised %+v, expected a hold by newpayee", alert)
+	}
+	if s.events[velocityAlertEvent] == nil {
+		t.Fatal("no velocity alert event was emitted")
+	}
+	s.expectBalance("b", 1000)
+	s.mustFail("Insufficient available funds in a", "a", "move", "a", "b", "941")
+
+	// b is a known counterparty from then on
+	s.mustInvoke("a", "move", "a", "b", "60")
+	s.expectBalance("b", 1060)
+	var alerts []VelocityAlert
+	if err := json.Unmarshal(s.mustInvoke("officer", "queryVelocityAlerts"), &alerts); err != nil {
+		t.Fatal(err)
+	}
+	if len(alerts) != 1 {
+		t.Fatalf("rules raised %d alerts, expected 1", len(alerts))
+	}
+}
+
+func TestHeldPaymentIsAppliedOnRelease(t *testing.T) {
+	s := newTestStub(t)
+	s.grant(RoleCompliance, "officer")
+	s.biller("acme")
+	s.register("alice", "Alice", "Able", KYCTier1)
+	s.fund("alice", 1000)
+	s.issueBill("b1", "acme", "alice", 100, "2017-11-30")
+	s.mustInvoke("officer", "setVelocityRule", `{"id":"burst","type":"COUNT","count":1,"windowminutes":60,"action":"HOLD"}`)
+
+	s.mustPay("p1", "alice", 50, "")
+	s.mustPay("p2", "alice", 50, "")
+	var pay Payment
+	s.get("PAYMENT"+"p2", &pay)
+	if !pay.Held || pay.Unallocated != 50 || s.bill("b1").PaidAmount != 50 {
+		t.Fatalf("payment p2 is held %v with %d unallocated, expected it held with nothing applied", pay.Held, pay.Unallocated)
+	}
+
+	s.mustFail(RoleCompliance, "alice", "releaseHeldPayment", "p2")
+	s.mustInvoke("officer", "releaseHeldPayment", "p2")
+	s.mustFail("Payment is not held: p2", "officer", "releaseHeldPayment", "p2")
+	if bill := s.bill("b1"); bill.Status != BillPaid {
+		t.Fatalf("b1 is %s after the release, expected %s", bill.Status, BillPaid)
+	}
+}
+
+func TestReviewHoldsAreForCompliance(t *testing.T) {
+	s := newTestStub(t)
+	s.grant(RoleCompliance, "officer")
+	s.mustInvoke("officer", "setVelocityRule", `{"id":"newpayee","type":"NEW_COUNTERPARTY","minamount":50,"action":"HOLD"}`)
+
+	var alert VelocityAlert
+	if err := json.Unmarshal(s.mustInvoke("a", "move", "a", "b", "60"), &alert); err != nil {
+		t.Fatal(err)
+	}
+	if alert.HoldID == "" {
+		t.Fatal("move was not held for review")
+	}
+	s.mustFail("does not hold the", "b", "capture", alert.HoldID, "60")
+	s.mustFail("does not hold the", "a", "capture", alert.HoldID, "60")
+	s.mustFail("does not hold the", "a", "voidHold", alert.HoldID)
+	s.expectBalance("b", 1000)
+
+	s.mustInvoke("officer", "capture", alert.HoldID, "60")
+	s.expectBalance("a", 940)
+	s.expectBalance("b", 1060)
+
+	s.mustFail("reserved for velocity reviews", "a", "authorize", reviewHoldPrefix+"x", "a", "b", "10", "2017-11-20T00:00:00Z")
+}
+
+func TestReleaseReplaysRequestedAllocations(t *testing.T) {
+	s := newTestStub(t)
+	s.grant(RoleCompliance, "officer")
+	s.biller("acme")
+	s.register("alice", "Alice", "Able", KYCTier1)
+	s.fund("alice", 1000)
+	s.issueBill("b1", "acme", "alice", 100, "2017-11-10")
+	s.issueBill("b2", "acme", "alice", 100, "2017-11-30")
+	s.mustInvoke("officer", "setVelocityRule", `{"id":"burst","type":"COUNT","count":1,"windowminutes":60,"action":"HOLD"}`)
+
+	// The move and the payment are counted against the same user, so the payment is the second in the window
+	s.mustInvoke("alice", "move", "alice", "b", "10")
+	s.mustPay("p1", "alice", 50, `[{"billid":"b2","amount":50}]`)
+	var pay Payment
+	s.get("PAYMENT"+"p1", &pay)
+	if !pay.Held {
+		t.Fatal("payment p1 was not held")
+	}
+
+	s.mustFail("does not hold the", "alice", "releaseHeldPayment", "p1")
+	s.mustInvoke("officer", "releaseHeldPayment", "p1")
+	if b1, b2 := s.bill("b1"), s.bill("b2"); b1.PaidAmount != 0 || b2.PaidAmount != 50 {
+		t.Fatalf("release paid %d on b1 and %d on b2, expected 0 and 50", b1.PaidAmount, b2.PaidAmount)
+	}
+}
+
+func TestTransfersInOneTransactionRaiseEveryAlert(t *testing.T) {
+	s := newTestStub(t)
+	s.grant(RoleCompliance, "officer")
+	s.fund("c", 0)
+	s.mustInvoke("officer", "setVelocityRule", `{"id":"newpayee","type":"NEW_COUNTERPARTY","minamount":50,"action":"FLAG"}`)
+	s.mustInvoke("a", "createStandingOrder", "so1", "a", "b", "60", FrequencyMonthly, "2017-11-01", "", "0")
+	s.mustInvoke("a", "createStandingOrder", "so2", "a", "c", "60", FrequencyMonthly, "2017-11-01", "", "0")
+
+	s.mustInvoke("keeper", "processDue")
+	var alerts []VelocityAlert
+	if err := json.Unmarshal(s.events[velocityAlertEvent], &alerts); err != nil {
+		t.Fatal(err)
+	}
+	if len(alerts) != 2 || alerts[0].Counterparty == alerts[1].Counterparty {
+		t.Fatalf("processDue emitted %v, expected an alert for each order", alerts)
+	}
+	s.expectBalance("a", 880)
+}
+
+func TestTransfersThatWouldBeHeldAreRefused(t *testing.T) {
+	s := newTestStub(t)
+	s.grant(RoleCompliance, "officer")
+	s.mustInvoke("officer", "setVelocityRule", `{"id":"newpayee","type":"NEW_COUNTERPARTY","minamount":50,"action":"HOLD"}`)
+	s.mustInvoke("a", "approve", "b", "500", "2017-11-20T00:00:00Z")
+	s.mustFail(VelocityHeld, "b", "transferFrom", "a", "b", "60")
+
+	// A failed standing order payment leaves the account's counters alone
+	s.mustInvoke("a", "createStandingOrder", "so1", "a", "b", "60", FrequencyMonthly, "2017-11-01", "", "0")
+	s.mustInvoke("keeper", "processDue")
+	s.expectBalance("a", 1000)
+	if s.State[s.compositeKey(accountActivityIndexName, "a")] != nil {
+		t.Fatal("processDue recorded activity for a refused transfer")
+	}
+}